@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	structures "BlueDevil-Engine/structures"
+)
+
+// agentClient talks to the web server's /api/agent endpoints.
+type agentClient struct {
+	baseURL string
+	token   string
+	http    *http.Client
+}
+
+// runAgent polls the server for assignments and reports the results of every
+// check slot that has come due until ctx is cancelled.
+func runAgent(ctx context.Context, serverURL, token string) {
+	c := &agentClient{
+		baseURL: strings.TrimSuffix(serverURL, "/"),
+		token:   token,
+		http:    &http.Client{Timeout: 30 * time.Second},
+	}
+	log.Println("scoring agent started, reporting to", c.baseURL)
+	due := newScheduler()
+	lastRound := 0
+	var wg sync.WaitGroup
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		assignment, err := c.assignments(ctx)
+		if err != nil {
+			log.Println("agent: failed to fetch assignments:", err)
+		} else {
+			if assignment.Round < lastRound {
+				// the competition was restarted and numbering begins again
+				due = newScheduler()
+			}
+			lastRound = assignment.Round
+			if batch := due.take(assignment.Boxes); len(batch) > 0 {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					results := runBoxes(ctx, batch)
+					if err := c.report(ctx, results); err != nil {
+						log.Printf("agent: failed to report round %d: %v", batch[0].Round, err)
+					} else {
+						log.Printf("agent: round %d reported %d checks", batch[0].Round, len(results))
+					}
+				}()
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			log.Println("scoring agent stopped")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (c *agentClient) do(ctx context.Context, method, path string, body interface{}, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(b)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	if out != nil {
+		return json.NewDecoder(resp.Body).Decode(out)
+	}
+	return nil
+}
+
+func (c *agentClient) assignments(ctx context.Context) (*structures.AgentAssignment, error) {
+	var a structures.AgentAssignment
+	if err := c.do(ctx, http.MethodGet, "/api/agent/assignments", nil, &a); err != nil {
+		return nil, err
+	}
+	return &a, nil
+}
+
+func (c *agentClient) report(ctx context.Context, results []structures.CheckResult) error {
+	if len(results) == 0 {
+		return nil
+	}
+	return c.do(ctx, http.MethodPost, "/api/agent/results", results, nil)
+}
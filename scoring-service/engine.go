@@ -0,0 +1,346 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+
+	"BlueDevil-Engine/scoring"
+	sql_wrapper "BlueDevil-Engine/sql"
+	structures "BlueDevil-Engine/structures"
+)
+
+// pollInterval is how often agents ask the server for checks that are due.
+const pollInterval = 5 * time.Second
+
+// engineTick is how often the engine looks for checks that are due. Services
+// can have intervals shorter than a round, so this is finer than pollInterval.
+const engineTick = time.Second
+
+// engine checks every box that is not assigned to a remote agent on each
+// service's own schedule. Several instances may run against one database; only
+// the one holding the leader lease schedules checks.
+type engine struct {
+	instance string
+	started  time.Time
+	leader   bool
+
+	due scheduler
+	// targets are reloaded at the start of every round so service and box
+	// changes take effect without a restart
+	targets      []structures.AgentBox
+	services     []structures.Service
+	targetsRound int
+	// seed orders the current round's checks; it is stored with the round
+	seed int64
+	// record serializes writing results so concurrent batches do not contend
+	// for the database. It also guards pending.
+	record sync.Mutex
+	// pending counts the batches still running for each open round; a round
+	// is finished once it is over and none are left
+	pending map[int]int
+	failed  map[int]bool
+	wg      sync.WaitGroup
+}
+
+// runEngine checks boxes as their slots come due until ctx is cancelled, then
+// waits for checks in flight to be recorded. While another instance holds the
+// leader lease it stands by and takes over if the lease expires.
+func runEngine(ctx context.Context, instance string) {
+	log.Printf("scoring engine %s started", instance)
+	e := &engine{instance: instance, started: time.Now()}
+	e.reset()
+	ticker := time.NewTicker(engineTick)
+	defer ticker.Stop()
+	for {
+		leader, err := e.lead(time.Now())
+		if err != nil {
+			log.Println("engine:", err)
+		}
+		metricLeader.Set(boolGauge(leader))
+		if leader {
+			if err := e.tick(ctx); err != nil {
+				log.Println("engine:", err)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			e.wg.Wait()
+			if e.leader {
+				if err := sql_wrapper.ReleaseEngineLease(e.instance); err != nil {
+					log.Println("engine: releasing lease:", err)
+				}
+			}
+			log.Println("scoring engine stopped")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// reset forgets the schedule and open rounds, for a fresh start or after
+// leadership passes to another instance. It first waits for batches in
+// flight, which count down pending for their rounds.
+func (e *engine) reset() {
+	e.wg.Wait()
+	e.due = newScheduler()
+	e.targetsRound = 0
+	e.record.Lock()
+	e.pending = make(map[int]int)
+	e.failed = make(map[int]bool)
+	e.record.Unlock()
+}
+
+// tick starts a batch for every box whose service has entered a new slot.
+func (e *engine) tick(ctx context.Context) error {
+	comp, err := sql_wrapper.GetCompetition()
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	round := scoring.RoundAt(comp, now)
+	if round == 0 {
+		if e.targetsRound == 0 {
+			return nil
+		}
+		// close the last round once the competition stops
+		return e.finishRounds(math.MaxInt)
+	}
+	if round < e.targetsRound {
+		// the competition was restarted and numbering begins again
+		e.reset()
+	}
+	if round != e.targetsRound {
+		if err := e.loadTargets(); err != nil {
+			return err
+		}
+		if e.targetsRound == 0 {
+			// rounds left running by a previous engine never finished; a
+			// deposed leader cannot record the rest, as RecordResults checks
+			// the lease
+			if err := sql_wrapper.MarkIncompleteRounds(round); err != nil {
+				return err
+			}
+		}
+		e.seed = rand.Int63()
+		if err := sql_wrapper.StartRound(round, scoring.RoundStart(comp, round).Format(time.RFC3339), e.seed); err != nil {
+			return err
+		}
+		if phase := scoring.RoundPhase(comp, round); phase != scoring.RoundPhase(comp, e.targetsRound) || e.targetsRound == 0 {
+			log.Printf("engine: round %d is in the %s phase", round, phase)
+		}
+		e.record.Lock()
+		if _, ok := e.pending[round]; !ok {
+			e.pending[round] = 0
+		}
+		e.record.Unlock()
+		e.targetsRound = round
+		metricRound.Set(float64(round))
+	}
+	if err := e.finishRounds(round); err != nil {
+		return err
+	}
+
+	boxes := make([]structures.AgentBox, len(e.targets))
+	copy(boxes, e.targets)
+	for i := range boxes {
+		boxes[i].Slot = scoring.SlotAt(comp, boxes[i].Service, now)
+		boxes[i].Round = scoring.RoundForSlot(comp, boxes[i].Service, boxes[i].Slot)
+	}
+	batch := e.due.take(boxes)
+	if len(batch) == 0 {
+		return nil
+	}
+	// the round's seed fixes the order checks are started in, so load on
+	// team boxes is not predictable but a round can be replayed
+	r := rand.New(rand.NewSource(e.seed + int64(batch[0].Slot)))
+	r.Shuffle(len(batch), func(i, j int) { batch[i], batch[j] = batch[j], batch[i] })
+
+	e.record.Lock()
+	e.pending[round]++
+	e.record.Unlock()
+	e.wg.Add(1)
+	go func() {
+		defer e.wg.Done()
+		err := e.score(ctx, batch)
+		if errors.Is(err, sql_wrapper.ErrNotLeader) {
+			// the round is now the new leader's to record
+			log.Printf("engine: round %d: discarded %d checks after losing the lease", round, len(batch))
+			err = nil
+		} else if err != nil {
+			log.Printf("engine: round %d failed: %v", round, err)
+		}
+		e.record.Lock()
+		e.pending[round]--
+		if err != nil {
+			metricBatchErrors.Inc()
+			e.failed[round] = true
+			if err := sql_wrapper.RecordRoundError(round); err != nil {
+				log.Println("engine:", err)
+			}
+		}
+		e.record.Unlock()
+	}()
+	return nil
+}
+
+// finishRounds closes every round before current whose batches have all been
+// recorded. That includes rounds the engine did not start itself, which get a
+// row when a late slot or an agent records results for them.
+func (e *engine) finishRounds(current int) error {
+	e.record.Lock()
+	defer e.record.Unlock()
+	open, err := sql_wrapper.GetOpenRounds(current)
+	if err != nil {
+		return err
+	}
+	for _, round := range open {
+		if _, ok := e.pending[round]; !ok {
+			e.pending[round] = 0
+		}
+	}
+	for round, n := range e.pending {
+		if round >= current || n > 0 {
+			continue
+		}
+		status := sql_wrapper.RoundComplete
+		if e.failed[round] {
+			status = sql_wrapper.RoundFailed
+		}
+		if err := sql_wrapper.FinishRound(round, time.Now().UTC().Format(time.RFC3339), status); err != nil {
+			return err
+		}
+		delete(e.pending, round)
+		delete(e.failed, round)
+	}
+	return nil
+}
+
+func (e *engine) loadTargets() error {
+	boxes, err := sql_wrapper.GetAllScoringBoxes()
+	if err != nil {
+		return err
+	}
+	services, err := sql_wrapper.GetAllServices()
+	if err != nil {
+		return err
+	}
+	teams, err := sql_wrapper.GetAllTeams()
+	if err != nil {
+		return err
+	}
+	baselines, err := sql_wrapper.GetBaselines(0, true)
+	if err != nil {
+		return err
+	}
+	// boxes assigned to a remote agent are checked by that agent
+	e.targets = scoring.BuildBoxes(boxes, services, teams, baselines, func(b structures.ScoringBox) bool {
+		return b.AgentID == nil
+	})
+	e.services = services
+	return nil
+}
+
+// score runs a batch of checks concurrently and records the results.
+func (e *engine) score(ctx context.Context, batch []structures.AgentBox) error {
+	started := time.Now()
+	results := runBoxes(ctx, batch)
+
+	e.record.Lock()
+	defer e.record.Unlock()
+
+	comp, err := sql_wrapper.GetCompetition()
+	if err != nil {
+		return err
+	}
+	var recorded []structures.CheckResult
+	if len(results) > 0 {
+		first, last := results[0].Round, results[0].Round
+		for _, res := range results {
+			first = min(first, res.Round)
+			last = max(last, res.Round)
+		}
+		// dependencies checked less often may last have run rounds earlier
+		recorded, err = sql_wrapper.GetRoundRangeResults(first-scoring.DependencyLookback(comp, e.services), last)
+		if err != nil {
+			return err
+		}
+	}
+	scoring.ApplyDependencies(results, recorded, e.services, comp)
+
+	svcByID := make(map[int]structures.Service)
+	for _, b := range batch {
+		svcByID[b.Service.ID] = b.Service
+	}
+	var record []structures.CheckResult
+	var points []int
+	for _, res := range results {
+		exists, err := sql_wrapper.HasServiceResult(res.TeamID, res.ServiceID, res.Round, res.Slot)
+		if err != nil {
+			return err
+		}
+		if exists {
+			continue
+		}
+		res.Output = scoring.PrepareOutput(res.TeamID, res.Output)
+		scoring.PrepareEvidence(res.TeamID, res.Evidence)
+		record = append(record, res)
+		points = append(points, scoring.PointsForResult(res, svcByID[res.ServiceID], comp))
+	}
+	stored, err := sql_wrapper.RecordResults(record, points, e.instance)
+	if err != nil {
+		return err
+	}
+	if err := sql_wrapper.RecordLeaderRound(e.instance, batch[0].Round, time.Now()); err != nil {
+		return err
+	}
+	log.Printf("engine: round %d scored %d checks in %s", batch[0].Round, stored, time.Since(started).Round(time.Millisecond))
+	return nil
+}
+
+// scheduler remembers the last slot started for each team/service so every
+// slot is checked exactly once. It is shared by the engine and agent modes.
+type scheduler struct {
+	last map[[2]int]int
+}
+
+func newScheduler() scheduler {
+	return scheduler{last: make(map[[2]int]int)}
+}
+
+// take returns the boxes whose slot has not been started yet and marks them
+// started.
+func (s scheduler) take(boxes []structures.AgentBox) []structures.AgentBox {
+	var due []structures.AgentBox
+	for _, b := range boxes {
+		key := [2]int{b.TeamID, b.Service.ID}
+		if b.Slot <= 0 || b.Slot <= s.last[key] {
+			continue
+		}
+		s.last[key] = b.Slot
+		due = append(due, b)
+	}
+	return due
+}
+
+// runBoxes runs the checks for each box in parallel. It is shared by the engine
+// and agent modes.
+func runBoxes(ctx context.Context, boxes []structures.AgentBox) []structures.CheckResult {
+	results := make([]structures.CheckResult, len(boxes))
+	var wg sync.WaitGroup
+	for i, b := range boxes {
+		wg.Add(1)
+		go func(i int, b structures.AgentBox) {
+			defer wg.Done()
+			results[i] = scoring.RunService(ctx, b)
+		}(i, b)
+	}
+	wg.Wait()
+	observeResults(boxes, results)
+	return results
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"time"
+
+	"BlueDevil-Engine/scoring"
+	sql_wrapper "BlueDevil-Engine/sql"
+)
+
+// maxLeaseTTL is how long the leader lease lasts without a renewal. The lease
+// is renewed every engineTick, and is never longer than half a round so a
+// standby instance takes over within one round interval.
+const maxLeaseTTL = 15 * time.Second
+
+// instanceRetention is how long instances that stopped heartbeating are kept
+// for the admin dashboard.
+const instanceRetention = 24 * time.Hour
+
+// defaultInstanceID names this process when -instance is not given.
+func defaultInstanceID() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "engine"
+	}
+	return fmt.Sprintf("%s-%d-%04x", host, os.Getpid(), rand.Intn(1<<16))
+}
+
+// leaseTTL returns how long a lease lasts for the competition's round length.
+func leaseTTL(roundInterval time.Duration) time.Duration {
+	return min(maxLeaseTTL, roundInterval/2)
+}
+
+// lead heartbeats this instance and takes or renews the leader lease. It
+// returns whether this instance should schedule checks. A leader that cannot
+// renew its lease stands by, as another instance may take over once it
+// expires.
+func (e *engine) lead(now time.Time) (bool, error) {
+	host, _ := os.Hostname()
+	if err := sql_wrapper.HeartbeatEngine(e.instance, host, e.started, now); err != nil {
+		e.standBy()
+		return false, err
+	}
+	comp, err := sql_wrapper.GetCompetition()
+	if err != nil {
+		e.standBy()
+		return false, err
+	}
+	leader, err := sql_wrapper.AcquireEngineLease(e.instance, now, leaseTTL(scoring.RoundInterval(comp)))
+	if err != nil {
+		e.standBy()
+		return false, err
+	}
+	if !leader {
+		e.standBy()
+		return false, nil
+	}
+	if !e.leader {
+		log.Printf("engine %s: became leader", e.instance)
+		if err := sql_wrapper.PruneEngineInstances(now.Add(-instanceRetention)); err != nil {
+			log.Println("engine: pruning instances:", err)
+		}
+		e.leader = true
+	}
+	return true, nil
+}
+
+// standBy gives up leadership, if held, once the batches in flight are done.
+// Their results are refused by RecordResults, as the lease is no longer ours.
+func (e *engine) standBy() {
+	if !e.leader {
+		return
+	}
+	log.Printf("engine %s: lost leadership, standing by", e.instance)
+	e.leader = false
+	e.reset()
+}
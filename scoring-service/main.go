@@ -1 +1,70 @@
-package scoringservice
+package main
+
+// scoring-service runs the service checks for a competition. By default it is
+// the central scoring engine and talks to the database directly. With -agent it
+// runs as a remote scoring agent that fetches its assigned boxes from the web
+// server and posts results back, for networks the engine cannot reach.
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	sql_wrapper "BlueDevil-Engine/sql"
+)
+
+func main() {
+	agentMode := flag.Bool("agent", false, "run as a remote scoring agent instead of the central engine")
+	serverURL := flag.String("server", os.Getenv("SCORING_SERVER_URL"), "web server base URL (agent mode)")
+	token := flag.String("token", os.Getenv("SCORING_AGENT_TOKEN"), "agent token issued by the admin dashboard (agent mode)")
+	instance := flag.String("instance", os.Getenv("SCORING_INSTANCE"), "name of this engine instance for leader election (default host-pid-random)")
+	metricsAddr := flag.String("metrics", os.Getenv("SCORING_METRICS_ADDR"), "address to serve Prometheus metrics on, e.g. :9101 (default off)")
+	flag.Parse()
+
+	if *metricsAddr != "" {
+		serveMetrics(*metricsAddr)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if *agentMode {
+		if *serverURL == "" || *token == "" {
+			log.Fatal("agent mode requires -server and -token (or SCORING_SERVER_URL and SCORING_AGENT_TOKEN)")
+		}
+		runAgent(ctx, *serverURL, *token)
+		return
+	}
+
+	// Same database settings as the web server
+	dbDriver := os.Getenv("DB_DRIVER")
+	if dbDriver == "" {
+		dbDriver = os.Getenv("DATABASE_DRIVER")
+	}
+	if dbDriver == "" {
+		dbDriver = "sqlite3"
+	}
+
+	dbPath := os.Getenv("DB_PATH")
+	if dbPath == "" {
+		dbPath = os.Getenv("DATABASE_DSN")
+	}
+	if dbPath == "" {
+		dbPath = "./blue_devil.db"
+	}
+	if err := sql_wrapper.InitDB(dbDriver, dbPath); err != nil {
+		log.Fatal(err)
+	}
+	if err := sql_wrapper.CreateTables(); err != nil {
+		log.Fatal(err)
+	}
+	defer sql_wrapper.CloseDB()
+
+	if *instance == "" {
+		*instance = defaultInstanceID()
+	}
+	runEngine(ctx, *instance)
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	"BlueDevil-Engine/metrics"
+	structures "BlueDevil-Engine/structures"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Metrics of the checks this process runs, served with -metrics. The web
+// server's /metrics covers the competition as recorded in the database.
+var (
+	metricLeader = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "bluedevil_engine_leader",
+		Help: "1 while this engine instance holds the leader lease.",
+	})
+	metricRound = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "bluedevil_engine_round",
+		Help: "The round this engine is scheduling checks for.",
+	})
+	metricChecks = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "bluedevil_engine_checks_total",
+		Help: "Checks run by this process, by team ID, service and result (up or down).",
+	}, []string{"team_id", "service", "result"})
+	metricTimeouts = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "bluedevil_engine_check_timeouts_total",
+		Help: "Checks that ran past their timeout, by service.",
+	}, []string{"service"})
+	metricCheckDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "bluedevil_engine_check_duration_seconds",
+		Help:    "Time taken by each check, retries included, by service.",
+		Buckets: []float64{.05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60},
+	}, []string{"service"})
+	metricServiceUp = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "bluedevil_engine_service_up",
+		Help: "1 when this process's latest check of a team's service passed, 0 when it failed.",
+	}, []string{"team_id", "service"})
+	metricBatchErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "bluedevil_engine_batch_errors_total",
+		Help: "Batches of checks that could not be recorded.",
+	})
+)
+
+// observeResults records the outcome of a batch of checks.
+func observeResults(boxes []structures.AgentBox, results []structures.CheckResult) {
+	for i, res := range results {
+		// teams are labelled by ID so their names stay off this endpoint
+		team := strconv.Itoa(res.TeamID)
+		svc := boxes[i].Service.Name
+		result := "down"
+		if res.IsUp {
+			result = "up"
+		}
+		metricChecks.WithLabelValues(team, svc, result).Inc()
+		metricServiceUp.WithLabelValues(team, svc).Set(boolGauge(res.IsUp))
+		for _, ev := range res.Evidence {
+			metricCheckDuration.WithLabelValues(svc).Observe(float64(ev.DurationMs()) / 1000)
+		}
+		if res.TimedOut {
+			metricTimeouts.WithLabelValues(svc).Inc()
+		}
+	}
+}
+
+func boolGauge(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// serveMetrics serves /metrics on addr in the background.
+func serveMetrics(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	go func() {
+		log.Printf("metrics served at http://%s/metrics", addr)
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			log.Println("metrics:", err)
+		}
+	}()
+}
@@ -1,11 +1,19 @@
 package config
 
 import (
+	"bytes"
 	"encoding/json"
 	"log"
 	"os"
 	"path/filepath"
 	"runtime"
+	"strconv"
+	"strings"
+	"text/template"
+
+	structures "BlueDevil-Engine/structures"
+
+	sprig "github.com/Masterminds/sprig/v3"
 )
 
 type ServiceIP struct {
@@ -47,6 +55,14 @@ type Config struct {
 	ServiceIPScheme   []ServiceIP        `json:"service_ip_scheme"`
 	DefaultPasswords  []DefaultPassword  `json:"default_passwords"`
 	EnvLoginTemplates []EnvLoginTemplate `json:"env_logins_templates"`
+	// RedactPatterns are regexes masked in stored check output. When a
+	// pattern has capture groups only the groups are masked.
+	RedactPatterns []string `json:"redact_patterns,omitempty"`
+	// TeamRedactPatterns are also masked when check output is shown to
+	// teams, e.g. addresses of the scoring network
+	TeamRedactPatterns []string `json:"team_redact_patterns,omitempty"`
+	// MaxOutputBytes caps stored check output (default 64 KiB)
+	MaxOutputBytes int `json:"max_output_bytes,omitempty"`
 }
 
 var Global Config
@@ -55,6 +71,88 @@ func GetConfig() Config {
 	return Global
 }
 
+// TeamCredentials returns the logins scripted checks can look up for a team.
+// Environment logins are keyed by service name with the {team} placeholder
+// filled in; default passwords are keyed by box name.
+func TeamCredentials(teamID int) []structures.Credential {
+	team := strconv.Itoa(teamID)
+	var out []structures.Credential
+	for _, t := range Global.EnvLoginTemplates {
+		out = append(out, structures.Credential{
+			ID:       t.Service,
+			Username: strings.ReplaceAll(t.UsernameTemplate, "{team}", team),
+			Password: strings.ReplaceAll(t.PasswordTemplate, "{team}", team),
+		})
+	}
+	for _, dp := range Global.DefaultPasswords {
+		for _, l := range dp.Logins {
+			out = append(out, structures.Credential{ID: dp.Box, Username: l.Username, Password: l.Password})
+		}
+	}
+	return out
+}
+
+// NatAddress returns a team's NAT address for a service in the IP scheme, or
+// "" if the scheme gives none. NatTemplate is rendered with the sprig
+// functions and a team function; otherwise the address is built from the
+// prefix, base and suffix.
+func NatAddress(si ServiceIP, teamID int) (string, error) {
+	if si.NatTemplate != "" {
+		funcs := sprig.TxtFuncMap()
+		// add aliases for multiplication helper names (sprig uses "mul")
+		if m, ok := funcs["mul"]; ok {
+			funcs["multiply"] = m
+			funcs["times"] = m
+			funcs["mult"] = m
+		}
+		funcs["team"] = func() int { return teamID }
+		funcs["Team"] = func() int { return teamID }
+		t, err := template.New("nat").Funcs(funcs).Parse(si.NatTemplate)
+		if err != nil {
+			return "", err
+		}
+		var b bytes.Buffer
+		if err := t.Execute(&b, nil); err != nil {
+			return "", err
+		}
+		return b.String(), nil
+	}
+	if si.NatPrefix != "" {
+		return si.NatPrefix + "." + strconv.Itoa(si.NatBase+teamID) + "." + strconv.Itoa(si.NatSuffix), nil
+	}
+	return "", nil
+}
+
+// TeamAddresses returns a team's address for each service in the IP scheme:
+// its NAT address, or the internal IP when the scheme gives no NAT.
+func TeamAddresses(teamID int) map[string]string {
+	out := make(map[string]string)
+	for _, si := range Global.ServiceIPScheme {
+		addr, err := NatAddress(si, teamID)
+		if err != nil {
+			log.Printf("config: nat address for %s: %v", si.Service, err)
+		}
+		if addr == "" {
+			addr = si.InternalIP
+		}
+		out[si.Service] = addr
+	}
+	return out
+}
+
+// TeamURLs returns a team's environment login URLs keyed by service name, with
+// the {team} placeholder filled in.
+func TeamURLs(teamID int) map[string]string {
+	team := strconv.Itoa(teamID)
+	out := make(map[string]string)
+	for _, t := range Global.EnvLoginTemplates {
+		if t.URLTemplate != "" {
+			out[t.Service] = strings.ReplaceAll(t.URLTemplate, "{team}", team)
+		}
+	}
+	return out
+}
+
 func init() {
 	// Try a few likely locations for envinfo.json: same dir, parent, or repo root
 	_, filename, _, ok := runtime.Caller(0)
@@ -0,0 +1,54 @@
+package config
+
+import "testing"
+
+func TestNatAddress(t *testing.T) {
+	tests := []struct {
+		name string
+		si   ServiceIP
+		want string
+	}{
+		{"template", ServiceIP{NatTemplate: "10.10.{{ add 39 team }}.9"}, "10.10.46.9"},
+		{"template with an alias", ServiceIP{NatTemplate: "10.{{ multiply team 2 }}.0.1"}, "10.14.0.1"},
+		{"prefix, base and suffix", ServiceIP{NatPrefix: "10.10", NatBase: 39, NatSuffix: 10}, "10.10.46.10"},
+		{"template wins", ServiceIP{NatTemplate: "192.168.{{ team }}.1", NatPrefix: "10.10"}, "192.168.7.1"},
+		{"no NAT", ServiceIP{InternalIP: "172.20.240.10"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NatAddress(tt.si, 7)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("NatAddress() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+	if _, err := NatAddress(ServiceIP{NatTemplate: "{{ nope }}"}, 7); err == nil {
+		t.Error("NatAddress() accepted an unknown template function")
+	}
+}
+
+func TestTeamAddresses(t *testing.T) {
+	saved := Global
+	t.Cleanup(func() { Global = saved })
+	Global = Config{
+		ServiceIPScheme: []ServiceIP{
+			{Service: "Web", InternalIP: "172.20.242.10", NatTemplate: "10.10.{{ add 39 team }}.9"},
+			{Service: "DC", InternalIP: "172.20.240.10"},
+		},
+		EnvLoginTemplates: []EnvLoginTemplate{
+			{Service: "Shop", URLTemplate: "http://shop.team{team}.local"},
+			{Service: "Mail"},
+		},
+	}
+	addrs := TeamAddresses(7)
+	if len(addrs) != 2 || addrs["Web"] != "10.10.46.9" || addrs["DC"] != "172.20.240.10" {
+		t.Errorf("TeamAddresses() = %v", addrs)
+	}
+	urls := TeamURLs(7)
+	if len(urls) != 1 || urls["Shop"] != "http://shop.team7.local" {
+		t.Errorf("TeamURLs() = %v", urls)
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"os"
 	"strings"
+	"time"
 
 	"crypto/rand"
 	"encoding/base64"
@@ -15,6 +16,7 @@ import (
 	"github.com/joho/godotenv"
 	"golang.org/x/oauth2"
 
+	"BlueDevil-Engine/metrics"
 	sql_wrapper "BlueDevil-Engine/sql"
 	structures "BlueDevil-Engine/structures"
 	webpages "BlueDevil-Engine/webpages"
@@ -102,6 +104,9 @@ func main() {
 	// Ensure the database is closed on exit
 	defer sql_wrapper.CloseDB()
 
+	// Push scoreboard updates to homepage viewers
+	go webpages.WatchEvents(context.Background())
+
 	ctx := context.Background()
 
 	var err error
@@ -141,12 +146,20 @@ func main() {
 		http.Redirect(w, r, "/", http.StatusFound)
 	})
 
-	http.HandleFunc("/scoreboard", handleScoreboard)
+	http.HandleFunc("/scoreboard", webpages.HandleScoreboard)
+	http.HandleFunc("/api/scoreboard", webpages.HandleApiScoreboard)
+	http.HandleFunc("/api/events", webpages.HandleEvents)
+	http.HandleFunc("/api/heatmap", webpages.HandleApiHeatmap)
+	// Prometheus metrics, behind METRICS_TOKEN when it is set
+	http.HandleFunc("/metrics", webpages.HandleMetrics)
 
 	// Public standalone info page (derived from homepage)
 	// Use AuthPromptMiddleware so unauthenticated users see a friendly login prompt
 	http.Handle("/info", AuthPromptMiddleware(http.HandlerFunc(webpages.HandleInfoPage)))
 
+	// Team score breakdown (admins can view any team)
+	http.Handle("/team", AuthPromptMiddleware(http.HandlerFunc(webpages.HandleTeamPage)))
+
 	// User-facing inject submission page (must be logged in)
 	http.Handle("/injects/submit", AuthMiddleware(http.HandlerFunc(webpages.HandleUserInjectPage)))
 
@@ -158,6 +171,8 @@ func main() {
 	http.Handle("/admin/box-mapping", AuthMiddleware(AdminAuthMiddleware(http.HandlerFunc(webpages.HandleManageMappings))))
 	http.Handle("/admin/scores", AuthMiddleware(AdminAuthMiddleware(http.HandlerFunc(webpages.HandleManageScoring))))
 	http.Handle("/admin/injects", AuthMiddleware(AdminAuthMiddleware(http.HandlerFunc(webpages.HandleManageInjects))))
+	http.Handle("/admin/agents", AuthMiddleware(AdminAuthMiddleware(http.HandlerFunc(webpages.HandleManageAgents))))
+	http.Handle("/admin/plugins", AuthMiddleware(AdminAuthMiddleware(http.HandlerFunc(webpages.HandleManagePlugins))))
 	http.Handle("/admin/competitions", AuthMiddleware(AdminAuthMiddleware(http.HandlerFunc(webpages.HandleCompetitionSettings))))
 
 	// everything that starts with /api/admin send it to the admin api handlers
@@ -170,9 +185,26 @@ func main() {
 
 	http.Handle("/api/admin/score-history", AuthMiddleware(AdminAuthMiddleware(http.HandlerFunc(webpages.HandleApiScoreHistory))))
 	http.Handle("/api/admin/score-adjust", AuthMiddleware(AdminAuthMiddleware(http.HandlerFunc(webpages.HandleApiScoreAdjust))))
+	http.Handle("/api/admin/score-override", AuthMiddleware(AdminAuthMiddleware(http.HandlerFunc(webpages.HandleApiScoreOverride))))
 
 	http.Handle("/api/admin/team-members", AuthMiddleware(AdminAuthMiddleware(http.HandlerFunc(webpages.HandleTeamMembers))))
 
+	// Scoring agent management
+	http.Handle("/api/admin/agents", AuthMiddleware(AdminAuthMiddleware(http.HandlerFunc(webpages.HandleApiAgents))))
+	http.Handle("/api/admin/agents/assign", AuthMiddleware(AdminAuthMiddleware(http.HandlerFunc(webpages.HandleApiAgentAssign))))
+
+	// External check plugin registry
+	http.Handle("/api/admin/plugins", AuthMiddleware(AdminAuthMiddleware(http.HandlerFunc(webpages.HandleApiPlugins))))
+	http.Handle("/api/admin/baselines", AuthMiddleware(AdminAuthMiddleware(http.HandlerFunc(webpages.HandleApiBaselines))))
+	http.Handle("/api/admin/rounds", AuthMiddleware(AdminAuthMiddleware(http.HandlerFunc(webpages.HandleApiRounds))))
+	http.Handle("/api/admin/engine", AuthMiddleware(AdminAuthMiddleware(http.HandlerFunc(webpages.HandleApiEngine))))
+	http.Handle("/api/admin/rescore", AuthMiddleware(AdminAuthMiddleware(http.HandlerFunc(webpages.HandleApiRescore))))
+	http.Handle("/api/admin/report", AuthMiddleware(AdminAuthMiddleware(http.HandlerFunc(webpages.HandleApiReport))))
+
+	// Scoring agent API (authenticated with per-agent bearer tokens)
+	http.Handle("/api/agent/assignments", AgentAuthMiddleware(http.HandlerFunc(webpages.HandleAgentAssignments)))
+	http.Handle("/api/agent/results", AgentAuthMiddleware(http.HandlerFunc(webpages.HandleAgentResults)))
+
 	// Inject APIs
 	http.Handle("/api/admin/injects", AuthMiddleware(AdminAuthMiddleware(http.HandlerFunc(webpages.HandleApiInjects))))
 	http.Handle("/api/admin/injects/upload", AuthMiddleware(AdminAuthMiddleware(http.HandlerFunc(webpages.HandleApiInjectUpload))))
@@ -229,7 +261,7 @@ func main() {
 	http.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir("static"))))
 
 	fmt.Println("Server started at http://localhost:8000")
-	log.Fatal(http.ListenAndServe(":8000", nil))
+	log.Fatal(http.ListenAndServe(":8000", metrics.InstrumentMux(http.DefaultServeMux)))
 }
 
 func handleLoginUser(w http.ResponseWriter, r *http.Request) {
@@ -397,10 +429,6 @@ func handleDashboard(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintf(w, "Welcome, %s!", user.Name)
 }
 
-func handleScoreboard(w http.ResponseWriter, r *http.Request) {
-	fmt.Fprintf(w, "This is the public scoreboard.")
-}
-
 func AuthMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Set a cookie for redirect after login
@@ -552,6 +580,36 @@ func AuthPromptMiddleware(next http.Handler) http.Handler {
 	})
 }
 
+// AgentAuthMiddleware authenticates scoring agents by the bearer token they were
+// issued when created. Every authenticated request also refreshes the agent's
+// last-seen time so admins can tell which agents are alive.
+func AgentAuthMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if token == "" || token == r.Header.Get("Authorization") {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		agent, err := sql_wrapper.GetAgentByTokenHash(webpages.HashAgentToken(token))
+		if err != nil {
+			http.Error(w, "Failed to look up agent: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if agent == nil {
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		if err := sql_wrapper.TouchAgent(agent.ID, time.Now().UTC().Format(time.RFC3339), r.RemoteAddr); err != nil {
+			log.Printf("agent %s: failed to update last seen: %v", agent.Name, err)
+		}
+
+		ctx := context.WithValue(r.Context(), webpages.CtxAgentKey, agent)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
 func AdminAuthMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		user := r.Context().Value(webpages.CtxUserKey).(structures.User)
@@ -0,0 +1,15 @@
+// Package metrics serves the Prometheus metrics that the web server and the
+// scoring service register with the client library's default registry, and
+// instruments the web server's requests.
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Handler serves every registered metric in the Prometheus text format.
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
@@ -0,0 +1,68 @@
+package metrics
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestInstrumentMux(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/teams/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if r.PathValue("id") == "0" {
+			http.Error(w, "Team not found", http.StatusNotFound)
+			return
+		}
+		io.WriteString(w, "ok")
+	})
+	h := InstrumentMux(mux)
+	for _, req := range []struct{ method, path string }{
+		{http.MethodGet, "/api/teams/1"},
+		{http.MethodGet, "/api/teams/2"},
+		{http.MethodGet, "/api/teams/0"},
+		{"PROPFIND", "/api/teams/1"},
+		{http.MethodGet, "/no/such/page"},
+	} {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(req.method, req.path, nil))
+	}
+
+	rec := httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("/metrics returned %d", rec.Code)
+	}
+	body := rec.Body.String()
+	tests := []struct {
+		name string
+		line string
+	}{
+		{"requests by pattern", `bluedevil_http_requests_total{code="200",method="GET",route="/api/teams/{id}"} 2`},
+		{"status written by the handler", `bluedevil_http_requests_total{code="404",method="GET",route="/api/teams/{id}"} 1`},
+		{"unusual methods grouped", `bluedevil_http_requests_total{code="200",method="other",route="/api/teams/{id}"} 1`},
+		{"unmatched paths grouped", `bluedevil_http_requests_total{code="404",method="GET",route="unmatched"} 1`},
+		{"durations", `bluedevil_http_request_duration_seconds_count{method="GET",route="/api/teams/{id}"} 3`},
+		{"help text", `# HELP bluedevil_http_requests_total HTTP requests served, by route, method and status code.`},
+		{"type", `# TYPE bluedevil_http_request_duration_seconds histogram`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !containsLine(body, tt.line) {
+				t.Errorf("/metrics is missing %q", tt.line)
+			}
+		})
+	}
+	if strings.Contains(body, "/api/teams/1") {
+		t.Errorf("/metrics labels requests by path rather than pattern")
+	}
+}
+
+func containsLine(body, line string) bool {
+	for _, l := range strings.Split(body, "\n") {
+		if l == line {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,47 @@
+package scoring
+
+import (
+	cfg "BlueDevil-Engine/config"
+	structures "BlueDevil-Engine/structures"
+)
+
+// BuildBoxes pairs each scoring box accepted by include with its service and
+// team context, including the team's integrity baselines for the service.
+// Boxes whose service no longer exists are skipped.
+func BuildBoxes(boxes []structures.ScoringBox, services []structures.Service, teams []structures.Team, baselines []structures.ContentBaseline, include func(structures.ScoringBox) bool) []structures.AgentBox {
+	svcByID := make(map[int]structures.Service)
+	for _, s := range services {
+		svcByID[s.ID] = s
+	}
+	teamNames := make(map[int]string)
+	for _, t := range teams {
+		teamNames[t.ID] = t.Name
+	}
+	baselinesFor := make(map[[2]int][]structures.ContentBaseline)
+	for _, bl := range baselines {
+		key := [2]int{bl.TeamID, bl.ServiceID}
+		baselinesFor[key] = append(baselinesFor[key], bl)
+	}
+	out := []structures.AgentBox{}
+	for _, b := range boxes {
+		if include != nil && !include(b) {
+			continue
+		}
+		svc, ok := svcByID[b.ServiceID]
+		if !ok {
+			continue
+		}
+		out = append(out, structures.AgentBox{
+			BoxID:         b.ID,
+			TeamID:        b.TeamID,
+			TeamName:      teamNames[b.TeamID],
+			IPAddress:     b.IPAddress,
+			Service:       svc,
+			Credentials:   cfg.TeamCredentials(b.TeamID),
+			TeamAddresses: cfg.TeamAddresses(b.TeamID),
+			TeamURLs:      cfg.TeamURLs(b.TeamID),
+			Baselines:     baselinesFor[[2]int{b.TeamID, b.ServiceID}],
+		})
+	}
+	return out
+}
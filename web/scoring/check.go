@@ -0,0 +1,256 @@
+package scoring
+
+// Check execution shared by the central scoring engine and remote scoring agents.
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strings"
+	"time"
+
+	structures "BlueDevil-Engine/structures"
+)
+
+// DefaultCheckTimeout bounds how long a single check attempt may run when the
+// check does not set its own timeout.
+const DefaultCheckTimeout = 10 * time.Second
+
+// ExpandCommand replaces the {{host}} placeholder in a check command with the
+// address of the box being checked.
+func ExpandCommand(command, host string) string {
+	command = strings.ReplaceAll(command, "{{host}}", host)
+	command = strings.ReplaceAll(command, "{{ host }}", host)
+	return command
+}
+
+// Check types stored in service_checks.check_type.
+const (
+	CheckTypeCommand   = "command"
+	CheckTypeStarlark  = "starlark"
+	CheckTypePlugin    = "plugin"
+	CheckTypeIntegrity = "integrity"
+)
+
+// RunService runs every check configured for the box's service against it and
+// returns the result for the box's slot. The service is up only when every
+// check passes, and loses the largest penalty any check withheld. The output
+// has one line per check and is what gets stored in competition_services.output.
+// The raw evidence of every attempt is kept with the result so it can be
+// re-assessed later (see Reassess).
+func RunService(ctx context.Context, box structures.AgentBox) structures.CheckResult {
+	svc := box.Service
+	res := structures.CheckResult{TeamID: box.TeamID, ServiceID: svc.ID, Round: box.Round, Slot: box.Slot}
+	if len(svc.Checks) == 0 {
+		res.Output = "no checks configured for " + svc.Name
+		return res
+	}
+	started := time.Now()
+	res.IsUp = true
+	var out strings.Builder
+	for _, chk := range svc.Checks {
+		addOutcome(&res, &out, chk, RunCheck(ctx, chk, box))
+	}
+	finishResult(&res, &out)
+	res.DurationMs = int(time.Since(started) / time.Millisecond)
+	return res
+}
+
+// addOutcome folds one check's outcome into a service result and writes its
+// line of output.
+func addOutcome(res *structures.CheckResult, out *strings.Builder, chk structures.Checks, o CheckOutcome) {
+	status := "PASS"
+	if !o.OK {
+		status = "FAIL"
+		res.IsUp = false
+	}
+	res.Penalty = max(res.Penalty, o.Penalty)
+	res.TimedOut = res.TimedOut || o.TimedOut
+	res.Evidence = append(res.Evidence, o.Evidence)
+	if o.Baseline != nil {
+		res.Baselines = append(res.Baselines, *o.Baseline)
+	}
+	fmt.Fprintf(out, "[%s] %s: %s\n", status, chk.Name, strings.TrimSpace(o.Output))
+}
+
+func finishResult(res *structures.CheckResult, out *strings.Builder) {
+	if !res.IsUp {
+		res.Penalty = 0
+	}
+	res.Output = strings.TrimSpace(out.String())
+}
+
+// CheckOutcome is the result of running one check against a box.
+type CheckOutcome struct {
+	OK bool
+	// Penalty is the percentage of points withheld by the last attempt
+	Penalty int
+	Output  string
+	// TimedOut is set when the last attempt ran past the check timeout
+	TimedOut bool
+	// Evidence holds the raw result of every attempt
+	Evidence structures.CheckEvidence
+	// Baseline is set when an integrity check captured the box's baseline
+	Baseline *structures.ContentBaseline
+}
+
+// RunCheck runs a check against box according to its attempt policy: up to
+// 1+Retries attempts, RetryDelay apart, stopping as soon as MinPasses attempts
+// have passed or too few attempts remain to get there. When more than one
+// attempt is allowed, the attempt tally is put at the top of the output so
+// flaky infrastructure can be told apart from a real outage.
+func RunCheck(ctx context.Context, chk structures.Checks, box structures.AgentBox) CheckOutcome {
+	attempts := 1 + max(chk.Retries, 0)
+	need := min(max(chk.MinPasses, 1), attempts)
+	delay := time.Duration(max(chk.RetryDelay, 0)) * time.Second
+
+	ev := structures.CheckEvidence{Check: chk.Name, Type: chk.Type}
+	for len(ev.Attempts) < attempts {
+		if len(ev.Attempts) > 0 && delay > 0 {
+			select {
+			case <-ctx.Done():
+			case <-time.After(delay):
+			}
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		attemptCtx, cancel := context.WithTimeout(ctx, CheckTimeout(chk))
+		started := time.Now()
+		a := runAttempt(attemptCtx, chk, box)
+		a.DurationMs = int(time.Since(started) / time.Millisecond)
+		a.TimedOut = !a.OK && errors.Is(attemptCtx.Err(), context.DeadlineExceeded)
+		cancel()
+		ev.Attempts = append(ev.Attempts, a)
+		passed := countPasses(chk, ev.Attempts)
+		if passed >= need || passed+(attempts-len(ev.Attempts)) < need {
+			break
+		}
+	}
+	return assessEvidence(chk, ev, attempts)
+}
+
+// assessEvidence applies the check's assertions and attempt policy to the
+// recorded attempts. attempts is how many attempts the policy allowed.
+func assessEvidence(chk structures.Checks, ev structures.CheckEvidence, attempts int) CheckOutcome {
+	need := min(max(chk.MinPasses, 1), attempts)
+	o := CheckOutcome{Evidence: ev}
+	passed := 0
+	for _, a := range ev.Attempts {
+		if a.Baseline != nil {
+			o.Baseline = a.Baseline
+		}
+		var ok bool
+		ok, o.Penalty, o.Output = AssessAttempt(chk, a)
+		o.TimedOut = a.TimedOut
+		if ok {
+			passed++
+		}
+	}
+	o.OK = passed >= need
+	if attempts > 1 {
+		o.Output = fmt.Sprintf("%d of %d attempts passed (%d made, %d required)\n%s", passed, attempts, len(ev.Attempts), need, o.Output)
+	}
+	return o
+}
+
+func countPasses(chk structures.Checks, attempts []structures.AttemptEvidence) int {
+	n := 0
+	for _, a := range attempts {
+		if ok, _, _ := AssessAttempt(chk, a); ok {
+			n++
+		}
+	}
+	return n
+}
+
+// AssessAttempt applies a check's assertions to the raw result of one attempt
+// and returns whether it passed, the penalty and the message for the output.
+// Regexes apply to the output of command, Starlark and plugin checks.
+func AssessAttempt(chk structures.Checks, a structures.AttemptEvidence) (bool, int, string) {
+	if !a.OK {
+		if a.Error != "" {
+			return false, 0, a.Error + "\n" + a.Output
+		}
+		return false, 0, a.Output
+	}
+	switch chk.Type {
+	case "", CheckTypeCommand, CheckTypeStarlark, CheckTypePlugin:
+		if missing := EvaluateRegexes(chk.Regexes, a.Output); len(missing) > 0 {
+			return false, 0, fmt.Sprintf("output did not match %s\n%s", strings.Join(missing, ", "), a.Output)
+		}
+	}
+	return true, a.Penalty, a.Output
+}
+
+// runAttempt executes a check once against box and returns its raw result,
+// before the check's assertions are applied. Command checks run through the
+// shell, Starlark checks run their script in an embedded interpreter, plugin
+// checks exec a registered plugin binary and integrity checks compare a page
+// with its captured baseline. ctx carries the attempt's timeout.
+func runAttempt(ctx context.Context, chk structures.Checks, box structures.AgentBox) structures.AttemptEvidence {
+	var a structures.AttemptEvidence
+	switch chk.Type {
+	case "", CheckTypeCommand:
+		return runCommand(ctx, chk, box.IPAddress)
+	case CheckTypeIntegrity:
+		a.OK, a.Penalty, a.Output, a.Baseline = RunIntegrity(ctx, chk, box)
+	case CheckTypeStarlark:
+		a.OK, a.Output = RunStarlark(ctx, chk.Script, box)
+	case CheckTypePlugin:
+		a.OK, a.Output = RunPlugin(ctx, chk, box)
+	default:
+		a.Output = fmt.Sprintf("unknown check type %q", chk.Type)
+	}
+	return a
+}
+
+// CheckTimeout is how long a single attempt of chk may run.
+func CheckTimeout(chk structures.Checks) time.Duration {
+	if chk.TimeoutSeconds > 0 {
+		return time.Duration(chk.TimeoutSeconds) * time.Second
+	}
+	return DefaultCheckTimeout
+}
+
+// ServiceRunTime is the longest a run of svc's checks can take: every attempt
+// of every check timing out, plus the delays between retries.
+func ServiceRunTime(svc structures.Service) time.Duration {
+	var d time.Duration
+	for _, chk := range svc.Checks {
+		attempts := 1 + max(chk.Retries, 0)
+		d += time.Duration(attempts) * CheckTimeout(chk)
+		d += time.Duration(attempts-1) * time.Duration(max(chk.RetryDelay, 0)) * time.Second
+	}
+	return d
+}
+
+func runCommand(ctx context.Context, chk structures.Checks, host string) structures.AttemptEvidence {
+	cmd := exec.CommandContext(ctx, "sh", "-c", ExpandCommand(chk.Command, host))
+	// children of the shell can hold the output pipe open after it is killed
+	cmd.WaitDelay = time.Second
+	raw, err := cmd.CombinedOutput()
+	a := structures.AttemptEvidence{OK: err == nil, Output: string(raw), ExitCode: cmd.ProcessState.ExitCode()}
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		a.OK = false
+		a.Error = fmt.Sprintf("timed out after %s", CheckTimeout(chk))
+	} else if err != nil {
+		a.Error = err.Error()
+	}
+	return a
+}
+
+// EvaluateRegexes returns the patterns that did not match output. Invalid
+// patterns are reported as failures so a typo never silently passes a check.
+func EvaluateRegexes(regexes []structures.Regexes, output string) []string {
+	var missing []string
+	for _, rx := range regexes {
+		re, err := regexp.Compile(rx.Pattern)
+		if err != nil || !re.MatchString(output) {
+			missing = append(missing, fmt.Sprintf("%q", rx.Pattern))
+		}
+	}
+	return missing
+}
@@ -0,0 +1,159 @@
+package scoring
+
+import (
+	"fmt"
+	"time"
+
+	structures "BlueDevil-Engine/structures"
+)
+
+// DependencyLookback returns how many rounds before a result's round the
+// latest slot of a service it depends on may have started in. A service
+// checked every five minutes in one-minute rounds may last have run five
+// rounds earlier.
+func DependencyLookback(comp *structures.Competition, services []structures.Service) int {
+	lookback := 1
+	round := RoundInterval(comp)
+	for _, s := range services {
+		if n := int((ServiceInterval(comp, s) + round - 1) / round); n > lookback {
+			lookback = n
+		}
+	}
+	return lookback
+}
+
+// ApplyDependencies marks failed results whose service depends, directly or
+// through other services, on a service that is also down for the same team.
+// A dependency is judged by its most recent result in a slot starting at or
+// before the dependent's slot, since services may be checked at different
+// intervals. The deepest failed dependency is recorded as the root cause and
+// noted at the top of the output. recorded holds results already stored for
+// the rounds in question and the DependencyLookback rounds before them;
+// results are consulted first.
+//
+// A dependency with no such result yet (for example one checked by an agent
+// that reports later) is treated as up.
+func ApplyDependencies(results []structures.CheckResult, recorded []structures.CheckResult, services []structures.Service, comp *structures.Competition) {
+	svcByID := make(map[int]structures.Service)
+	for _, s := range services {
+		svcByID[s.ID] = s
+	}
+	type key struct{ team, service int }
+	type slotStatus struct {
+		start time.Duration // from the start of the competition
+		up    bool
+	}
+	history := make(map[key][]slotStatus)
+	startOf := func(r structures.CheckResult) time.Duration {
+		if r.Slot <= 0 {
+			// results recorded before slots existed
+			return time.Duration(r.Round-1) * RoundInterval(comp)
+		}
+		return time.Duration(r.Slot-1) * ServiceInterval(comp, svcByID[r.ServiceID])
+	}
+	add := func(r structures.CheckResult) {
+		k := key{r.TeamID, r.ServiceID}
+		st := slotStatus{start: startOf(r), up: r.IsUp}
+		for i, h := range history[k] {
+			if h.start == st.start {
+				history[k][i] = st
+				return
+			}
+		}
+		history[k] = append(history[k], st)
+	}
+	for _, r := range recorded {
+		add(r)
+	}
+	for _, r := range results {
+		add(r)
+	}
+	// statusAt returns the status of a service's latest slot starting at or
+	// before at, and whether there is one.
+	statusAt := func(team, serviceID int, at time.Duration) (bool, bool) {
+		var latest *slotStatus
+		for i, h := range history[key{team, serviceID}] {
+			if h.start <= at && (latest == nil || h.start > latest.start) {
+				latest = &history[key{team, serviceID}][i]
+			}
+		}
+		if latest == nil {
+			return false, false
+		}
+		return latest.up, true
+	}
+
+	// rootCause walks the failed dependencies of serviceID and returns the
+	// deepest one, or 0 if every dependency is up.
+	var rootCause func(team int, at time.Duration, serviceID int, seen map[int]bool) int
+	rootCause = func(team int, at time.Duration, serviceID int, seen map[int]bool) int {
+		seen[serviceID] = true
+		for _, dep := range svcByID[serviceID].DependsOn {
+			if seen[dep] {
+				continue
+			}
+			up, known := statusAt(team, dep, at)
+			if !known || up {
+				continue
+			}
+			if deeper := rootCause(team, at, dep, seen); deeper != 0 {
+				return deeper
+			}
+			return dep
+		}
+		return 0
+	}
+
+	for i := range results {
+		r := &results[i]
+		r.RootCause = ""
+		if r.IsUp {
+			continue
+		}
+		dep := rootCause(r.TeamID, startOf(*r), r.ServiceID, map[int]bool{})
+		if dep == 0 {
+			continue
+		}
+		name := svcByID[dep].Name
+		r.RootCause = name
+		r.Output = fmt.Sprintf("down (dependency: %s)\n%s", name, r.Output)
+	}
+}
+
+// HasDependencyCycle reports whether any service depends on itself, directly
+// or through other services. Cycles would let services excuse each other's
+// outages, so they are rejected when services are saved.
+func HasDependencyCycle(services []structures.Service) bool {
+	deps := make(map[int][]int)
+	for _, s := range services {
+		deps[s.ID] = s.DependsOn
+	}
+	const (
+		visiting = 1
+		done     = 2
+	)
+	state := make(map[int]int)
+	var visit func(id int) bool
+	visit = func(id int) bool {
+		switch state[id] {
+		case visiting:
+			return true
+		case done:
+			return false
+		}
+		state[id] = visiting
+		for _, d := range deps[id] {
+			if visit(d) {
+				return true
+			}
+		}
+		state[id] = done
+		return false
+	}
+	for id := range deps {
+		if visit(id) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,184 @@
+package scoring
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	structures "BlueDevil-Engine/structures"
+)
+
+var testStart = time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// runningComp is a competition started at testStart with one-minute rounds.
+func runningComp() *structures.Competition {
+	return &structures.Competition{
+		Status:        "running",
+		StartedTime:   testStart.Format(time.RFC3339),
+		RoundInterval: 60,
+	}
+}
+
+func TestApplyDependencies(t *testing.T) {
+	// App needs Web and DB, Web needs DNS. DNS is checked every other round.
+	services := []structures.Service{
+		{ID: 1, Name: "DNS", Interval: 120},
+		{ID: 2, Name: "Web", DependsOn: []int{1}},
+		{ID: 3, Name: "DB"},
+		{ID: 4, Name: "App", DependsOn: []int{2, 3}},
+	}
+	comp := runningComp()
+	result := func(service, slot int, up bool) structures.CheckResult {
+		return structures.CheckResult{TeamID: 1, ServiceID: service, Slot: slot, IsUp: up, Output: "check output"}
+	}
+
+	tests := []struct {
+		name     string
+		results  []structures.CheckResult
+		recorded []structures.CheckResult
+		// root cause wanted for each result, in order
+		want []string
+	}{
+		{
+			name:    "dependency down",
+			results: []structures.CheckResult{result(1, 1, false), result(2, 1, false)},
+			want:    []string{"", "DNS"},
+		},
+		{
+			name:    "dependency up",
+			results: []structures.CheckResult{result(1, 1, true), result(2, 1, false)},
+			want:    []string{"", ""},
+		},
+		{
+			name:    "dependency unknown",
+			results: []structures.CheckResult{result(2, 1, false)},
+			want:    []string{""},
+		},
+		{
+			name:    "up service keeps no root cause",
+			results: []structures.CheckResult{result(1, 1, false), result(2, 1, true)},
+			want:    []string{"", ""},
+		},
+		{
+			name:    "deepest failure",
+			results: []structures.CheckResult{result(1, 1, false), result(2, 1, false), result(3, 1, true), result(4, 1, false)},
+			want:    []string{"", "DNS", "", "DNS"},
+		},
+		{
+			name:    "second dependency",
+			results: []structures.CheckResult{result(2, 1, true), result(3, 1, false), result(4, 1, false)},
+			want:    []string{"", "", "DB"},
+		},
+		{
+			name:     "dependency from recorded results",
+			results:  []structures.CheckResult{result(2, 2, false)},
+			recorded: []structures.CheckResult{result(1, 1, false)},
+			want:     []string{"DNS"},
+		},
+		{
+			// Web's slot 2 starts at 60s, inside DNS's first slot
+			name:     "slower dependency judged by its slot in progress",
+			results:  []structures.CheckResult{result(2, 2, false)},
+			recorded: []structures.CheckResult{result(1, 1, true), result(1, 2, false)},
+			want:     []string{""},
+		},
+		{
+			// Web's slot 3 starts at 120s, with DNS's second slot
+			name:     "slower dependency's next slot",
+			results:  []structures.CheckResult{result(2, 3, false)},
+			recorded: []structures.CheckResult{result(1, 1, true), result(1, 2, false)},
+			want:     []string{"DNS"},
+		},
+		{
+			name:     "results replace recorded results",
+			results:  []structures.CheckResult{result(1, 1, true), result(2, 1, false)},
+			recorded: []structures.CheckResult{result(1, 1, false)},
+			want:     []string{"", ""},
+		},
+		{
+			name: "other teams are ignored",
+			results: []structures.CheckResult{
+				{TeamID: 2, ServiceID: 1, Slot: 1},
+				result(2, 1, false),
+			},
+			want: []string{"", ""},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ApplyDependencies(tt.results, tt.recorded, services, comp)
+			for i, r := range tt.results {
+				if r.RootCause != tt.want[i] {
+					t.Errorf("result %d: root cause %q, want %q", i, r.RootCause, tt.want[i])
+				}
+				if r.RootCause != "" && !strings.HasPrefix(r.Output, "down (dependency: "+r.RootCause+")\n") {
+					t.Errorf("result %d: output %q does not note the dependency", i, r.Output)
+				}
+			}
+		})
+	}
+}
+
+func TestApplyDependenciesClearsRootCause(t *testing.T) {
+	services := []structures.Service{{ID: 1, Name: "DNS"}, {ID: 2, Name: "Web", DependsOn: []int{1}}}
+	results := []structures.CheckResult{
+		{TeamID: 1, ServiceID: 1, Round: 1, IsUp: true},
+		{TeamID: 1, ServiceID: 2, Round: 1, RootCause: "DNS"},
+	}
+	ApplyDependencies(results, nil, services, runningComp())
+	if results[1].RootCause != "" {
+		t.Errorf("root cause %q kept with the dependency up", results[1].RootCause)
+	}
+}
+
+func TestHasDependencyCycle(t *testing.T) {
+	tests := []struct {
+		name     string
+		services []structures.Service
+		want     bool
+	}{
+		{"no services", nil, false},
+		{"no dependencies", []structures.Service{{ID: 1}, {ID: 2}}, false},
+		{"chain", []structures.Service{{ID: 1}, {ID: 2, DependsOn: []int{1}}, {ID: 3, DependsOn: []int{2}}}, false},
+		{"diamond", []structures.Service{{ID: 1}, {ID: 2, DependsOn: []int{1}}, {ID: 3, DependsOn: []int{1}}, {ID: 4, DependsOn: []int{2, 3}}}, false},
+		{"unknown dependency", []structures.Service{{ID: 1, DependsOn: []int{9}}}, false},
+		{"self", []structures.Service{{ID: 1, DependsOn: []int{1}}}, true},
+		{"pair", []structures.Service{{ID: 1, DependsOn: []int{2}}, {ID: 2, DependsOn: []int{1}}}, true},
+		{"loop of three", []structures.Service{{ID: 1, DependsOn: []int{3}}, {ID: 2, DependsOn: []int{1}}, {ID: 3, DependsOn: []int{2}}}, true},
+		{"loop off a chain", []structures.Service{{ID: 1}, {ID: 2, DependsOn: []int{1, 3}}, {ID: 3, DependsOn: []int{2}}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := HasDependencyCycle(tt.services); got != tt.want {
+				t.Errorf("HasDependencyCycle() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDependencyLookback(t *testing.T) {
+	comp := runningComp()
+	tests := []struct {
+		name      string
+		intervals []int
+		want      int
+	}{
+		{"no services", nil, 1},
+		{"every round", []int{0, 60}, 1},
+		{"more often than a round", []int{30}, 1},
+		{"every other round", []int{120}, 2},
+		{"partway into a round", []int{90}, 2},
+		{"every five minutes", []int{30, 300, 120}, 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var services []structures.Service
+			for i, interval := range tt.intervals {
+				services = append(services, structures.Service{ID: i + 1, Interval: interval})
+			}
+			if got := DependencyLookback(comp, services); got != tt.want {
+				t.Errorf("DependencyLookback() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,303 @@
+package scoring
+
+// Content integrity (defacement) checks. An admin captures a golden copy of a
+// page for every team; later checks fetch the page again and compare it with
+// that baseline by hash, by similarity of the visible text, or by required
+// markers.
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	structures "BlueDevil-Engine/structures"
+)
+
+// Integrity check modes.
+const (
+	IntegrityHash       = "hash"
+	IntegritySimilarity = "similarity"
+	IntegrityMarkers    = "markers"
+)
+
+// defaultSimilarity is the minimum text similarity, in percent, when an
+// integrity check does not set one.
+const defaultSimilarity = 90
+
+// maxDiffLines caps how much of a diff goes into the check output.
+const maxDiffLines = 40
+
+var integrityClient = &http.Client{
+	// Competition boxes almost always serve self-signed certificates.
+	Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}},
+}
+
+// ValidateIntegrity reports whether an integrity check is usable.
+func ValidateIntegrity(cfg *structures.IntegrityCheck) error {
+	if cfg == nil || strings.TrimSpace(cfg.URL) == "" {
+		return errors.New("integrity checks need a URL")
+	}
+	switch cfg.Mode {
+	case IntegrityHash, IntegritySimilarity:
+	case IntegrityMarkers:
+		if len(cfg.Markers) == 0 {
+			return errors.New("markers mode needs at least one marker")
+		}
+	default:
+		return fmt.Errorf("unknown integrity mode %q", cfg.Mode)
+	}
+	if cfg.Similarity < 0 || cfg.Similarity > 100 || cfg.Partial < 0 || cfg.Partial > 100 {
+		return errors.New("similarity and partial penalty are percentages")
+	}
+	return nil
+}
+
+// NeedsBaseline reports whether an integrity check compares against a
+// captured baseline.
+func NeedsBaseline(chk structures.Checks) bool {
+	return chk.Type == CheckTypeIntegrity && chk.Integrity != nil && chk.Integrity.Mode != IntegrityMarkers
+}
+
+// FetchPage GETs url and returns its body, failing on non-2xx responses.
+func FetchPage(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := integrityClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseBytes))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	return body, nil
+}
+
+// CaptureBaseline fetches the page an integrity check watches on box and
+// returns it as a baseline for the box's team.
+func CaptureBaseline(ctx context.Context, chk structures.Checks, box structures.AgentBox) (structures.ContentBaseline, error) {
+	if chk.Integrity == nil {
+		return structures.ContentBaseline{}, fmt.Errorf("check %q is not an integrity check", chk.Name)
+	}
+	ctx, cancel := context.WithTimeout(ctx, CheckTimeout(chk))
+	defer cancel()
+	url := ExpandCommand(chk.Integrity.URL, box.IPAddress)
+	body, err := FetchPage(ctx, url)
+	if err != nil {
+		return structures.ContentBaseline{}, err
+	}
+	return newBaseline(chk, box, url, body), nil
+}
+
+func newBaseline(chk structures.Checks, box structures.AgentBox, url string, body []byte) structures.ContentBaseline {
+	return structures.ContentBaseline{
+		TeamID:     box.TeamID,
+		ServiceID:  box.Service.ID,
+		Check:      chk.Name,
+		URL:        url,
+		Hash:       hashContent(body),
+		Text:       PageText(string(body)),
+		CapturedAt: time.Now().UTC().Format(time.RFC3339),
+	}
+}
+
+// RunIntegrity checks the page against the box's baseline. It returns whether
+// the check passed, the percentage of points to withhold, and the output. A
+// page that cannot be fetched fails the check; a defaced page fails it too
+// unless the check only partially penalizes defacement.
+//
+// When the box has no baseline yet the page is captured as one and returned,
+// so boxes only their engine or agent can reach still get a baseline.
+func RunIntegrity(ctx context.Context, chk structures.Checks, box structures.AgentBox) (bool, int, string, *structures.ContentBaseline) {
+	cfg := chk.Integrity
+	if cfg == nil {
+		return false, 0, "integrity check is not configured", nil
+	}
+	url := ExpandCommand(cfg.URL, box.IPAddress)
+	body, err := FetchPage(ctx, url)
+	if err != nil {
+		return false, 0, fmt.Sprintf("fetching %s: %v", url, err), nil
+	}
+
+	var intact bool
+	var detail string
+	switch cfg.Mode {
+	case IntegrityMarkers:
+		var missing []string
+		for _, m := range cfg.Markers {
+			if m != "" && !strings.Contains(string(body), m) {
+				missing = append(missing, fmt.Sprintf("%q", m))
+			}
+		}
+		intact = len(missing) == 0
+		if intact {
+			detail = fmt.Sprintf("all %d markers present", len(cfg.Markers))
+		} else {
+			detail = "missing markers: " + strings.Join(missing, ", ")
+		}
+	case IntegrityHash, IntegritySimilarity:
+		base := findBaseline(box.Baselines, chk.Name)
+		if base == nil {
+			captured := newBaseline(chk, box, url, body)
+			return true, 0, "no baseline yet; captured " + url + " as the baseline", &captured
+		}
+		text := PageText(string(body))
+		if cfg.Mode == IntegrityHash {
+			intact = hashContent(body) == base.Hash
+			if intact {
+				detail = "content matches baseline"
+			} else {
+				detail = "content hash differs from baseline\n" + TextDiff(base.Text, text)
+			}
+		} else {
+			need := cfg.Similarity
+			if need <= 0 {
+				need = defaultSimilarity
+			}
+			sim := TextSimilarity(base.Text, text)
+			intact = sim >= need
+			detail = fmt.Sprintf("text %d%% similar to baseline (need %d%%)", sim, need)
+			if !intact {
+				detail += "\n" + TextDiff(base.Text, text)
+			}
+		}
+	default:
+		return false, 0, fmt.Sprintf("unknown integrity mode %q", cfg.Mode), nil
+	}
+
+	if intact {
+		return true, 0, detail, nil
+	}
+	if cfg.Partial > 0 {
+		penalty := min(cfg.Partial, 100)
+		return true, penalty, fmt.Sprintf("DEFACED (-%d%% points): %s", penalty, detail), nil
+	}
+	return false, 0, "DEFACED: " + detail, nil
+}
+
+func findBaseline(baselines []structures.ContentBaseline, check string) *structures.ContentBaseline {
+	for i := range baselines {
+		if baselines[i].Check == check {
+			return &baselines[i]
+		}
+	}
+	return nil
+}
+
+func hashContent(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+var (
+	invisibleRe = regexp.MustCompile(`(?is)<(script|style|noscript|template)\b.*?</(script|style|noscript|template)\s*>|<!--.*?-->`)
+	blockTagRe  = regexp.MustCompile(`(?i)<(br|/p|/div|/h[1-6]|/li|/tr|/title|/section|/article|/header|/footer)\b[^>]*>`)
+	tagRe       = regexp.MustCompile(`(?s)<[^>]*>`)
+	spaceRe     = regexp.MustCompile(`[ \t\r\f\v]+`)
+)
+
+// PageText returns the visible text of an HTML page, one block per line.
+// Non-HTML content is returned with whitespace normalized.
+func PageText(page string) string {
+	page = invisibleRe.ReplaceAllString(page, "")
+	page = blockTagRe.ReplaceAllString(page, "\n")
+	page = tagRe.ReplaceAllString(page, " ")
+	page = html.UnescapeString(page)
+	var lines []string
+	for _, line := range strings.Split(page, "\n") {
+		line = strings.TrimSpace(spaceRe.ReplaceAllString(line, " "))
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// TextSimilarity returns how similar two texts are, in percent, by the share
+// of words they have in common.
+func TextSimilarity(a, b string) int {
+	wa, wb := strings.Fields(a), strings.Fields(b)
+	if len(wa)+len(wb) == 0 {
+		return 100
+	}
+	counts := make(map[string]int)
+	for _, w := range wa {
+		counts[w]++
+	}
+	common := 0
+	for _, w := range wb {
+		if counts[w] > 0 {
+			counts[w]--
+			common++
+		}
+	}
+	return 200 * common / (len(wa) + len(wb))
+}
+
+// TextDiff returns a line diff from baseline to current, "-" for removed and
+// "+" for added lines, truncated to maxDiffLines.
+func TextDiff(baseline, current string) string {
+	a, b := splitLines(baseline), splitLines(current)
+	// very large pages are not diffed line by line
+	if len(a)*len(b) > 4_000_000 {
+		return fmt.Sprintf("(diff skipped: %d baseline lines, %d current lines)", len(a), len(b))
+	}
+	// longest common subsequence table, filled from the end
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+	var out []string
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			i++
+			j++
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			out = append(out, "- "+a[i])
+			i++
+		default:
+			out = append(out, "+ "+b[j])
+			j++
+		}
+	}
+	if len(out) == 0 {
+		return "(no text changes)"
+	}
+	if len(out) > maxDiffLines {
+		out = append(out[:maxDiffLines], fmt.Sprintf("... %d more changed lines", len(out)-maxDiffLines))
+	}
+	return strings.Join(out, "\n")
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, "\n")
+}
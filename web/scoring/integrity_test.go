@@ -0,0 +1,65 @@
+package scoring
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestTextSimilarity(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+		want int
+	}{
+		{"both empty", "", "", 100},
+		{"one empty", "hello world", "", 0},
+		{"identical", "hello world", "hello world", 100},
+		{"whitespace ignored", "hello   world\n", " hello world", 100},
+		{"word order ignored", "hello world", "world hello", 100},
+		{"disjoint", "hello world", "goodbye moon", 0},
+		{"one word changed", "a b c d", "a b c e", 75},
+		{"repeated words counted once each", "a a", "a", 66},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := TextSimilarity(tt.a, tt.b); got != tt.want {
+				t.Errorf("TextSimilarity() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTextDiff(t *testing.T) {
+	var long []string
+	for i := 0; i < maxDiffLines+5; i++ {
+		long = append(long, fmt.Sprintf("line %d", i))
+	}
+	truncated := make([]string, 0, maxDiffLines+1)
+	for _, l := range long[:maxDiffLines] {
+		truncated = append(truncated, "+ "+l)
+	}
+	truncated = append(truncated, "... 5 more changed lines")
+
+	tests := []struct {
+		name              string
+		baseline, current string
+		want              string
+	}{
+		{"unchanged", "a\nb", "a\nb", "(no text changes)"},
+		{"both empty", "", "", "(no text changes)"},
+		{"line changed", "a\nb\nc", "a\nx\nc", "- b\n+ x"},
+		{"line added", "a\nc", "a\nb\nc", "+ b"},
+		{"line removed", "a\nb\nc", "a\nc", "- b"},
+		{"all new", "", "a\nb", "+ a\n+ b"},
+		{"defaced", "Welcome\nAbout us\nContact", "HACKED\nAbout us", "- Welcome\n+ HACKED\n- Contact"},
+		{"truncated", "", strings.Join(long, "\n"), strings.Join(truncated, "\n")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := TextDiff(tt.baseline, tt.current); got != tt.want {
+				t.Errorf("TextDiff() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
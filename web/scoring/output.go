@@ -0,0 +1,156 @@
+package scoring
+
+// Preparing check output for storage. Outputs can echo back credentials and
+// whole pages, so before a result is recorded known secrets and configured
+// patterns are masked and the output is capped. Output shown to teams is
+// filtered again, with the team-only patterns.
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	cfg "BlueDevil-Engine/config"
+	structures "BlueDevil-Engine/structures"
+)
+
+// Redacted replaces masked values in stored output.
+const Redacted = "[REDACTED]"
+
+// DefaultMaxOutputBytes caps stored output when the config does not set
+// max_output_bytes.
+const DefaultMaxOutputBytes = 64 << 10
+
+// TeamOutputBytes caps check output shown to teams.
+const TeamOutputBytes = 4 << 10
+
+// minSecretLength keeps very short passwords from masking unrelated text.
+const minSecretLength = 4
+
+var (
+	redactOnce         sync.Once
+	redactPatterns     []*regexp.Regexp
+	teamRedactPatterns []*regexp.Regexp
+)
+
+// configuredPatterns compiles the config's redact_patterns and
+// team_redact_patterns once. Invalid patterns are logged and skipped.
+func configuredPatterns() []*regexp.Regexp {
+	redactOnce.Do(func() {
+		redactPatterns = compilePatterns(cfg.Global.RedactPatterns)
+		teamRedactPatterns = compilePatterns(cfg.Global.TeamRedactPatterns)
+	})
+	return redactPatterns
+}
+
+func compilePatterns(patterns []string) []*regexp.Regexp {
+	var out []*regexp.Regexp
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			log.Printf("config: invalid redact pattern %q: %v", p, err)
+			continue
+		}
+		out = append(out, re)
+	}
+	return out
+}
+
+// PrepareOutput redacts a team's check output and caps its size. It is applied
+// to every result before it is recorded.
+func PrepareOutput(teamID int, output string) string {
+	return CapOutput(RedactOutput(teamID, output))
+}
+
+// PrepareEvidence redacts and caps the raw output of every attempt in a
+// result's evidence, in place.
+func PrepareEvidence(teamID int, evidence []structures.CheckEvidence) {
+	for i := range evidence {
+		for j := range evidence[i].Attempts {
+			a := &evidence[i].Attempts[j]
+			a.Output = PrepareOutput(teamID, a.Output)
+			a.Error = RedactOutput(teamID, a.Error)
+		}
+	}
+}
+
+// RedactOutput masks the team's known credential values and anything matching
+// the configured redact patterns.
+func RedactOutput(teamID int, output string) string {
+	var secrets []string
+	for _, c := range cfg.TeamCredentials(teamID) {
+		if len(c.Password) >= minSecretLength {
+			secrets = append(secrets, c.Password)
+		}
+	}
+	// longest first so a password containing another is masked whole
+	sort.Slice(secrets, func(i, j int) bool { return len(secrets[i]) > len(secrets[j]) })
+	for _, s := range secrets {
+		output = strings.ReplaceAll(output, s, Redacted)
+	}
+	for _, re := range configuredPatterns() {
+		output = redactPattern(re, output)
+	}
+	return output
+}
+
+// RedactForTeam filters stored output before it is shown to the team it
+// belongs to. Redaction is applied again, as patterns and credentials may
+// have changed since the result was recorded, along with the team-only
+// patterns, and the output is capped at TeamOutputBytes.
+func RedactForTeam(teamID int, output string) string {
+	output = RedactOutput(teamID, output)
+	configuredPatterns()
+	for _, re := range teamRedactPatterns {
+		output = redactPattern(re, output)
+	}
+	return capBytes(output, TeamOutputBytes)
+}
+
+// redactPattern masks each match of re, or only its capture groups when it
+// has any.
+func redactPattern(re *regexp.Regexp, s string) string {
+	if re.NumSubexp() == 0 {
+		return re.ReplaceAllLiteralString(s, Redacted)
+	}
+	var b strings.Builder
+	last := 0
+	for _, m := range re.FindAllStringSubmatchIndex(s, -1) {
+		for g := 2; g+1 < len(m); g += 2 {
+			start, end := m[g], m[g+1]
+			if start < last || start == end {
+				continue
+			}
+			b.WriteString(s[last:start])
+			b.WriteString(Redacted)
+			last = end
+		}
+	}
+	b.WriteString(s[last:])
+	return b.String()
+}
+
+// CapOutput truncates output to the configured maximum, noting how much was
+// cut.
+func CapOutput(output string) string {
+	limit := cfg.Global.MaxOutputBytes
+	if limit <= 0 {
+		limit = DefaultMaxOutputBytes
+	}
+	return capBytes(output, limit)
+}
+
+func capBytes(output string, limit int) string {
+	if len(output) <= limit {
+		return output
+	}
+	cut := limit
+	// don't split a UTF-8 sequence
+	for cut > 0 && output[cut]&0xC0 == 0x80 {
+		cut--
+	}
+	return output[:cut] + fmt.Sprintf("\n... [truncated %d bytes]", len(output)-cut)
+}
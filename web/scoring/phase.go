@@ -0,0 +1,61 @@
+package scoring
+
+// Competition phases. A competition usually opens with a grace period in which
+// teams change passwords: checks run and their results are shown, but earn no
+// points. Scoring follows, and an optional final freeze at the end keeps
+// scoring while the public scoreboard stops showing new points.
+
+import (
+	"time"
+
+	structures "BlueDevil-Engine/structures"
+)
+
+// Competition phases, in order.
+const (
+	PhaseGrace   = "grace"
+	PhaseScoring = "scoring"
+	PhaseFreeze  = "freeze"
+)
+
+// phaseAfter returns the phase at offset d from the competition start.
+func phaseAfter(comp *structures.Competition, d time.Duration) string {
+	switch {
+	case d < time.Duration(comp.ScoringOffset)*time.Minute:
+		return PhaseGrace
+	case comp.FreezeOffset > 0 && d >= time.Duration(comp.FreezeOffset)*time.Minute:
+		return PhaseFreeze
+	}
+	return PhaseScoring
+}
+
+// PhaseAt returns the phase in progress at now, or "" if the competition is
+// not running.
+func PhaseAt(comp *structures.Competition, now time.Time) string {
+	d, ok := elapsed(comp, now)
+	if !ok {
+		return ""
+	}
+	return phaseAfter(comp, d)
+}
+
+// RoundPhase returns the phase a round belongs to, by when it started. Rounds
+// keep their phase after the competition stops so they can be rescored.
+func RoundPhase(comp *structures.Competition, round int) string {
+	if comp == nil || round <= 0 {
+		return PhaseScoring
+	}
+	return phaseAfter(comp, time.Duration(round-1)*RoundInterval(comp))
+}
+
+// FreezeRound returns the first round of the final freeze, or 0 if the
+// competition has no freeze.
+func FreezeRound(comp *structures.Competition) int {
+	if comp == nil || comp.FreezeOffset <= 0 {
+		return 0
+	}
+	freeze := time.Duration(comp.FreezeOffset) * time.Minute
+	interval := RoundInterval(comp)
+	// the first round starting at or after the freeze offset
+	return int((freeze+interval-1)/interval) + 1
+}
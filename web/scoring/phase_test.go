@@ -0,0 +1,66 @@
+package scoring
+
+import (
+	"testing"
+
+	structures "BlueDevil-Engine/structures"
+)
+
+func TestFreezeRound(t *testing.T) {
+	tests := []struct {
+		name string
+		comp *structures.Competition
+		want int
+	}{
+		{"nil competition", nil, 0},
+		{"no freeze", &structures.Competition{RoundInterval: 60}, 0},
+		{"freeze on a round boundary", &structures.Competition{RoundInterval: 60, FreezeOffset: 10}, 11},
+		{"freeze within a round", &structures.Competition{RoundInterval: 90, FreezeOffset: 10}, 8},
+		{"default interval", &structures.Competition{FreezeOffset: 5}, 6},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := FreezeRound(tt.comp)
+			if got != tt.want {
+				t.Fatalf("FreezeRound() = %d, want %d", got, tt.want)
+			}
+			// the freeze round is the first round RoundPhase puts in the freeze
+			if got > 0 {
+				if p := RoundPhase(tt.comp, got); p != PhaseFreeze {
+					t.Errorf("RoundPhase(%d) = %q, want %q", got, p, PhaseFreeze)
+				}
+				if p := RoundPhase(tt.comp, got-1); p == PhaseFreeze {
+					t.Errorf("RoundPhase(%d) = %q, want a round before the freeze", got-1, p)
+				}
+			}
+		})
+	}
+}
+
+func TestRoundPhase(t *testing.T) {
+	// grace for the first five minutes, frozen from ten
+	comp := &structures.Competition{RoundInterval: 60, ScoringOffset: 5, FreezeOffset: 10}
+	tests := []struct {
+		name  string
+		comp  *structures.Competition
+		round int
+		want  string
+	}{
+		{"nil competition", nil, 3, PhaseScoring},
+		{"no round", comp, 0, PhaseScoring},
+		{"first round", comp, 1, PhaseGrace},
+		{"last grace round", comp, 5, PhaseGrace},
+		{"first scoring round", comp, 6, PhaseScoring},
+		{"last scoring round", comp, 10, PhaseScoring},
+		{"first frozen round", comp, 11, PhaseFreeze},
+		{"later frozen round", comp, 50, PhaseFreeze},
+		{"no grace or freeze", &structures.Competition{RoundInterval: 60}, 1, PhaseScoring},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := RoundPhase(tt.comp, tt.round); got != tt.want {
+				t.Errorf("RoundPhase() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package scoring
+
+// External check plugins. The engine execs the registered binary, writes a
+// structures.PluginRequest as JSON on its stdin and reads a
+// structures.PluginResult as JSON from its stdout. Anything the plugin writes
+// to stderr is kept in the check output to help debugging.
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"sort"
+	"strings"
+	"time"
+
+	structures "BlueDevil-Engine/structures"
+)
+
+// PluginProtocolVersion is sent in every request so plugins can reject
+// requests they do not understand.
+const PluginProtocolVersion = 1
+
+// RunPlugin runs a plugin check against box. The context deadline bounds the
+// plugin's run time; the remaining time is passed to the plugin as well.
+func RunPlugin(ctx context.Context, chk structures.Checks, box structures.AgentBox) (bool, string) {
+	if chk.PluginPath == "" {
+		return false, fmt.Sprintf("plugin %q is not registered", chk.Plugin)
+	}
+	params := chk.Params
+	if params == nil {
+		params = map[string]string{}
+	}
+	creds := box.Credentials
+	if creds == nil {
+		creds = []structures.Credential{}
+	}
+	timeout := DefaultCheckTimeout
+	if deadline, ok := ctx.Deadline(); ok {
+		timeout = time.Until(deadline)
+	}
+	req, err := json.Marshal(structures.PluginRequest{
+		Version:        PluginProtocolVersion,
+		Host:           box.IPAddress,
+		Team:           structures.PluginTeam{ID: box.TeamID, Name: box.TeamName},
+		Service:        box.Service.Name,
+		Check:          chk.Name,
+		Credentials:    creds,
+		Params:         params,
+		TimeoutSeconds: int(timeout.Seconds()),
+	})
+	if err != nil {
+		return false, "encoding plugin request: " + err.Error()
+	}
+
+	stdout := &cappedBuffer{limit: maxResponseBytes}
+	stderr := &cappedBuffer{limit: maxResponseBytes}
+	cmd := exec.CommandContext(ctx, chk.PluginPath)
+	// children of the plugin can hold its output open after it is killed
+	cmd.WaitDelay = time.Second
+	cmd.Stdin = bytes.NewReader(req)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	err = cmd.Run()
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return false, fmt.Sprintf("plugin %s timed out\n%s", chk.Plugin, stderr.String())
+	}
+	if err != nil {
+		return false, fmt.Sprintf("plugin %s: %v\n%s", chk.Plugin, err, stderr.String())
+	}
+	if stdout.truncated {
+		return false, fmt.Sprintf("plugin %s wrote more than %d bytes to stdout\n%s", chk.Plugin, maxResponseBytes, stderr.String())
+	}
+
+	var res structures.PluginResult
+	if err := json.Unmarshal(stdout.Bytes(), &res); err != nil {
+		return false, fmt.Sprintf("plugin %s returned invalid JSON: %v\n%s", chk.Plugin, err, stdout.String())
+	}
+	var up bool
+	switch strings.ToLower(res.Status) {
+	case "up":
+		up = true
+	case "down":
+		up = false
+	default:
+		return false, fmt.Sprintf("plugin %s returned unknown status %q: %s", chk.Plugin, res.Status, res.Message)
+	}
+	return up, strings.TrimSpace(res.Message + "\n" + formatMetrics(res.Metrics))
+}
+
+// formatMetrics renders plugin metrics in a stable order for the check output.
+func formatMetrics(metrics map[string]float64) string {
+	if len(metrics) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(metrics))
+	for k := range metrics {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		parts = append(parts, fmt.Sprintf("%s=%g", k, metrics[k]))
+	}
+	return "metrics: " + strings.Join(parts, " ")
+}
+
+// cappedBuffer keeps the first limit bytes written to it and discards the
+// rest, so a plugin flooding its output cannot exhaust memory.
+type cappedBuffer struct {
+	buf       bytes.Buffer
+	limit     int
+	truncated bool
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if room := b.limit - b.buf.Len(); len(p) > room {
+		b.truncated = true
+		b.buf.Write(p[:max(room, 0)])
+		return len(p), nil
+	}
+	return b.buf.Write(p)
+}
+
+func (b *cappedBuffer) Bytes() []byte  { return b.buf.Bytes() }
+func (b *cappedBuffer) String() string { return b.buf.String() }
@@ -0,0 +1,137 @@
+package scoring
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	structures "BlueDevil-Engine/structures"
+)
+
+// writePlugin writes a shell script plugin and returns its path.
+func writePlugin(t *testing.T, body string) string {
+	path := filepath.Join(t.TempDir(), "plugin")
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+body+"\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestRunPluginRequest(t *testing.T) {
+	saved := filepath.Join(t.TempDir(), "request.json")
+	chk := structures.Checks{
+		Name:       "share",
+		Plugin:     "smb",
+		PluginPath: writePlugin(t, `cat > `+saved+`; echo '{"status": "up", "message": "listed 4 files"}'`),
+		Params:     map[string]string{"share": "public"},
+	}
+	box := structures.AgentBox{
+		TeamID:      3,
+		TeamName:    "Team 3",
+		IPAddress:   "10.0.3.5",
+		Service:     structures.Service{Name: "SMB"},
+		Credentials: []structures.Credential{{ID: "SMB", Username: "admin", Password: "pw"}},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 8*time.Second)
+	defer cancel()
+	up, output := RunPlugin(ctx, chk, box)
+	if !up || output != "listed 4 files" {
+		t.Fatalf("RunPlugin() = %v, %q", up, output)
+	}
+
+	raw, err := os.ReadFile(saved)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var req structures.PluginRequest
+	if err := json.Unmarshal(raw, &req); err != nil {
+		t.Fatalf("plugin got invalid JSON %q: %v", raw, err)
+	}
+	if req.Version != PluginProtocolVersion || req.Host != "10.0.3.5" || req.Team.ID != 3 || req.Team.Name != "Team 3" ||
+		req.Service != "SMB" || req.Check != "share" || req.Params["share"] != "public" ||
+		len(req.Credentials) != 1 || req.Credentials[0].Password != "pw" {
+		t.Errorf("plugin got %+v", req)
+	}
+	if req.TimeoutSeconds < 6 || req.TimeoutSeconds > 8 {
+		t.Errorf("plugin got a timeout of %ds, want the 8s left", req.TimeoutSeconds)
+	}
+
+	// parameters and credentials are sent as empty objects rather than null
+	chk.Params = nil
+	box.Credentials = nil
+	RunPlugin(context.Background(), chk, box)
+	raw, _ = os.ReadFile(saved)
+	if s := string(raw); !strings.Contains(s, `"params":{}`) || !strings.Contains(s, `"credentials":[]`) {
+		t.Errorf("plugin got %s", s)
+	}
+}
+
+func TestRunPlugin(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+		up     bool
+		output string
+	}{
+		{"up", `echo '{"status": "up", "message": "ok"}'`, true, "ok"},
+		{"status is case-insensitive", `echo '{"status": "UP"}'`, true, ""},
+		{"down", `echo '{"status": "down", "message": "share missing"}'`, false, "share missing"},
+		{"metrics", `echo '{"status": "up", "message": "ok", "metrics": {"latency_ms": 12.5, "files": 4}}'`, true, "ok\nmetrics: files=4 latency_ms=12.5"},
+		{"unknown status", `echo '{"status": "degraded", "message": "slow"}'`, false, `unknown status "degraded": slow`},
+		{"invalid JSON", `echo 'all good'`, false, "returned invalid JSON"},
+		{"no output", `true`, false, "returned invalid JSON"},
+		{"non-zero exit", `echo 'cannot connect' >&2; exit 2`, false, "exit status 2\ncannot connect"},
+		{"stderr kept on failure", `echo 'debug line' >&2; echo '{'`, false, "invalid JSON"},
+		{"too much output", `head -c 2000000 /dev/zero`, false, "wrote more than"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chk := structures.Checks{Name: "c", Plugin: "p", PluginPath: writePlugin(t, tt.script)}
+			up, output := RunPlugin(context.Background(), chk, structures.AgentBox{})
+			if up != tt.up {
+				t.Errorf("RunPlugin() up = %v, want %v (output %q)", up, tt.up, output)
+			}
+			if !strings.Contains(output, tt.output) {
+				t.Errorf("RunPlugin() output %q, want it to contain %q", output, tt.output)
+			}
+		})
+	}
+
+	t.Run("not registered", func(t *testing.T) {
+		up, output := RunPlugin(context.Background(), structures.Checks{Plugin: "gone"}, structures.AgentBox{})
+		if up || output != `plugin "gone" is not registered` {
+			t.Errorf("RunPlugin() = %v, %q", up, output)
+		}
+	})
+}
+
+func TestRunPluginTimeout(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+	}{
+		{"slow plugin", `sleep 30`},
+		// the background sleep keeps stdout open after the plugin is killed
+		{"child holds stdout", `sleep 30 & sleep 30`},
+		{"child outlives the plugin", `sleep 30 & echo '{"status": "up"}'`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chk := structures.Checks{Name: "c", Plugin: "p", PluginPath: writePlugin(t, tt.script)}
+			ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+			defer cancel()
+			start := time.Now()
+			up, output := RunPlugin(ctx, chk, structures.AgentBox{})
+			if elapsed := time.Since(start); elapsed > 5*time.Second {
+				t.Errorf("RunPlugin() returned after %s", elapsed)
+			}
+			if up {
+				t.Errorf("RunPlugin() passed: %q", output)
+			}
+		})
+	}
+}
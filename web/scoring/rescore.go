@@ -0,0 +1,50 @@
+package scoring
+
+// Re-assessing recorded results. Every result keeps the raw evidence of its
+// checks, so when an assertion turns out to be wrong the affected rounds can
+// be scored again under the checks as they are configured now.
+
+import (
+	"strings"
+
+	structures "BlueDevil-Engine/structures"
+)
+
+// Reassess evaluates a recorded result again from its evidence under svc's
+// current checks and returns the corrected result. The regexes and attempt
+// policy are applied afresh; integrity checks keep their recorded outcome
+// because the page itself is not stored. ok is false when the evidence does
+// not cover every current check (the check was added or its type changed
+// since), in which case the result cannot be re-assessed.
+func Reassess(res structures.CheckResult, svc structures.Service) (structures.CheckResult, bool) {
+	if len(svc.Checks) == 0 {
+		return res, false
+	}
+	byName := make(map[string]structures.CheckEvidence)
+	for _, ev := range res.Evidence {
+		byName[ev.Check] = ev
+	}
+	out := structures.CheckResult{
+		TeamID: res.TeamID, ServiceID: res.ServiceID, Round: res.Round, Slot: res.Slot,
+		IsUp: true, DurationMs: res.DurationMs,
+	}
+	var lines strings.Builder
+	for _, chk := range svc.Checks {
+		ev, found := byName[chk.Name]
+		if !found || len(ev.Attempts) == 0 || normalType(ev.Type) != normalType(chk.Type) {
+			return res, false
+		}
+		attempts := 1 + max(chk.Retries, 0)
+		ev.Attempts = ev.Attempts[:min(len(ev.Attempts), attempts)]
+		addOutcome(&out, &lines, chk, assessEvidence(chk, ev, attempts))
+	}
+	finishResult(&out, &lines)
+	return out, true
+}
+
+func normalType(t string) string {
+	if t == "" {
+		return CheckTypeCommand
+	}
+	return t
+}
@@ -0,0 +1,75 @@
+package scoring
+
+import (
+	"testing"
+
+	structures "BlueDevil-Engine/structures"
+)
+
+func TestReassess(t *testing.T) {
+	httpCheck := func(pattern string) structures.Checks {
+		return structures.Checks{Name: "http", Regexes: []structures.Regexes{{Pattern: pattern}}}
+	}
+	evidence := func(typ string, attempts ...structures.AttemptEvidence) []structures.CheckEvidence {
+		return []structures.CheckEvidence{{Check: "http", Type: typ, Attempts: attempts}}
+	}
+	pass := structures.AttemptEvidence{OK: true, Output: "HTTP/1.1 200 OK"}
+	fail := structures.AttemptEvidence{Output: "connection refused"}
+	defaced := structures.AttemptEvidence{OK: true, Output: "HTTP/1.1 200 OK", Penalty: 30}
+
+	tests := []struct {
+		name        string
+		checks      []structures.Checks
+		evidence    []structures.CheckEvidence
+		wantOK      bool
+		wantUp      bool
+		wantPenalty int
+	}{
+		{"still passes", []structures.Checks{httpCheck("200 OK")}, evidence("", pass), true, true, 0},
+		{"regex changed", []structures.Checks{httpCheck("201 Created")}, evidence("", pass), true, false, 0},
+		{"failed attempt", []structures.Checks{httpCheck("200 OK")}, evidence("", fail), true, false, 0},
+		{"penalty kept", []structures.Checks{httpCheck("200 OK")}, evidence("", defaced), true, true, 30},
+		{"penalty dropped when down", []structures.Checks{httpCheck("201")}, evidence("", defaced), true, false, 0},
+		{"default type matches command", []structures.Checks{{Name: "http", Type: CheckTypeCommand}}, evidence("", pass), true, true, 0},
+		{
+			"min passes met",
+			[]structures.Checks{{Name: "http", Retries: 2, MinPasses: 2}},
+			evidence("", pass, fail, pass), true, true, 0,
+		},
+		{
+			"min passes raised",
+			[]structures.Checks{{Name: "http", Retries: 2, MinPasses: 3}},
+			evidence("", pass, fail, pass), true, false, 0,
+		},
+		{
+			"retries cut",
+			[]structures.Checks{{Name: "http"}},
+			evidence("", fail, pass), true, false, 0,
+		},
+		{"no checks", nil, evidence("", pass), false, false, 0},
+		{"check added", []structures.Checks{httpCheck("200"), {Name: "ssh"}}, evidence("", pass), false, false, 0},
+		{"type changed", []structures.Checks{{Name: "http", Type: CheckTypeStarlark}}, evidence(CheckTypeCommand, pass), false, false, 0},
+		{"no attempts", []structures.Checks{httpCheck("200")}, evidence(""), false, false, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := structures.CheckResult{TeamID: 1, ServiceID: 2, Round: 3, Slot: 3, IsUp: true, Output: "recorded", Evidence: tt.evidence}
+			got, ok := Reassess(res, structures.Service{ID: 2, Checks: tt.checks})
+			if ok != tt.wantOK {
+				t.Fatalf("Reassess() ok = %v, want %v", ok, tt.wantOK)
+			}
+			if !ok {
+				if got.Output != res.Output {
+					t.Errorf("Reassess() changed a result it could not re-assess")
+				}
+				return
+			}
+			if got.IsUp != tt.wantUp || got.Penalty != tt.wantPenalty {
+				t.Errorf("Reassess() up = %v, penalty = %d, want %v, %d", got.IsUp, got.Penalty, tt.wantUp, tt.wantPenalty)
+			}
+			if got.TeamID != 1 || got.ServiceID != 2 || got.Round != 3 || got.Slot != 3 {
+				t.Errorf("Reassess() moved the result to %+v", got)
+			}
+		})
+	}
+}
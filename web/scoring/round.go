@@ -0,0 +1,133 @@
+package scoring
+
+import (
+	"math"
+	"time"
+
+	structures "BlueDevil-Engine/structures"
+)
+
+// DefaultRoundInterval is used when the competition has no round interval set.
+const DefaultRoundInterval = 60 * time.Second
+
+// ServiceUpPoints is awarded to a team for each service that is up in a round,
+// unless the service sets its own weight.
+const ServiceUpPoints = 100
+
+// RoundInterval returns the configured round length for the competition.
+func RoundInterval(comp *structures.Competition) time.Duration {
+	if comp == nil || comp.RoundInterval <= 0 {
+		return DefaultRoundInterval
+	}
+	return time.Duration(comp.RoundInterval) * time.Second
+}
+
+// ServiceInterval returns how often svc is checked: its own interval when set,
+// otherwise once per round.
+func ServiceInterval(comp *structures.Competition, svc structures.Service) time.Duration {
+	if svc.Interval > 0 {
+		return time.Duration(svc.Interval) * time.Second
+	}
+	return RoundInterval(comp)
+}
+
+// elapsed returns how long the competition has been running at now, or false
+// if it is not running.
+func elapsed(comp *structures.Competition, now time.Time) (time.Duration, bool) {
+	if comp == nil || comp.Status != "running" || comp.StartedTime == "" {
+		return 0, false
+	}
+	start, err := time.Parse(time.RFC3339, comp.StartedTime)
+	if err != nil || now.Before(start) {
+		return 0, false
+	}
+	return now.Sub(start), true
+}
+
+// RoundAt returns the round in progress at now. Rounds are numbered from 1 at
+// the competition start time so the engine and every agent agree on the round
+// number without coordinating. Zero means the competition is not running.
+func RoundAt(comp *structures.Competition, now time.Time) int {
+	d, ok := elapsed(comp, now)
+	if !ok {
+		return 0
+	}
+	return int(d/RoundInterval(comp)) + 1
+}
+
+// RoundStart returns when a round begins, or the zero time if the competition
+// has not started.
+func RoundStart(comp *structures.Competition, round int) time.Time {
+	if comp == nil || comp.StartedTime == "" || round <= 0 {
+		return time.Time{}
+	}
+	start, err := time.Parse(time.RFC3339, comp.StartedTime)
+	if err != nil {
+		return time.Time{}
+	}
+	return start.Add(time.Duration(round-1) * RoundInterval(comp)).UTC()
+}
+
+// SlotStart returns when a slot of svc begins, or the zero time if the
+// competition has not started.
+func SlotStart(comp *structures.Competition, svc structures.Service, slot int) time.Time {
+	start := RoundStart(comp, 1)
+	if start.IsZero() || slot <= 0 {
+		return time.Time{}
+	}
+	return start.Add(time.Duration(slot-1) * ServiceInterval(comp, svc))
+}
+
+// SlotAt returns the check slot of svc in progress at now. Slots are numbered
+// from 1 at the competition start like rounds, but follow the service's own
+// interval; each slot is checked once. For services checked once per round the
+// slot equals the round. Zero means the competition is not running.
+func SlotAt(comp *structures.Competition, svc structures.Service, now time.Time) int {
+	d, ok := elapsed(comp, now)
+	if !ok {
+		return 0
+	}
+	return int(d/ServiceInterval(comp, svc)) + 1
+}
+
+// RoundForSlot returns the global round in which a slot of svc starts. Results
+// are recorded under this round so charts keep one x-axis for every service.
+func RoundForSlot(comp *structures.Competition, svc structures.Service, slot int) int {
+	if slot <= 0 {
+		return 0
+	}
+	return int(time.Duration(slot-1)*ServiceInterval(comp, svc)/RoundInterval(comp)) + 1
+}
+
+// ServicePoints returns what one passing check of svc is worth. A service's
+// weight is its points per round (ServiceUpPoints unless set); checks are
+// scaled by their share of a round so a service earns the same points per unit
+// of time however often it is checked.
+func ServicePoints(comp *structures.Competition, svc structures.Service) int {
+	weight := svc.Weight
+	if weight <= 0 {
+		weight = ServiceUpPoints
+	}
+	scaled := float64(weight) * float64(ServiceInterval(comp, svc)) / float64(RoundInterval(comp))
+	return int(math.Round(scaled))
+}
+
+// PointsForResult applies the competition's scoring policy to a result for svc.
+// When the competition forgives dependency failures, a service that is only
+// down because a service it depends on is down still earns its points, so the
+// outage is penalized once at the root cause. An up service with a penalty
+// (e.g. a partially defaced page) loses that percentage of its points. Rounds
+// in the grace period earn nothing.
+func PointsForResult(res structures.CheckResult, svc structures.Service, comp *structures.Competition) int {
+	if RoundPhase(comp, res.Round) == PhaseGrace {
+		return 0
+	}
+	if res.IsUp {
+		penalty := min(max(res.Penalty, 0), 100)
+		return int(math.Round(float64(ServicePoints(comp, svc)) * float64(100-penalty) / 100))
+	}
+	if res.RootCause != "" && comp != nil && comp.ForgiveDependencies {
+		return ServicePoints(comp, svc)
+	}
+	return 0
+}
@@ -0,0 +1,147 @@
+package scoring
+
+import (
+	"testing"
+	"time"
+
+	structures "BlueDevil-Engine/structures"
+)
+
+func TestRoundAt(t *testing.T) {
+	stopped := runningComp()
+	stopped.Status = "stopped"
+	tests := []struct {
+		name string
+		comp *structures.Competition
+		at   time.Duration
+		want int
+	}{
+		{"nil competition", nil, time.Minute, 0},
+		{"not running", stopped, time.Minute, 0},
+		{"before the start", runningComp(), -time.Second, 0},
+		{"at the start", runningComp(), 0, 1},
+		{"end of the first round", runningComp(), 59 * time.Second, 1},
+		{"start of the second round", runningComp(), 60 * time.Second, 2},
+		{"later round", runningComp(), 10*time.Minute + 30*time.Second, 11},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := RoundAt(tt.comp, testStart.Add(tt.at)); got != tt.want {
+				t.Errorf("RoundAt() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSlotAt(t *testing.T) {
+	tests := []struct {
+		name     string
+		interval int
+		at       time.Duration
+		want     int
+	}{
+		{"once per round", 0, 90 * time.Second, 2},
+		{"faster than rounds", 30, 45 * time.Second, 2},
+		{"faster than rounds, later", 30, 90 * time.Second, 4},
+		{"slower than rounds", 120, 125 * time.Second, 2},
+		{"slower than rounds, first slot", 120, 119 * time.Second, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := structures.Service{Interval: tt.interval}
+			if got := SlotAt(runningComp(), svc, testStart.Add(tt.at)); got != tt.want {
+				t.Errorf("SlotAt() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+	if got := SlotAt(nil, structures.Service{Interval: 30}, testStart); got != 0 {
+		t.Errorf("SlotAt() without a competition = %d, want 0", got)
+	}
+}
+
+func TestRoundForSlot(t *testing.T) {
+	tests := []struct {
+		name     string
+		interval int
+		slot     int
+		want     int
+	}{
+		{"no slot", 30, 0, 0},
+		{"once per round", 0, 7, 7},
+		{"first half of round 1", 30, 1, 1},
+		{"second half of round 1", 30, 2, 1},
+		{"first half of round 2", 30, 3, 2},
+		{"every other round", 120, 2, 3},
+		{"every other round, third slot", 120, 3, 5},
+		{"offset from rounds", 90, 2, 2},
+		{"offset from rounds, third slot", 90, 3, 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := structures.Service{Interval: tt.interval}
+			if got := RoundForSlot(runningComp(), svc, tt.slot); got != tt.want {
+				t.Errorf("RoundForSlot() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestServicePoints(t *testing.T) {
+	tests := []struct {
+		name     string
+		weight   int
+		interval int
+		want     int
+	}{
+		{"defaults", 0, 0, ServiceUpPoints},
+		{"weighted", 50, 0, 50},
+		{"negative weight uses the default", -10, 0, ServiceUpPoints},
+		{"twice per round", 0, 30, 50},
+		{"every other round", 10, 120, 20},
+		{"rounded", 100, 20, 33},
+		{"longer than a round", 100, 90, 150},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := structures.Service{Weight: tt.weight, Interval: tt.interval}
+			if got := ServicePoints(runningComp(), svc); got != tt.want {
+				t.Errorf("ServicePoints() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPointsForResult(t *testing.T) {
+	// rounds 1 and 2 are the grace period
+	comp := runningComp()
+	comp.ScoringOffset = 2
+	forgiving := runningComp()
+	forgiving.ScoringOffset = 2
+	forgiving.ForgiveDependencies = true
+	svc := structures.Service{Weight: 100}
+
+	tests := []struct {
+		name string
+		comp *structures.Competition
+		res  structures.CheckResult
+		want int
+	}{
+		{"up in the grace period", comp, structures.CheckResult{Round: 2, IsUp: true}, 0},
+		{"up once scoring", comp, structures.CheckResult{Round: 3, IsUp: true}, 100},
+		{"down", comp, structures.CheckResult{Round: 3}, 0},
+		{"penalty", comp, structures.CheckResult{Round: 3, IsUp: true, Penalty: 25}, 75},
+		{"penalty over 100", comp, structures.CheckResult{Round: 3, IsUp: true, Penalty: 150}, 0},
+		{"negative penalty", comp, structures.CheckResult{Round: 3, IsUp: true, Penalty: -5}, 100},
+		{"dependency not forgiven", comp, structures.CheckResult{Round: 3, RootCause: "DNS"}, 0},
+		{"dependency forgiven", forgiving, structures.CheckResult{Round: 3, RootCause: "DNS"}, 100},
+		{"dependency forgiven in the grace period", forgiving, structures.CheckResult{Round: 1, RootCause: "DNS"}, 0},
+		{"no competition", nil, structures.CheckResult{Round: 1, IsUp: true, Penalty: 10}, 90},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := PointsForResult(tt.res, svc, tt.comp); got != tt.want {
+				t.Errorf("PointsForResult() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,414 @@
+package scoring
+
+// Scripted checks written in Starlark. A script defines check(), which returns
+// either a bool or an (ok, message) tuple. Scripts get a small, deliberately
+// limited set of builtins for talking to the box under test:
+//
+//	host                          address of the box being checked
+//	team                          struct(id, name, addresses, urls) of the
+//	                              owning team: id is the team number the
+//	                              config's templates use, addresses and urls
+//	                              map service names to the team's address in
+//	                              the IP scheme and its login URL
+//	credential(name, username=)   struct(username, password) or None
+//	http.get(url, headers=, timeout=)
+//	http.post(url, body=, form=, headers=, timeout=)
+//	                              struct(status, body, headers, url); cookies
+//	                              persist across calls within one run
+//	tcp.connect(host, port, send=, timeout=)
+//	                              whatever the server sent back, as a string
+//	dns.lookup(name, server=, type="A")
+//	                              list of records (A, MX, TXT, CNAME)
+//	regex.match(pattern, s)       bool
+//	regex.find(pattern, s)        first match or None
+//	regex.find_all(pattern, s)    list of matches
+//
+// print() output is appended to the check output.
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	structures "BlueDevil-Engine/structures"
+
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+	"go.starlark.net/syntax"
+)
+
+// maxScriptSteps bounds the work a single script may do so an accidental
+// infinite loop cannot stall a round.
+const maxScriptSteps = 10_000_000
+
+// maxResponseBytes caps how much of an HTTP or TCP response a script sees.
+const maxResponseBytes = 1 << 20
+
+var scriptFileOptions = &syntax.FileOptions{
+	Set:             true,
+	While:           true,
+	TopLevelControl: true,
+	GlobalReassign:  true,
+}
+
+// RunStarlark executes script against box and returns the result of its check()
+// function. Any script error fails the check with the error as output.
+func RunStarlark(ctx context.Context, script string, box structures.AgentBox) (bool, string) {
+	var printed strings.Builder
+	thread := &starlark.Thread{
+		Name: "check",
+		Print: func(_ *starlark.Thread, msg string) {
+			printed.WriteString(msg)
+			printed.WriteByte('\n')
+		},
+	}
+	thread.SetMaxExecutionSteps(maxScriptSteps)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			thread.Cancel(ctx.Err().Error())
+		case <-done:
+		}
+	}()
+
+	env := scriptEnv(ctx, box)
+	globals, err := starlark.ExecFileOptions(scriptFileOptions, thread, "check.star", script, env)
+	if err != nil {
+		return false, scriptError(err, printed.String())
+	}
+	fn, ok := globals["check"].(starlark.Callable)
+	if !ok {
+		return false, "script does not define check()"
+	}
+	ret, err := starlark.Call(thread, fn, nil, nil)
+	if err != nil {
+		return false, scriptError(err, printed.String())
+	}
+
+	var up bool
+	var msg string
+	switch v := ret.(type) {
+	case starlark.Bool:
+		up = bool(v)
+	case starlark.Tuple:
+		if len(v) != 2 {
+			return false, fmt.Sprintf("check() returned a %d-tuple, want (ok, message)", len(v))
+		}
+		up = bool(v[0].Truth())
+		if s, ok := starlark.AsString(v[1]); ok {
+			msg = s
+		} else {
+			msg = v[1].String()
+		}
+	default:
+		return false, fmt.Sprintf("check() returned %s, want bool or (ok, message)", ret.Type())
+	}
+	return up, strings.TrimSpace(printed.String() + msg)
+}
+
+func scriptError(err error, printed string) string {
+	if evalErr, ok := err.(*starlark.EvalError); ok {
+		return strings.TrimSpace(printed + evalErr.Backtrace())
+	}
+	return strings.TrimSpace(printed + err.Error())
+}
+
+// scriptTransport is shared by every script run so idle connections are
+// pooled and reaped instead of piling up with each run.
+var scriptTransport = &http.Transport{
+	// Competition boxes almost always serve self-signed certificates, and
+	// teams regenerate them as they harden their boxes, so like integrity
+	// checks scripts do not verify certificates.
+	TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+	IdleConnTimeout: 30 * time.Second,
+}
+
+// scriptEnv builds the predeclared names available to a check script. Each
+// run gets its own cookie jar.
+func scriptEnv(ctx context.Context, box structures.AgentBox) starlark.StringDict {
+	jar, _ := cookiejar.New(nil)
+	client := &http.Client{Jar: jar, Transport: scriptTransport}
+	s := &scriptRuntime{ctx: ctx, client: client, creds: box.Credentials}
+
+	return starlark.StringDict{
+		"host": starlark.String(box.IPAddress),
+		"team": starlarkstruct.FromStringDict(starlark.String("team"), starlark.StringDict{
+			"id":        starlark.MakeInt(box.TeamID),
+			"name":      starlark.String(box.TeamName),
+			"addresses": stringDict(box.TeamAddresses),
+			"urls":      stringDict(box.TeamURLs),
+		}),
+		"credential": starlark.NewBuiltin("credential", s.credential),
+		"http": &starlarkstruct.Module{Name: "http", Members: starlark.StringDict{
+			"get":  starlark.NewBuiltin("http.get", s.httpGet),
+			"post": starlark.NewBuiltin("http.post", s.httpPost),
+		}},
+		"tcp": &starlarkstruct.Module{Name: "tcp", Members: starlark.StringDict{
+			"connect": starlark.NewBuiltin("tcp.connect", s.tcpConnect),
+		}},
+		"dns": &starlarkstruct.Module{Name: "dns", Members: starlark.StringDict{
+			"lookup": starlark.NewBuiltin("dns.lookup", s.dnsLookup),
+		}},
+		"regex": &starlarkstruct.Module{Name: "regex", Members: starlark.StringDict{
+			"match":    starlark.NewBuiltin("regex.match", regexMatch),
+			"find":     starlark.NewBuiltin("regex.find", regexFind),
+			"find_all": starlark.NewBuiltin("regex.find_all", regexFindAll),
+		}},
+	}
+}
+
+// stringDict returns a frozen Starlark dict of m.
+func stringDict(m map[string]string) *starlark.Dict {
+	d := starlark.NewDict(len(m))
+	for k, v := range m {
+		_ = d.SetKey(starlark.String(k), starlark.String(v))
+	}
+	d.Freeze()
+	return d
+}
+
+type scriptRuntime struct {
+	ctx    context.Context
+	client *http.Client
+	creds  []structures.Credential
+}
+
+func (s *scriptRuntime) credential(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var name, username string
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "name", &name, "username?", &username); err != nil {
+		return nil, err
+	}
+	for _, c := range s.creds {
+		if !strings.EqualFold(c.ID, name) || (username != "" && c.Username != username) {
+			continue
+		}
+		return starlarkstruct.FromStringDict(starlark.String("credential"), starlark.StringDict{
+			"username": starlark.String(c.Username),
+			"password": starlark.String(c.Password),
+		}), nil
+	}
+	return starlark.None, nil
+}
+
+func (s *scriptRuntime) httpGet(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var rawURL string
+	var headers *starlark.Dict
+	timeout := 10
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "url", &rawURL, "headers?", &headers, "timeout?", &timeout); err != nil {
+		return nil, err
+	}
+	return s.doHTTP(b.Name(), http.MethodGet, rawURL, "", headers, timeout)
+}
+
+func (s *scriptRuntime) httpPost(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var rawURL, body string
+	var form, headers *starlark.Dict
+	timeout := 10
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "url", &rawURL, "body?", &body, "form?", &form, "headers?", &headers, "timeout?", &timeout); err != nil {
+		return nil, err
+	}
+	if form != nil {
+		vals := url.Values{}
+		for _, item := range form.Items() {
+			k, _ := starlark.AsString(item[0])
+			v, ok := starlark.AsString(item[1])
+			if !ok {
+				v = item[1].String()
+			}
+			vals.Add(k, v)
+		}
+		body = vals.Encode()
+		if headers == nil {
+			headers = starlark.NewDict(1)
+		}
+		if _, found, _ := headers.Get(starlark.String("Content-Type")); !found {
+			_ = headers.SetKey(starlark.String("Content-Type"), starlark.String("application/x-www-form-urlencoded"))
+		}
+	}
+	return s.doHTTP(b.Name(), http.MethodPost, rawURL, body, headers, timeout)
+}
+
+func (s *scriptRuntime) doHTTP(fn, method, rawURL, body string, headers *starlark.Dict, timeout int) (starlark.Value, error) {
+	ctx, cancel := context.WithTimeout(s.ctx, time.Duration(timeout)*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, method, rawURL, strings.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", fn, err)
+	}
+	if headers != nil {
+		for _, item := range headers.Items() {
+			k, _ := starlark.AsString(item[0])
+			v, _ := starlark.AsString(item[1])
+			req.Header.Set(k, v)
+		}
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", fn, err)
+	}
+	defer resp.Body.Close()
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseBytes))
+	if err != nil {
+		return nil, fmt.Errorf("%s: reading body: %v", fn, err)
+	}
+	hdrs := starlark.NewDict(len(resp.Header))
+	for k := range resp.Header {
+		_ = hdrs.SetKey(starlark.String(k), starlark.String(resp.Header.Get(k)))
+	}
+	return starlarkstruct.FromStringDict(starlark.String("response"), starlark.StringDict{
+		"status":  starlark.MakeInt(resp.StatusCode),
+		"body":    starlark.String(raw),
+		"headers": hdrs,
+		"url":     starlark.String(resp.Request.URL.String()),
+	}), nil
+}
+
+func (s *scriptRuntime) tcpConnect(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var host, send string
+	var port int
+	timeout := 5
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "host", &host, "port", &port, "send?", &send, "timeout?", &timeout); err != nil {
+		return nil, err
+	}
+	deadline := time.Now().Add(time.Duration(timeout) * time.Second)
+	ctx, cancel := context.WithDeadline(s.ctx, deadline)
+	defer cancel()
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", b.Name(), err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(deadline)
+	if send != "" {
+		if _, err := io.WriteString(conn, send); err != nil {
+			return nil, fmt.Errorf("%s: write: %v", b.Name(), err)
+		}
+	}
+	// Read until the server closes the connection or goes quiet; a timeout
+	// after some data has arrived is the normal end of a banner exchange.
+	buf := make([]byte, 4096)
+	var got []byte
+	for len(got) < maxResponseBytes {
+		n, err := conn.Read(buf)
+		got = append(got, buf[:n]...)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() && len(got) == 0 {
+				return nil, fmt.Errorf("%s: no response before timeout", b.Name())
+			}
+			break
+		}
+		_ = conn.SetReadDeadline(time.Now().Add(500 * time.Millisecond))
+	}
+	return starlark.String(got), nil
+}
+
+func (s *scriptRuntime) dnsLookup(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var name, server string
+	qtype := "A"
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "name", &name, "server?", &server, "type?", &qtype); err != nil {
+		return nil, err
+	}
+	resolver := net.DefaultResolver
+	if server != "" {
+		if _, _, err := net.SplitHostPort(server); err != nil {
+			server = net.JoinHostPort(server, "53")
+		}
+		resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, network, server)
+			},
+		}
+	}
+	ctx, cancel := context.WithTimeout(s.ctx, 5*time.Second)
+	defer cancel()
+
+	var records []string
+	var err error
+	switch strings.ToUpper(qtype) {
+	case "A":
+		records, err = resolver.LookupHost(ctx, name)
+	case "MX":
+		var mxs []*net.MX
+		mxs, err = resolver.LookupMX(ctx, name)
+		for _, mx := range mxs {
+			records = append(records, mx.Host)
+		}
+	case "TXT":
+		records, err = resolver.LookupTXT(ctx, name)
+	case "CNAME":
+		var cname string
+		cname, err = resolver.LookupCNAME(ctx, name)
+		if cname != "" {
+			records = append(records, cname)
+		}
+	default:
+		return nil, fmt.Errorf("%s: unsupported record type %q", b.Name(), qtype)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", b.Name(), err)
+	}
+	list := make([]starlark.Value, 0, len(records))
+	for _, r := range records {
+		list = append(list, starlark.String(r))
+	}
+	return starlark.NewList(list), nil
+}
+
+func unpackRegex(b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (*regexp.Regexp, string, error) {
+	var pattern, s string
+	if err := starlark.UnpackArgs(b.Name(), args, kwargs, "pattern", &pattern, "s", &s); err != nil {
+		return nil, "", err
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, "", fmt.Errorf("%s: %v", b.Name(), err)
+	}
+	return re, s, nil
+}
+
+func regexMatch(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	re, s, err := unpackRegex(b, args, kwargs)
+	if err != nil {
+		return nil, err
+	}
+	return starlark.Bool(re.MatchString(s)), nil
+}
+
+func regexFind(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	re, s, err := unpackRegex(b, args, kwargs)
+	if err != nil {
+		return nil, err
+	}
+	loc := re.FindStringIndex(s)
+	if loc == nil {
+		return starlark.None, nil
+	}
+	return starlark.String(s[loc[0]:loc[1]]), nil
+}
+
+func regexFindAll(_ *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	re, s, err := unpackRegex(b, args, kwargs)
+	if err != nil {
+		return nil, err
+	}
+	matches := re.FindAllString(s, -1)
+	list := make([]starlark.Value, 0, len(matches))
+	for _, m := range matches {
+		list = append(list, starlark.String(m))
+	}
+	return starlark.NewList(list), nil
+}
@@ -0,0 +1,178 @@
+package scoring
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	structures "BlueDevil-Engine/structures"
+)
+
+func TestRunStarlark(t *testing.T) {
+	// a web app that needs a login cookie
+	mux := http.NewServeMux()
+	mux.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.FormValue("user") != "admin" || r.FormValue("pass") != "hunter2" {
+			http.Error(w, "bad login", http.StatusForbidden)
+			return
+		}
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "ok"})
+		io.WriteString(w, "welcome")
+	})
+	mux.HandleFunc("/cart", func(w http.ResponseWriter, r *http.Request) {
+		if c, err := r.Cookie("session"); err != nil || c.Value != "ok" {
+			http.Error(w, "log in first", http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("X-Items", "3")
+		io.WriteString(w, "3 items in cart")
+	})
+	web := httptest.NewServer(mux)
+	defer web.Close()
+	tlsWeb := httptest.NewTLSServer(mux)
+	defer tlsWeb.Close()
+
+	// a banner service
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			buf := make([]byte, 64)
+			n, _ := conn.Read(buf)
+			fmt.Fprintf(conn, "220 ready, you said %s", buf[:n])
+			conn.Close()
+		}
+	}()
+	_, tcpPort, _ := net.SplitHostPort(ln.Addr().String())
+
+	box := structures.AgentBox{
+		TeamID:        7,
+		TeamName:      "Red Herrings",
+		IPAddress:     "127.0.0.1",
+		Credentials:   []structures.Credential{{ID: "Shop", Username: "admin", Password: "hunter2"}},
+		TeamAddresses: map[string]string{"Shop": "10.10.46.9"},
+		TeamURLs:      map[string]string{"Shop": "http://shop.team7.local"},
+	}
+
+	tests := []struct {
+		name   string
+		script string
+		up     bool
+		output string
+	}{
+		{"returns a bool", `def check(): return True`, true, ""},
+		{"returns a tuple", `def check(): return False, "down for maintenance"`, false, "down for maintenance"},
+		{"print is kept", "def check():\n    print('step 1')\n    return True, 'done'", true, "step 1\ndone"},
+		{"wrong tuple", `def check(): return True, "a", "b"`, false, "3-tuple"},
+		{"wrong type", `def check(): return "yes"`, false, "returned string"},
+		{"no check", `x = 1`, false, "does not define check()"},
+		{"syntax error", `def check(:`, false, "check.star"},
+		{"runtime error", `def check(): return 1 // 0`, false, "division by zero"},
+		{"host", `def check(): return host == "127.0.0.1"`, true, ""},
+		{"team", `def check(): return team.id == 7 and team.name == "Red Herrings"`, true, ""},
+		{"team variables", `def check(): return team.addresses["Shop"] == "10.10.46.9" and team.urls["Shop"] == "http://shop.team7.local"`, true, ""},
+		{"team variables are read-only", "def check():\n    team.addresses['Shop'] = 'x'\n    return True", false, "frozen"},
+		{"credential", `def check(): return credential("shop").password == "hunter2"`, true, ""},
+		{"credential by username", `def check(): return credential("Shop", username="root") == None`, true, ""},
+		{"unknown credential", `def check(): return credential("Mail") == None`, true, ""},
+		{
+			"http login keeps cookies",
+			fmt.Sprintf(`
+def check():
+    c = credential("Shop")
+    login = http.post("%[1]s/login", form={"user": c.username, "pass": c.password})
+    if login.status != 200:
+        return False, "login " + str(login.status)
+    r = http.get("%[1]s/cart")
+    return r.status == 200 and r.headers["X-Items"] == "3", r.body`, web.URL),
+			true, "3 items in cart",
+		},
+		{
+			"http without the cookie",
+			fmt.Sprintf(`def check(): return http.get("%s/cart").status == 401`, web.URL),
+			true, "",
+		},
+		{
+			"https with a self-signed certificate",
+			fmt.Sprintf(`def check(): return http.post("%s/login", body="user=admin&pass=hunter2", headers={"Content-Type": "application/x-www-form-urlencoded"}).body == "welcome"`, tlsWeb.URL),
+			true, "",
+		},
+		{"http error", `def check(): return http.get("http://127.0.0.1:1/").status == 200`, false, "http.get"},
+		{
+			"tcp",
+			fmt.Sprintf(`def check(): return tcp.connect(host, %s, send="HELO").startswith("220 ready, you said HELO")`, tcpPort),
+			true, "",
+		},
+		{"tcp refused", `def check(): return tcp.connect(host, 1) != ""`, false, "tcp.connect"},
+		{"dns", `def check(): return "127.0.0.1" in dns.lookup("localhost")`, true, ""},
+		{"dns record type", `def check(): return dns.lookup("localhost", type="SRV")`, false, "unsupported record type"},
+		{"regex.match", `def check(): return regex.match("^v[0-9]+", "v12 ok")`, true, ""},
+		{"regex.find", `def check(): return regex.find("[0-9]+", "v12 ok") == "12" and regex.find("x", "ok") == None`, true, ""},
+		{"regex.find_all", `def check(): return regex.find_all("[0-9]", "a1b2c3") == ["1", "2", "3"]`, true, ""},
+		{"bad regex", `def check(): return regex.match("(", "")`, false, "regex.match"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			up, output := RunStarlark(context.Background(), tt.script, box)
+			if up != tt.up {
+				t.Errorf("RunStarlark() up = %v, want %v (output %q)", up, tt.up, output)
+			}
+			if !strings.Contains(output, tt.output) {
+				t.Errorf("RunStarlark() output %q, want it to contain %q", output, tt.output)
+			}
+		})
+	}
+}
+
+func TestRunStarlarkTimeout(t *testing.T) {
+	tests := []struct {
+		name   string
+		script string
+	}{
+		{"busy loop", "def check():\n    while True:\n        pass"},
+		{"loop at the top level", "while True:\n    pass"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+			defer cancel()
+			start := time.Now()
+			up, output := RunStarlark(ctx, tt.script, structures.AgentBox{})
+			if elapsed := time.Since(start); elapsed > 5*time.Second {
+				t.Errorf("RunStarlark() ran %s past a 100ms timeout", elapsed)
+			}
+			if up || !strings.Contains(output, context.DeadlineExceeded.Error()) {
+				t.Errorf("RunStarlark() = %v, %q, want a timeout", up, output)
+			}
+		})
+	}
+
+	// a slow server is abandoned when the check times out
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer slow.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	up, output := RunStarlark(ctx, fmt.Sprintf(`def check(): return http.get("%s").status == 200`, slow.URL), structures.AgentBox{})
+	if up || time.Since(start) > 2*time.Second {
+		t.Errorf("RunStarlark() = %v, %q after %s, want a prompt failure", up, output, time.Since(start))
+	}
+}
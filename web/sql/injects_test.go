@@ -0,0 +1,141 @@
+package sql_wrapper
+
+import (
+	"database/sql"
+	"testing"
+)
+
+func TestGetCountedInjectSubmissions(t *testing.T) {
+	openTestDB(t)
+	// round 2 starts at 01:00, round 3 at 02:00
+	for _, r := range []struct{ number, startedAt string }{
+		{"1", "2026-01-01T00:00:00Z"},
+		{"2", "2026-01-01T01:00:00Z"},
+		{"3", "2026-01-01T02:00:00Z"},
+	} {
+		if _, err := db.DB.Exec("INSERT INTO rounds (number, started_at, status) VALUES (?, ?, 'complete')", r.number, r.startedAt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// submissions, oldest first within each team and inject
+	for _, s := range []struct {
+		inject      string
+		team        int
+		submittedAt string
+		scored      bool
+		score       sql.NullInt64
+		scoredAt    sql.NullString
+	}{
+		// team 1, inject A: graded 60, then 90, then 70
+		{"A", 1, "2026-01-01 00:10:00", true, sql.NullInt64{Int64: 60, Valid: true}, sql.NullString{String: "2026-01-01T00:20:00Z", Valid: true}},
+		{"A", 1, "2026-01-01 00:30:00", true, sql.NullInt64{Int64: 90, Valid: true}, sql.NullString{String: "2026-01-01T00:40:00Z", Valid: true}},
+		{"A", 1, "2026-01-01 00:50:00", true, sql.NullInt64{Int64: 70, Valid: true}, sql.NullString{String: "2026-01-01T01:10:00Z", Valid: true}},
+		// an ungraded resubmission never counts
+		{"A", 1, "2026-01-01 00:55:00", false, sql.NullInt64{}, sql.NullString{}},
+		// team 1, inject B: graded before grading times were kept
+		{"B", 1, "2026-01-01 00:15:00", true, sql.NullInt64{Int64: 40, Valid: true}, sql.NullString{}},
+		// team 2, inject A: marked scored without a score, then graded
+		{"A", 2, "2026-01-01 00:05:00", true, sql.NullInt64{}, sql.NullString{String: "2026-01-01T00:06:00Z", Valid: true}},
+		{"A", 2, "2026-01-01 00:25:00", true, sql.NullInt64{Int64: 50, Valid: true}, sql.NullString{String: "2026-01-01T01:30:00Z", Valid: true}},
+	} {
+		if _, err := db.DB.Exec("INSERT INTO inject_submissions (inject_id, team_id, filename, submitted_at, scored, score, reviewer, notes, scored_at) VALUES (?, ?, 'report.pdf', ?, ?, ?, '', '', ?)",
+			s.inject, s.team, s.submittedAt, s.scored, s.score, s.scoredAt); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	type counted struct {
+		inject string
+		team   int
+		score  int
+	}
+	tests := []struct {
+		name        string
+		rule        string
+		beforeRound int
+		want        []counted
+	}{
+		{"latest", InjectRuleLatest, 0, []counted{{"A", 1, 70}, {"B", 1, 40}, {"A", 2, 50}}},
+		{"unknown rule counts the latest", "", 0, []counted{{"A", 1, 70}, {"B", 1, 40}, {"A", 2, 50}}},
+		{"best", InjectRuleBest, 0, []counted{{"A", 1, 90}, {"B", 1, 40}, {"A", 2, 50}}},
+		{"first", InjectRuleFirst, 0, []counted{{"A", 1, 60}, {"B", 1, 40}, {"A", 2, 50}}},
+		{"latest graded before round 2", InjectRuleLatest, 2, []counted{{"A", 1, 90}, {"B", 1, 40}}},
+		{"best graded before round 2", InjectRuleBest, 2, []counted{{"A", 1, 90}, {"B", 1, 40}}},
+		{"latest graded before round 3", InjectRuleLatest, 3, []counted{{"A", 1, 70}, {"B", 1, 40}, {"A", 2, 50}}},
+		{"unrecorded round", InjectRuleLatest, 9, []counted{{"A", 1, 70}, {"B", 1, 40}, {"A", 2, 50}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			subs, err := GetCountedInjectSubmissions(tt.rule, tt.beforeRound)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []counted
+			for _, s := range subs {
+				got = append(got, counted{s.InjectID, s.TeamID, *s.Score})
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("GetCountedInjectSubmissions() = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("GetCountedInjectSubmissions() = %v, want %v", got, tt.want)
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestRegradeDuringFreeze(t *testing.T) {
+	openTestDB(t)
+	// round 2, the freeze round, starts at 01:00
+	for _, r := range []struct{ number, startedAt string }{
+		{"1", "2026-01-01T00:00:00Z"},
+		{"2", "2026-01-01T01:00:00Z"},
+	} {
+		if _, err := db.DB.Exec("INSERT INTO rounds (number, started_at, status) VALUES (?, ?, 'complete')", r.number, r.startedAt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := db.DB.Exec("INSERT INTO inject_submissions (inject_id, team_id, filename, submitted_at, reviewer, notes) VALUES ('A', 1, 'report.pdf', '2026-01-01 00:10:00', '', '')"); err != nil {
+		t.Fatal(err)
+	}
+	sub, err := GetInjectSubmission(1)
+	if err != nil || sub == nil {
+		t.Fatalf("GetInjectSubmission() = %v, %v", sub, err)
+	}
+	grade := func(scored bool, score int, at string) {
+		sub.Scored, sub.Score, sub.ScoredAt = scored, &score, at
+		if err := UpdateInjectSubmission(sub); err != nil {
+			t.Fatal(err)
+		}
+	}
+	counted := func(beforeRound int) []int {
+		subs, err := GetCountedInjectSubmissions(InjectRuleLatest, beforeRound)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var scores []int
+		for _, s := range subs {
+			scores = append(scores, *s.Score)
+		}
+		return scores
+	}
+	check := func(when string, beforeRound int, want []int) {
+		t.Helper()
+		got := counted(beforeRound)
+		if len(got) != len(want) || (len(got) == 1 && got[0] != want[0]) {
+			t.Errorf("%s, before round %d: counted %v, want %v", when, beforeRound, got, want)
+		}
+	}
+
+	grade(true, 60, "2026-01-01T00:20:00Z")
+	check("graded before the freeze", 2, []int{60})
+	grade(true, 80, "2026-01-01T01:10:00Z")
+	check("regraded during the freeze", 2, []int{60})
+	check("regraded during the freeze", 0, []int{80})
+	grade(false, 0, "")
+	check("ungraded during the freeze", 2, []int{60})
+	check("ungraded during the freeze", 0, nil)
+}
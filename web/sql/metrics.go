@@ -0,0 +1,157 @@
+package sql_wrapper
+
+// Query timings for /metrics. db wraps the connection pool so every query is
+// timed, including queries inside transactions, until its rows have been read.
+// Each query is labelled with the sql_wrapper function making it.
+
+import (
+	"database/sql"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	queryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "bluedevil_db_query_duration_seconds",
+		Help: "Time taken by database queries, by the sql_wrapper function making them.",
+		// most queries take well under the client library's smallest default
+		// bucket
+		Buckets: []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10},
+	}, []string{"query"})
+	queryErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "bluedevil_db_query_errors_total",
+		Help: "Database queries that failed, by the sql_wrapper function making them.",
+	}, []string{"query"})
+)
+
+type timedDB struct {
+	*sql.DB
+}
+
+type timedTx struct {
+	*sql.Tx
+}
+
+// timedRows finishes timing its query when closed, so reading the rows counts.
+type timedRows struct {
+	*sql.Rows
+	name   string
+	start  time.Time
+	closed bool
+}
+
+func (r *timedRows) Close() error {
+	err := r.Rows.Close()
+	if !r.closed {
+		r.closed = true
+		observeQuery(r.name, r.start, r.Rows.Err())
+	}
+	return err
+}
+
+// timedRow finishes timing its query when scanned.
+type timedRow struct {
+	*sql.Row
+	name  string
+	start time.Time
+}
+
+func (r *timedRow) Scan(dest ...interface{}) error {
+	err := r.Row.Scan(dest...)
+	observeQuery(r.name, r.start, err)
+	return err
+}
+
+func (d *timedDB) Query(query string, args ...interface{}) (*timedRows, error) {
+	name, start := callerName(), time.Now()
+	rows, err := d.DB.Query(query, args...)
+	return timeRows(name, start, rows, err)
+}
+
+func (d *timedDB) QueryRow(query string, args ...interface{}) *timedRow {
+	name, start := callerName(), time.Now()
+	return &timedRow{Row: d.DB.QueryRow(query, args...), name: name, start: start}
+}
+
+func (d *timedDB) Exec(query string, args ...interface{}) (sql.Result, error) {
+	name, start := callerName(), time.Now()
+	res, err := d.DB.Exec(query, args...)
+	observeQuery(name, start, err)
+	return res, err
+}
+
+func (d *timedDB) Begin() (*timedTx, error) {
+	tx, err := d.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	return &timedTx{tx}, nil
+}
+
+func (t *timedTx) Query(query string, args ...interface{}) (*timedRows, error) {
+	name, start := callerName(), time.Now()
+	rows, err := t.Tx.Query(query, args...)
+	return timeRows(name, start, rows, err)
+}
+
+func (t *timedTx) QueryRow(query string, args ...interface{}) *timedRow {
+	name, start := callerName(), time.Now()
+	return &timedRow{Row: t.Tx.QueryRow(query, args...), name: name, start: start}
+}
+
+func (t *timedTx) Exec(query string, args ...interface{}) (sql.Result, error) {
+	name, start := callerName(), time.Now()
+	res, err := t.Tx.Exec(query, args...)
+	observeQuery(name, start, err)
+	return res, err
+}
+
+// timeRows wraps the result of a query started at start, which is timed
+// until its rows are closed.
+func timeRows(name string, start time.Time, rows *sql.Rows, err error) (*timedRows, error) {
+	if err != nil {
+		observeQuery(name, start, err)
+		return nil, err
+	}
+	return &timedRows{Rows: rows, name: name, start: start}, nil
+}
+
+// callerNames caches the label of each call site by program counter.
+var callerNames sync.Map
+
+// callerName returns the name of the sql_wrapper function calling the timedDB
+// or timedTx method that calls it, without the package or any closure suffix.
+func callerName() string {
+	pcs := make([]uintptr, 1)
+	// skip runtime.Callers, callerName and the timed method
+	if runtime.Callers(3, pcs) == 0 {
+		return "unknown"
+	}
+	if name, ok := callerNames.Load(pcs[0]); ok {
+		return name.(string)
+	}
+	frame, _ := runtime.CallersFrames(pcs).Next()
+	name := frame.Function
+	// BlueDevil-Engine/sql.StartRound.func1 -> StartRound
+	name = name[strings.LastIndex(name, "/")+1:]
+	if i := strings.Index(name, "."); i >= 0 {
+		name = name[i+1:]
+	}
+	if i := strings.Index(name, "."); i >= 0 {
+		name = name[:i]
+	}
+	callerNames.Store(pcs[0], name)
+	return name
+}
+
+func observeQuery(name string, start time.Time, err error) {
+	queryDuration.WithLabelValues(name).Observe(time.Since(start).Seconds())
+	if err != nil && err != sql.ErrNoRows {
+		queryErrors.WithLabelValues(name).Inc()
+	}
+}
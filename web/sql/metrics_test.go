@@ -0,0 +1,67 @@
+package sql_wrapper
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// queryCount returns how many queries labelled name have been timed.
+func queryCount(t *testing.T, name string) uint64 {
+	t.Helper()
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range families {
+		if f.GetName() != "bluedevil_db_query_duration_seconds" {
+			continue
+		}
+		for _, m := range f.GetMetric() {
+			for _, l := range m.GetLabel() {
+				if l.GetName() == "query" && l.GetValue() == name {
+					return m.GetHistogram().GetSampleCount()
+				}
+			}
+		}
+	}
+	return 0
+}
+
+func TestQueryTimings(t *testing.T) {
+	openTestDB(t)
+	before := queryCount(t, "GetAllTeams")
+	if _, err := GetAllTeams(); err != nil {
+		t.Fatal(err)
+	}
+	if got := queryCount(t, "GetAllTeams"); got != before+1 {
+		t.Errorf("GetAllTeams timed %d queries, want 1", got-before)
+	}
+
+	// a query is timed once its rows are closed
+	rows, err := db.Query("SELECT id FROM teams")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for rows.Next() {
+	}
+	if got := queryCount(t, "TestQueryTimings"); got != 0 {
+		t.Errorf("timed a query before its rows were closed")
+	}
+	rows.Close()
+	rows.Close()
+	if got := queryCount(t, "TestQueryTimings"); got != 1 {
+		t.Errorf("timed %d queries, want 1", got)
+	}
+
+	// closures are labelled with the function they are in
+	func() {
+		var n int
+		if err := db.QueryRow("SELECT COUNT(*) FROM teams").Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+	}()
+	if got := queryCount(t, "TestQueryTimings"); got != 2 {
+		t.Errorf("timed %d queries, want 2", got)
+	}
+}
@@ -0,0 +1,73 @@
+package sql_wrapper
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	structures "BlueDevil-Engine/structures"
+)
+
+// openTestDB points the package at a fresh SQLite database for the test.
+func openTestDB(t *testing.T) {
+	if err := InitDB("sqlite3", filepath.Join(t.TempDir(), "test.db")); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { CloseDB() })
+	if err := CreateTables(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPluginInUse(t *testing.T) {
+	openTestDB(t)
+	used := &structures.CheckPlugin{Name: "smb", Path: "/opt/checks/smb"}
+	unused := &structures.CheckPlugin{Name: "ldap", Path: "/opt/checks/ldap"}
+	for _, p := range []*structures.CheckPlugin{used, unused} {
+		if err := SavePlugin(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	svc := &structures.Service{Name: "SMB", Checks: []structures.Checks{{Name: "share", Type: "plugin", Plugin: "smb"}}}
+	if err := SaveService(svc); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		op   func() error
+		want error
+	}{
+		{"rename used plugin", func() error { return SavePlugin(&structures.CheckPlugin{ID: used.ID, Name: "cifs", Path: used.Path}) }, ErrPluginInUse},
+		{"move used plugin", func() error {
+			return SavePlugin(&structures.CheckPlugin{ID: used.ID, Name: "smb", Path: "/usr/local/bin/smb"})
+		}, nil},
+		{"delete used plugin", func() error { return DeletePlugin(used.ID) }, ErrPluginInUse},
+		{"rename unused plugin", func() error { return SavePlugin(&structures.CheckPlugin{ID: unused.ID, Name: "ad", Path: unused.Path}) }, nil},
+		{"delete unused plugin", func() error { return DeletePlugin(unused.ID) }, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.op(); !errors.Is(err, tt.want) {
+				t.Errorf("got %v, want %v", err, tt.want)
+			}
+		})
+	}
+
+	plugins, err := GetAllPlugins()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plugins) != 1 || plugins[0].Name != "smb" || plugins[0].Path != "/usr/local/bin/smb" {
+		t.Errorf("plugins left: %+v", plugins)
+	}
+
+	// once no check uses it the plugin can go
+	svc.Checks = nil
+	if err := SaveService(svc); err != nil {
+		t.Fatal(err)
+	}
+	if err := DeletePlugin(used.ID); err != nil {
+		t.Errorf("deleting a plugin no longer used: %v", err)
+	}
+}
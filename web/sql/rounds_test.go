@@ -0,0 +1,82 @@
+package sql_wrapper
+
+import (
+	"testing"
+	"time"
+
+	structures "BlueDevil-Engine/structures"
+)
+
+func TestSettleRound(t *testing.T) {
+	openTestDB(t)
+	team := &structures.Team{Name: "T1"}
+	if err := CreateTeam(team); err != nil {
+		t.Fatal(err)
+	}
+	svc := structures.Service{Name: "Web"}
+	if err := SaveService(&svc); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().UTC().Format(time.RFC3339)
+	// each round records 5 points for the team
+	for round := 1; round <= 3; round++ {
+		if err := StartRound(round, now, 1); err != nil {
+			t.Fatal(err)
+		}
+		res := structures.CheckResult{TeamID: team.ID, ServiceID: svc.ID, Round: round, Slot: round, IsUp: true}
+		if _, err := RecordResults([]structures.CheckResult{res}, []int{5}, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := FinishRound(1, now, RoundComplete); err != nil {
+		t.Fatal(err)
+	}
+	if err := RecordRoundError(2); err != nil {
+		t.Fatal(err)
+	}
+	if err := FinishRound(2, now, RoundComplete); err != nil {
+		t.Fatal(err)
+	}
+	if err := MarkIncompleteRounds(4); err != nil {
+		t.Fatal(err)
+	}
+
+	points := func() int {
+		standings, err := GetTeamStandings(0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(standings) != 1 {
+			t.Fatalf("%d standings, want 1", len(standings))
+		}
+		return standings[0].Points
+	}
+	if got := points(); got != 5 {
+		t.Fatalf("%d points with rounds 2 and 3 failed and incomplete, want 5", got)
+	}
+
+	tests := []struct {
+		name    string
+		round   int
+		settled bool
+		points  int
+	}{
+		{"complete round", 1, false, 5},
+		{"failed round", 2, true, 10},
+		{"failed round again", 2, false, 10},
+		{"incomplete round", 3, true, 15},
+		{"unknown round", 4, false, 15},
+	}
+	for _, tt := range tests {
+		settled, err := SettleRound(tt.round)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if settled != tt.settled {
+			t.Errorf("%s: SettleRound() = %v, want %v", tt.name, settled, tt.settled)
+		}
+		if got := points(); got != tt.points {
+			t.Errorf("%s: %d points, want %d", tt.name, got, tt.points)
+		}
+	}
+}
@@ -3,9 +3,16 @@ package sql_wrapper
 // Wrapper containing SQL queries used by the application. This is used to configure either sqlite or mysql.
 
 import (
+	"bytes"
+	"compress/gzip"
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"sort"
 	"strings"
+	"time"
 
 	_ "github.com/go-sql-driver/mysql"
 	_ "github.com/mattn/go-sqlite3"
@@ -13,14 +20,14 @@ import (
 	structures "BlueDevil-Engine/structures"
 )
 
-var db *sql.DB
+var db *timedDB
 
 func InitDB(driver, dataSource string) error {
-	var err error
-	db, err = sql.Open(driver, dataSource)
+	conn, err := sql.Open(driver, dataSource)
 	if err != nil {
 		return err
 	}
+	db = &timedDB{conn}
 	return db.Ping()
 }
 
@@ -44,7 +51,9 @@ func CreateTables() error {
 	CREATE TABLE IF NOT EXISTS services (
 		id INTEGER PRIMARY KEY AUTOINCREMENT,
 		name TEXT NOT NULL UNIQUE,
-		description TEXT
+		description TEXT,
+		check_interval INTEGER NOT NULL DEFAULT 0,
+		weight INTEGER NOT NULL DEFAULT 0
 	);`
 
 	serviceCheckTable := `
@@ -53,6 +62,15 @@ func CreateTables() error {
 		service_id INTEGER NOT NULL,
 		name TEXT NOT NULL,
 		command TEXT NOT NULL,
+		check_type TEXT NOT NULL DEFAULT 'command',
+		script TEXT,
+		plugin TEXT,
+		params TEXT,
+		timeout_seconds INTEGER NOT NULL DEFAULT 0,
+		retries INTEGER NOT NULL DEFAULT 0,
+		retry_delay INTEGER NOT NULL DEFAULT 0,
+		min_passes INTEGER NOT NULL DEFAULT 0,
+		integrity TEXT,
 		FOREIGN KEY(service_id) REFERENCES services(id)
 	);`
 
@@ -68,7 +86,8 @@ func CreateTables() error {
 	teamTable := `
 	CREATE TABLE IF NOT EXISTS teams (
 		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		name TEXT NOT NULL UNIQUE
+		name TEXT NOT NULL UNIQUE,
+		alias TEXT
 	);`
 
 	teamMembersTable := `
@@ -87,6 +106,7 @@ func CreateTables() error {
 		team_id INTEGER NOT NULL,
 		ip_address TEXT NOT NULL,
 		service_id INTEGER,
+		agent_id INTEGER,
 		FOREIGN KEY(team_id) REFERENCES teams(id),
 		FOREIGN KEY(service_id) REFERENCES services(id)
 	);`
@@ -114,12 +134,30 @@ func CreateTables() error {
 		service_id INTEGER NOT NULL,
 		is_up BOOLEAN NOT NULL,
 		output TEXT,
+		output_gz BLOB,
 		round INTEGER NOT NULL,
+		slot INTEGER,
+		root_cause TEXT,
+		duration_ms INTEGER,
+		timed_out BOOLEAN NOT NULL DEFAULT 0,
+		evidence_gz BLOB,
+		override_reason TEXT,
+		override_by TEXT,
+		overridden_at TEXT,
 		timestamp DATETIME DEFAULT CURRENT_TIMESTAMP,
 		FOREIGN KEY(team_id) REFERENCES teams(id),
 		FOREIGN KEY(service_id) REFERENCES services(id)
 	);`
 
+	serviceDependenciesTable := `
+	CREATE TABLE IF NOT EXISTS service_dependencies (
+		service_id INTEGER NOT NULL,
+		depends_on_id INTEGER NOT NULL,
+		PRIMARY KEY(service_id, depends_on_id),
+		FOREIGN KEY(service_id) REFERENCES services(id),
+		FOREIGN KEY(depends_on_id) REFERENCES services(id)
+	);`
+
 	compScoresTable := `
 	CREATE TABLE IF NOT EXISTS competition_scores (
 		id INTEGER PRIMARY KEY AUTOINCREMENT,
@@ -127,6 +165,8 @@ func CreateTables() error {
 		score INTEGER NOT NULL,
 		round INTEGER,
 		description TEXT,
+		result_id INTEGER,
+		category TEXT,
 		timestamp DATETIME DEFAULT CURRENT_TIMESTAMP,
 		FOREIGN KEY(team_id) REFERENCES teams(id)
 	);`
@@ -137,7 +177,92 @@ func CreateTables() error {
 		status TEXT NOT NULL DEFAULT 'stopped',
 		scheduled_time DATETIME,
 		started_time DATETIME,
-		stopped_time DATETIME
+		stopped_time DATETIME,
+		round_interval INTEGER,
+		forgive_dependencies BOOLEAN NOT NULL DEFAULT 0,
+		scoring_offset INTEGER NOT NULL DEFAULT 0,
+		freeze_offset INTEGER NOT NULL DEFAULT 0,
+		revealed BOOLEAN NOT NULL DEFAULT 0,
+		inject_score_rule TEXT NOT NULL DEFAULT 'latest',
+		anonymize_teams BOOLEAN NOT NULL DEFAULT 0
+	);`
+
+	scoringAgentsTable := `
+	CREATE TABLE IF NOT EXISTS scoring_agents (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL UNIQUE,
+		token_hash TEXT NOT NULL UNIQUE,
+		last_seen TEXT,
+		last_address TEXT,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);`
+
+	checkPluginsTable := `
+	CREATE TABLE IF NOT EXISTS check_plugins (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		name TEXT NOT NULL UNIQUE,
+		path TEXT NOT NULL,
+		description TEXT
+	);`
+
+	roundsTable := `
+	CREATE TABLE IF NOT EXISTS rounds (
+		number INTEGER PRIMARY KEY,
+		started_at TEXT,
+		ended_at TEXT,
+		seed INTEGER NOT NULL DEFAULT 0,
+		status TEXT NOT NULL DEFAULT 'running',
+		checks_total INTEGER NOT NULL DEFAULT 0,
+		checks_up INTEGER NOT NULL DEFAULT 0,
+		checks_down INTEGER NOT NULL DEFAULT 0,
+		checks_timed_out INTEGER NOT NULL DEFAULT 0,
+		errors INTEGER NOT NULL DEFAULT 0
+	);`
+
+	// how long each check of a recorded result took, for the check latency
+	// distribution; a result's own duration covers all of its checks
+	checkDurationsTable := `
+	CREATE TABLE IF NOT EXISTS check_durations (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		result_id INTEGER NOT NULL,
+		round INTEGER NOT NULL,
+		check_name TEXT NOT NULL,
+		duration_ms INTEGER NOT NULL,
+		FOREIGN KEY(result_id) REFERENCES competition_services(id)
+	);`
+
+	// a single row naming the scoring-service instance allowed to schedule
+	// checks; instances heartbeat in engine_instances
+	engineLeaseTable := `
+	CREATE TABLE IF NOT EXISTS engine_lease (
+		id INTEGER PRIMARY KEY,
+		holder TEXT NOT NULL DEFAULT '',
+		acquired_at TEXT,
+		expires_at TEXT NOT NULL DEFAULT '',
+		last_round INTEGER NOT NULL DEFAULT 0,
+		last_round_at TEXT
+	);`
+
+	engineInstancesTable := `
+	CREATE TABLE IF NOT EXISTS engine_instances (
+		instance_id TEXT PRIMARY KEY,
+		hostname TEXT,
+		started_at TEXT NOT NULL,
+		last_seen TEXT NOT NULL
+	);`
+
+	// golden copies for integrity checks; keyed by check name because
+	// service_checks rows are recreated whenever a service is saved
+	contentBaselinesTable := `
+	CREATE TABLE IF NOT EXISTS content_baselines (
+		team_id INTEGER NOT NULL,
+		service_id INTEGER NOT NULL,
+		check_name TEXT NOT NULL,
+		url TEXT NOT NULL,
+		hash TEXT NOT NULL,
+		text TEXT,
+		captured_at TEXT NOT NULL,
+		PRIMARY KEY(team_id, service_id, check_name)
 	);`
 
 	_, err := db.Exec(servicesTable)
@@ -195,6 +320,147 @@ func CreateTables() error {
 		return err
 	}
 
+	_, err = db.Exec(scoringAgentsTable)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(checkPluginsTable)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(serviceDependenciesTable)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(contentBaselinesTable)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(roundsTable)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(checkDurationsTable)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(engineLeaseTable)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(engineInstancesTable)
+	if err != nil {
+		return err
+	}
+
+	var leases int
+	if err = db.QueryRow("SELECT COUNT(*) FROM engine_lease").Scan(&leases); err != nil {
+		return err
+	}
+	if leases == 0 {
+		if _, err = db.Exec("INSERT INTO engine_lease (id) VALUES (1)"); err != nil {
+			return err
+		}
+	}
+
+	// columns added after the original schema; existing databases need them too
+	if err = ensureColumn("scored_boxes", "agent_id", "INTEGER"); err != nil {
+		return err
+	}
+	if err = ensureColumn("service_checks", "check_type", "TEXT NOT NULL DEFAULT 'command'"); err != nil {
+		return err
+	}
+	if err = ensureColumn("service_checks", "script", "TEXT"); err != nil {
+		return err
+	}
+	if err = ensureColumn("service_checks", "plugin", "TEXT"); err != nil {
+		return err
+	}
+	if err = ensureColumn("service_checks", "params", "TEXT"); err != nil {
+		return err
+	}
+	if err = ensureColumn("services", "check_interval", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err = ensureColumn("services", "weight", "INTEGER NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err = ensureColumn("competition_services", "slot", "INTEGER"); err != nil {
+		return err
+	}
+	if err = uniqueResultSlots(); err != nil {
+		return err
+	}
+	for _, col := range []string{"timeout_seconds", "retries", "retry_delay", "min_passes"} {
+		if err = ensureColumn("service_checks", col, "INTEGER NOT NULL DEFAULT 0"); err != nil {
+			return err
+		}
+	}
+	if err = ensureColumn("competition_services", "root_cause", "TEXT"); err != nil {
+		return err
+	}
+	if err = ensureColumn("competition", "forgive_dependencies", "BOOLEAN NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err = ensureColumn("competition", "round_interval", "INTEGER"); err != nil {
+		return err
+	}
+	if err = ensureColumn("service_checks", "integrity", "TEXT"); err != nil {
+		return err
+	}
+	if err = ensureColumn("competition_services", "output_gz", "BLOB"); err != nil {
+		return err
+	}
+	if err = ensureColumn("competition_services", "duration_ms", "INTEGER"); err != nil {
+		return err
+	}
+	if err = ensureColumn("competition_services", "timed_out", "BOOLEAN NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err = ensureColumn("competition_services", "evidence_gz", "BLOB"); err != nil {
+		return err
+	}
+	if err = ensureColumn("competition_scores", "result_id", "INTEGER"); err != nil {
+		return err
+	}
+	if err = ensureColumn("competition_scores", "category", "TEXT"); err != nil {
+		return err
+	}
+	for _, col := range []string{"scoring_offset", "freeze_offset"} {
+		if err = ensureColumn("competition", col, "INTEGER NOT NULL DEFAULT 0"); err != nil {
+			return err
+		}
+	}
+	if err = ensureColumn("competition", "revealed", "BOOLEAN NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err = ensureColumn("competition", "inject_score_rule", "TEXT NOT NULL DEFAULT 'latest'"); err != nil {
+		return err
+	}
+	if err = ensureColumn("competition", "anonymize_teams", "BOOLEAN NOT NULL DEFAULT 0"); err != nil {
+		return err
+	}
+	if err = ensureColumn("teams", "alias", "TEXT"); err != nil {
+		return err
+	}
+	for _, col := range []string{"override_reason", "override_by", "overridden_at"} {
+		if err = ensureColumn("competition_services", col, "TEXT"); err != nil {
+			return err
+		}
+	}
+	for _, col := range []string{"checks_timed_out", "errors"} {
+		if err = ensureColumn("rounds", col, "INTEGER NOT NULL DEFAULT 0"); err != nil {
+			return err
+		}
+	}
+
 	// injects table
 	injectsTable := `
 	CREATE TABLE IF NOT EXISTS injects (
@@ -205,6 +471,8 @@ func CreateTables() error {
 		filename TEXT,
 		release_time INTEGER DEFAULT NULL,
 		due_time INTEGER DEFAULT NULL,
+		release_offset_minutes INTEGER NOT NULL DEFAULT 0,
+		due_offset_minutes INTEGER NOT NULL DEFAULT 0,
 		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
 	);`
 
@@ -219,6 +487,7 @@ func CreateTables() error {
 		score INTEGER,
 		reviewer TEXT,
 		notes TEXT,
+		scored_at TEXT,
 		FOREIGN KEY(team_id) REFERENCES teams(id)
 	);`
 
@@ -226,19 +495,84 @@ func CreateTables() error {
 	if err != nil {
 		return err
 	}
+	// columns added after the original schema
+	for _, col := range []string{"release_offset_minutes", "due_offset_minutes"} {
+		if err = ensureColumn("injects", col, "INTEGER NOT NULL DEFAULT 0"); err != nil {
+			return err
+		}
+	}
 
 	_, err = db.Exec(injectSubTable)
 	if err != nil {
 		return err
 	}
+	if err = ensureColumn("inject_submissions", "scored_at", "TEXT"); err != nil {
+		return err
+	}
+	// every grade given to a submission, so the final freeze can show the
+	// grade a regraded submission had when it began
+	injectGradesTable := `
+	CREATE TABLE IF NOT EXISTS inject_grades (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		submission_id INTEGER NOT NULL,
+		scored BOOLEAN NOT NULL,
+		score INTEGER,
+		graded_at TEXT NOT NULL,
+		FOREIGN KEY(submission_id) REFERENCES inject_submissions(id)
+	);`
+	if _, err = db.Exec(injectGradesTable); err != nil {
+		return err
+	}
+	// submissions graded before grades were kept start their history with
+	// that grade; one without a grading time counts from the start
+	_, err = db.Exec(`INSERT INTO inject_grades (submission_id, scored, score, graded_at)
+		SELECT id, scored, score, COALESCE(scored_at, '') FROM inject_submissions s
+		WHERE scored = 1 AND NOT EXISTS (SELECT 1 FROM inject_grades g WHERE g.submission_id = s.id)`)
+	if err != nil {
+		return err
+	}
 
 	// no separate mapping tables to create
 	return nil
 }
 
+// ensureColumn adds a column to an existing table, ignoring the error returned
+// when the column is already present.
+func ensureColumn(table, column, definition string) error {
+	// untimed: an existing column fails the statement, which is not a query
+	// error worth reporting
+	_, err := db.DB.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	if err != nil && strings.Contains(strings.ToLower(err.Error()), "duplicate column") {
+		return nil
+	}
+	return err
+}
+
+// uniqueResultSlots makes sure each team's service is recorded at most once
+// per check slot, so two engine instances that both believe they lead cannot
+// score a slot twice. Databases from before the index may hold such
+// duplicates; the first result stands and the later ones are removed along
+// with their points.
+func uniqueResultSlots() error {
+	dupes := `SELECT id FROM competition_services r WHERE slot IS NOT NULL AND EXISTS (
+		SELECT 1 FROM competition_services f
+		WHERE f.team_id = r.team_id AND f.service_id = r.service_id AND f.round = r.round AND f.slot = r.slot AND f.id < r.id)`
+	if _, err := db.Exec("DELETE FROM competition_scores WHERE result_id IN (" + dupes + ")"); err != nil {
+		return err
+	}
+	if _, err := db.Exec("DELETE FROM check_durations WHERE result_id IN (" + dupes + ")"); err != nil {
+		return err
+	}
+	if _, err := db.Exec("DELETE FROM competition_services WHERE id IN (" + dupes + ")"); err != nil {
+		return err
+	}
+	_, err := db.Exec("CREATE UNIQUE INDEX IF NOT EXISTS competition_services_slot ON competition_services (team_id, service_id, round, slot)")
+	return err
+}
+
 // Teams and scoring boxes helpers
 func GetAllTeams() ([]structures.Team, error) {
-	rows, err := db.Query("SELECT id, name FROM teams ORDER BY id ASC")
+	rows, err := db.Query("SELECT id, name, COALESCE(alias, '') FROM teams ORDER BY id ASC")
 	if err != nil {
 		return nil, err
 	}
@@ -246,7 +580,7 @@ func GetAllTeams() ([]structures.Team, error) {
 	var teams []structures.Team
 	for rows.Next() {
 		var t structures.Team
-		if err := rows.Scan(&t.ID, &t.Name); err != nil {
+		if err := rows.Scan(&t.ID, &t.Name, &t.Alias); err != nil {
 			return nil, err
 		}
 		teams = append(teams, t)
@@ -259,7 +593,7 @@ func CreateTeam(t *structures.Team) error {
 		return nil
 	}
 	if t.ID == 0 {
-		res, err := db.Exec("INSERT INTO teams (name) VALUES (?)", t.Name)
+		res, err := db.Exec("INSERT INTO teams (name, alias) VALUES (?, ?)", t.Name, t.Alias)
 		if err != nil {
 			return err
 		}
@@ -269,7 +603,7 @@ func CreateTeam(t *structures.Team) error {
 		}
 		return nil
 	}
-	_, err := db.Exec("UPDATE teams SET name = ? WHERE id = ?", t.Name, t.ID)
+	_, err := db.Exec("UPDATE teams SET name = ?, alias = ? WHERE id = ?", t.Name, t.Alias, t.ID)
 	return err
 }
 
@@ -368,7 +702,7 @@ func GetAllUsersWithTeams() ([]structures.User, error) {
 }
 
 func GetAllScoringBoxes() ([]structures.ScoringBox, error) {
-	rows, err := db.Query("SELECT id, ip_address, team_id, service_id FROM scored_boxes ORDER BY id ASC")
+	rows, err := db.Query("SELECT id, ip_address, team_id, service_id, agent_id FROM scored_boxes ORDER BY id ASC")
 	if err != nil {
 		return nil, err
 	}
@@ -376,9 +710,14 @@ func GetAllScoringBoxes() ([]structures.ScoringBox, error) {
 	var boxes []structures.ScoringBox
 	for rows.Next() {
 		var b structures.ScoringBox
-		if err := rows.Scan(&b.ID, &b.IPAddress, &b.TeamID, &b.ServiceID); err != nil {
+		var agentID sql.NullInt64
+		if err := rows.Scan(&b.ID, &b.IPAddress, &b.TeamID, &b.ServiceID, &agentID); err != nil {
 			return nil, err
 		}
+		if agentID.Valid {
+			aid := int(agentID.Int64)
+			b.AgentID = &aid
+		}
 		boxes = append(boxes, b)
 	}
 	return boxes, nil
@@ -490,36 +829,195 @@ func AddInjectSubmission(sub *structures.InjectSubmission) error {
 }
 
 func GetSubmissionsForInject(injectID string) ([]structures.InjectSubmission, error) {
-	rows, err := db.Query("SELECT id, inject_id, team_id, filename, submitted_at, scored, score, reviewer, notes FROM inject_submissions WHERE inject_id = ? ORDER BY submitted_at DESC", injectID)
+	rows, err := db.Query("SELECT "+submissionColumns+" FROM inject_submissions WHERE inject_id = ? ORDER BY submitted_at DESC", injectID)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 	var out []structures.InjectSubmission
 	for rows.Next() {
-		var s structures.InjectSubmission
-		if err := rows.Scan(&s.ID, &s.InjectID, &s.TeamID, &s.Filename, &s.SubmittedAt, &s.Scored, &s.Score, &s.Reviewer, &s.Notes); err != nil {
+		s, err := scanSubmission(rows)
+		if err != nil {
 			return nil, err
 		}
-		out = append(out, s)
+		out = append(out, *s)
 	}
 	return out, nil
 }
 
-func UpdateInjectSubmission(sub *structures.InjectSubmission) error {
+// GetAllInjectSubmissions returns every submission by team, inject and
+// submission time
+func GetAllInjectSubmissions() ([]structures.InjectSubmission, error) {
+	rows, err := db.Query("SELECT " + submissionColumns + " FROM inject_submissions ORDER BY team_id ASC, inject_id ASC, submitted_at ASC, id ASC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []structures.InjectSubmission
+	for rows.Next() {
+		s, err := scanSubmission(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, *s)
+	}
+	return out, rows.Err()
+}
+
+const submissionColumns = "id, inject_id, team_id, filename, submitted_at, scored, score, reviewer, notes, scored_at"
+
+func scanSubmission(row interface{ Scan(...interface{}) error }) (*structures.InjectSubmission, error) {
+	var s structures.InjectSubmission
+	var scoredAt sql.NullString
+	if err := row.Scan(&s.ID, &s.InjectID, &s.TeamID, &s.Filename, &s.SubmittedAt, &s.Scored, &s.Score, &s.Reviewer, &s.Notes, &scoredAt); err != nil {
+		return nil, err
+	}
+	s.ScoredAt = scoredAt.String
+	return &s, nil
+}
+
+// GetInjectSubmission returns a submission by id, or nil if there is none
+func GetInjectSubmission(id int) (*structures.InjectSubmission, error) {
+	s, err := scanSubmission(db.QueryRow("SELECT "+submissionColumns+" FROM inject_submissions WHERE id = ?", id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return s, err
+}
+
+// UpdateInjectSubmission saves a submission's grade and adds it to the
+// submission's grade history, dated by ScoredAt or, when it is being ungraded,
+// now.
+func UpdateInjectSubmission(sub *structures.InjectSubmission) (err error) {
 	if sub == nil || sub.ID == 0 {
 		return fmt.Errorf("invalid submission")
 	}
-	_, err := db.Exec("UPDATE inject_submissions SET scored = ?, score = ?, reviewer = ?, notes = ? WHERE id = ?", sub.Scored, sub.Score, sub.Reviewer, sub.Notes, sub.ID)
+	var scoredAt interface{}
+	gradedAt := time.Now().UTC().Format(time.RFC3339)
+	if sub.ScoredAt != "" {
+		scoredAt = sub.ScoredAt
+		gradedAt = sub.ScoredAt
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+	}()
+	_, err = tx.Exec("UPDATE inject_submissions SET scored = ?, score = ?, reviewer = ?, notes = ?, scored_at = ? WHERE id = ?", sub.Scored, sub.Score, sub.Reviewer, sub.Notes, scoredAt, sub.ID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec("INSERT INTO inject_grades (submission_id, scored, score, graded_at) VALUES (?, ?, ?, ?)", sub.ID, sub.Scored, sub.Score, gradedAt)
 	return err
 }
 
+// gradesBefore returns the latest grade each submission had before at, and
+// the IDs of every submission with a grade history. Submissions with a
+// history but no grade before at had not been graded yet.
+func gradesBefore(at string) (map[int]structures.InjectSubmission, map[int]bool, error) {
+	rows, err := db.Query("SELECT submission_id, scored, score, graded_at FROM inject_grades ORDER BY submission_id, id")
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	grades := make(map[int]structures.InjectSubmission)
+	history := make(map[int]bool)
+	for rows.Next() {
+		var g structures.InjectSubmission
+		if err := rows.Scan(&g.ID, &g.Scored, &g.Score, &g.ScoredAt); err != nil {
+			return nil, nil, err
+		}
+		history[g.ID] = true
+		if g.ScoredAt < at {
+			grades[g.ID] = g
+		}
+	}
+	return grades, history, rows.Err()
+}
+
+// Rules for which of a team's graded submissions for an inject counts toward
+// its standing
+const (
+	InjectRuleLatest = "latest"
+	InjectRuleBest   = "best"
+	InjectRuleFirst  = "first"
+)
+
+// GetCountedInjectSubmissions returns the graded submission that counts for
+// each team and inject under rule. When beforeRound is not 0 submissions are
+// taken with the grade they had when that round started, from their grade
+// history; submissions without one count when graded before then, or graded
+// before grading times were kept.
+func GetCountedInjectSubmissions(rule string, beforeRound int) ([]structures.InjectSubmission, error) {
+	gradedBefore, err := roundStartedAt(beforeRound)
+	if err != nil {
+		return nil, err
+	}
+	var grades map[int]structures.InjectSubmission
+	var history map[int]bool
+	if gradedBefore != "" {
+		if grades, history, err = gradesBefore(gradedBefore); err != nil {
+			return nil, err
+		}
+	}
+	// submissions graded later may have counted before with an earlier grade
+	rows, err := db.Query("SELECT "+submissionColumns+` FROM inject_submissions
+		WHERE ? <> '' OR (scored = 1 AND score IS NOT NULL)
+		ORDER BY team_id ASC, inject_id ASC, submitted_at ASC, id ASC`, gradedBefore)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []structures.InjectSubmission
+	for rows.Next() {
+		s, err := scanSubmission(rows)
+		if err != nil {
+			return nil, err
+		}
+		if gradedBefore != "" {
+			if g, ok := grades[s.ID]; ok {
+				s.Scored, s.Score, s.ScoredAt = g.Scored, g.Score, g.ScoredAt
+			} else if history[s.ID] || (s.ScoredAt != "" && s.ScoredAt >= gradedBefore) {
+				continue
+			}
+		}
+		if !s.Scored || s.Score == nil {
+			continue
+		}
+		n := len(out)
+		if n == 0 || out[n-1].TeamID != s.TeamID || out[n-1].InjectID != s.InjectID {
+			out = append(out, *s)
+			continue
+		}
+		// submissions come oldest first
+		switch rule {
+		case InjectRuleFirst:
+		case InjectRuleBest:
+			if *s.Score >= *out[n-1].Score {
+				out[n-1] = *s
+			}
+		default:
+			out[n-1] = *s
+		}
+	}
+	return out, rows.Err()
+}
+
 // DeleteInjectByInjectID deletes an inject and its associated submissions by inject_id
 func DeleteInjectByInjectID(injectID string) error {
 	if injectID == "" {
 		return nil
 	}
-	// delete submissions first
+	// delete grades and submissions first
+	if _, err := db.Exec("DELETE FROM inject_grades WHERE submission_id IN (SELECT id FROM inject_submissions WHERE inject_id = ?)", injectID); err != nil {
+		return err
+	}
 	if _, err := db.Exec("DELETE FROM inject_submissions WHERE inject_id = ?", injectID); err != nil {
 		return err
 	}
@@ -584,7 +1082,7 @@ func UpdateUser(user *structures.User) error {
 func GetAllServices() ([]structures.Service, error) {
 	services := []structures.Service{}
 
-	serviceRows, err := db.Query("SELECT id, name, description FROM services")
+	serviceRows, err := db.Query("SELECT id, name, description, check_interval, weight FROM services")
 	if err != nil {
 		return nil, err
 	}
@@ -592,12 +1090,18 @@ func GetAllServices() ([]structures.Service, error) {
 
 	for serviceRows.Next() {
 		var svc structures.Service
-		err := serviceRows.Scan(&svc.ID, &svc.Name, &svc.Host)
+		err := serviceRows.Scan(&svc.ID, &svc.Name, &svc.Host, &svc.Interval, &svc.Weight)
 		if err != nil {
 			return nil, err
 		}
 
-		checkRows, err := db.Query("SELECT id, name, command FROM service_checks WHERE service_id = ?", svc.ID)
+		checkRows, err := db.Query(`
+			SELECT sc.id, sc.name, sc.command, sc.check_type, COALESCE(sc.script, ''),
+			       COALESCE(sc.plugin, ''), COALESCE(sc.params, ''), COALESCE(cp.path, ''),
+			       sc.timeout_seconds, sc.retries, sc.retry_delay, sc.min_passes, COALESCE(sc.integrity, '')
+			FROM service_checks sc
+			LEFT JOIN check_plugins cp ON cp.name = sc.plugin
+			WHERE sc.service_id = ?`, svc.ID)
 		if err != nil {
 			return nil, err
 		}
@@ -606,12 +1110,27 @@ func GetAllServices() ([]structures.Service, error) {
 			var chk structures.Checks
 			// service_checks.id is an integer
 			var checkID int
-			err := checkRows.Scan(&checkID, &chk.Name, &chk.Command)
+			var params, integrity string
+			err := checkRows.Scan(&checkID, &chk.Name, &chk.Command, &chk.Type, &chk.Script, &chk.Plugin, &params, &chk.PluginPath,
+				&chk.TimeoutSeconds, &chk.Retries, &chk.RetryDelay, &chk.MinPasses, &integrity)
 			if err != nil {
 				checkRows.Close()
 				return nil, err
 			}
 			chk.ID = checkID
+			if params != "" {
+				if err := json.Unmarshal([]byte(params), &chk.Params); err != nil {
+					checkRows.Close()
+					return nil, fmt.Errorf("check %d params: %w", checkID, err)
+				}
+			}
+			if integrity != "" {
+				chk.Integrity = &structures.IntegrityCheck{}
+				if err := json.Unmarshal([]byte(integrity), chk.Integrity); err != nil {
+					checkRows.Close()
+					return nil, fmt.Errorf("check %d integrity: %w", checkID, err)
+				}
+			}
 
 			regexRows, err := db.Query("SELECT id, regex, expected FROM regex_checks WHERE service_check_id = ?", checkID)
 			if err != nil {
@@ -635,6 +1154,20 @@ func GetAllServices() ([]structures.Service, error) {
 			svc.Checks = append(svc.Checks, chk)
 		}
 		checkRows.Close()
+
+		depRows, err := db.Query("SELECT depends_on_id FROM service_dependencies WHERE service_id = ? ORDER BY depends_on_id", svc.ID)
+		if err != nil {
+			return nil, err
+		}
+		for depRows.Next() {
+			var dep int
+			if err := depRows.Scan(&dep); err != nil {
+				depRows.Close()
+				return nil, err
+			}
+			svc.DependsOn = append(svc.DependsOn, dep)
+		}
+		depRows.Close()
 		services = append(services, svc)
 	}
 
@@ -649,7 +1182,7 @@ func GetAllServices() ([]structures.Service, error) {
 func SaveService(svc *structures.Service) error {
 	// If ID is 0, it's a new service; otherwise update existing.
 	if svc.ID == 0 {
-		res, err := db.Exec("INSERT INTO services (name, description) VALUES (?, ?)", svc.Name, svc.Host)
+		res, err := db.Exec("INSERT INTO services (name, description, check_interval, weight) VALUES (?, ?, ?, ?)", svc.Name, svc.Host, svc.Interval, svc.Weight)
 		if err != nil {
 			return err
 		}
@@ -659,7 +1192,7 @@ func SaveService(svc *structures.Service) error {
 		}
 		svc.ID = int(lastID)
 	} else {
-		_, err := db.Exec("UPDATE services SET name = ?, description = ? WHERE id = ?", svc.Name, svc.Host, svc.ID)
+		_, err := db.Exec("UPDATE services SET name = ?, description = ?, check_interval = ?, weight = ? WHERE id = ?", svc.Name, svc.Host, svc.Interval, svc.Weight, svc.ID)
 		if err != nil {
 			return err
 		}
@@ -672,7 +1205,27 @@ func SaveService(svc *structures.Service) error {
 	}
 
 	for _, chk := range svc.Checks {
-		res, err := db.Exec("INSERT INTO service_checks (service_id, name, command) VALUES (?, ?, ?)", svc.ID, chk.Name, chk.Command)
+		checkType := chk.Type
+		if checkType == "" {
+			checkType = "command"
+		}
+		var params []byte
+		if len(chk.Params) > 0 {
+			if params, err = json.Marshal(chk.Params); err != nil {
+				return err
+			}
+		}
+		var integrity []byte
+		if chk.Integrity != nil {
+			if integrity, err = json.Marshal(chk.Integrity); err != nil {
+				return err
+			}
+		}
+		res, err := db.Exec(`INSERT INTO service_checks
+			(service_id, name, command, check_type, script, plugin, params, timeout_seconds, retries, retry_delay, min_passes, integrity)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			svc.ID, chk.Name, chk.Command, checkType, chk.Script, chk.Plugin, string(params),
+			chk.TimeoutSeconds, chk.Retries, chk.RetryDelay, chk.MinPasses, string(integrity))
 		if err != nil {
 			return err
 		}
@@ -689,12 +1242,24 @@ func SaveService(svc *structures.Service) error {
 		}
 	}
 
-	return nil
-}
-
-func DeleteServiceByID(id int) error {
-	// Delete regexes, checks, and then the service itself.
-	_, err := db.Exec("DELETE FROM regex_checks WHERE service_check_id IN (SELECT id FROM service_checks WHERE service_id = ?)", id)
+	if _, err := db.Exec("DELETE FROM service_dependencies WHERE service_id = ?", svc.ID); err != nil {
+		return err
+	}
+	for _, dep := range svc.DependsOn {
+		if dep == svc.ID {
+			continue
+		}
+		if _, err := db.Exec("INSERT INTO service_dependencies (service_id, depends_on_id) VALUES (?, ?)", svc.ID, dep); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func DeleteServiceByID(id int) error {
+	// Delete regexes, checks, and then the service itself.
+	_, err := db.Exec("DELETE FROM regex_checks WHERE service_check_id IN (SELECT id FROM service_checks WHERE service_id = ?)", id)
 	if err != nil {
 		return err
 	}
@@ -704,6 +1269,16 @@ func DeleteServiceByID(id int) error {
 		return err
 	}
 
+	_, err = db.Exec("DELETE FROM service_dependencies WHERE service_id = ? OR depends_on_id = ?", id, id)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec("DELETE FROM content_baselines WHERE service_id = ?", id)
+	if err != nil {
+		return err
+	}
+
 	_, err = db.Exec("DELETE FROM services WHERE id = ?", id)
 	return err
 }
@@ -730,6 +1305,8 @@ type TeamStanding struct {
 	TeamID int
 	Name   string
 	Points int
+	// InjectPoints is the part of Points from graded injects
+	InjectPoints int
 }
 
 // RoundScore represents points per team per round
@@ -739,8 +1316,36 @@ type RoundScore struct {
 	Points int
 }
 
-// GetLatestStatuses returns the latest status per team/service based on max round
-func GetLatestStatuses() ([]LatestStatus, error) {
+// Categories of competition_scores rows
+const (
+	// ScoreService rows are the points a check result earned
+	ScoreService = "service"
+	// ScoreRescore and ScoreOverride rows correct a check result's points
+	ScoreRescore  = "rescore"
+	ScoreOverride = "override"
+	// the rest are added by hand
+	ScoreAdjustment = "adjustment"
+	ScoreSLA        = "sla"
+	ScoreRedTeam    = "red_team"
+)
+
+// ScoreEntry is a row of competition_scores. Rows for a check result carry
+// its service and status.
+type ScoreEntry struct {
+	ID          int
+	Round       int // 0 for adjustments not tied to a round
+	Score       int
+	Category    string
+	Description string
+	Timestamp   string
+	ResultID    int
+	ServiceID   int
+	IsUp        bool
+}
+
+// GetLatestStatuses returns the latest status per team/service based on max round,
+// limited to rounds before beforeRound when it is not 0
+func GetLatestStatuses(beforeRound int) ([]LatestStatus, error) {
 	// Join with subquery to get latest round per team/service
 	q := `
 		SELECT cs.team_id, cs.service_id, cs.is_up
@@ -748,11 +1353,12 @@ func GetLatestStatuses() ([]LatestStatus, error) {
 		JOIN (
 			SELECT team_id, service_id, MAX(round) AS mr
 			FROM competition_services
+			WHERE ? = 0 OR round < ?
 			GROUP BY team_id, service_id
 		) t
 		ON cs.team_id = t.team_id AND cs.service_id = t.service_id AND cs.round = t.mr
 	`
-	rows, err := db.Query(q)
+	rows, err := db.Query(q, beforeRound, beforeRound)
 	if err != nil {
 		return nil, err
 	}
@@ -770,16 +1376,18 @@ func GetLatestStatuses() ([]LatestStatus, error) {
 	return out, nil
 }
 
-// GetServiceUptimePercents returns uptime percentage for each service across all teams/rounds
-func GetServiceUptimePercents() (map[int]float64, error) {
+// GetServiceUptimePercents returns uptime percentage for each service across all teams/rounds,
+// limited to rounds before beforeRound when it is not 0
+func GetServiceUptimePercents(beforeRound int) (map[int]float64, error) {
 	q := `
 		SELECT service_id,
 			   SUM(CASE WHEN is_up THEN 1 ELSE 0 END) AS up_count,
 			   COUNT(*) AS total_count
 		FROM competition_services
+		WHERE ? = 0 OR round < ?
 		GROUP BY service_id
 	`
-	rows, err := db.Query(q)
+	rows, err := db.Query(q, beforeRound, beforeRound)
 	if err != nil {
 		return nil, err
 	}
@@ -800,16 +1408,102 @@ func GetServiceUptimePercents() (map[int]float64, error) {
 	return m, nil
 }
 
-// GetTeamStandings returns total points per team (1 point per up per record)
-func GetTeamStandings() ([]TeamStanding, error) {
+// RoundStatus counts a team's checks of a service in one round.
+type RoundStatus struct {
+	TeamID    int
+	ServiceID int
+	Round     int
+	Up        int
+	Total     int
+}
+
+// GetRoundStatuses returns how many checks of each team's services passed in
+// each round, limited to rounds before beforeRound when it is not 0.
+func GetRoundStatuses(beforeRound int) ([]RoundStatus, error) {
+	q := `
+		SELECT team_id, service_id, round,
+			   SUM(CASE WHEN is_up THEN 1 ELSE 0 END) AS up_count,
+			   COUNT(*) AS total_count
+		FROM competition_services
+		WHERE ? = 0 OR round < ?
+		GROUP BY team_id, service_id, round
+		ORDER BY round ASC
+	`
+	rows, err := db.Query(q, beforeRound, beforeRound)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []RoundStatus
+	for rows.Next() {
+		var rs RoundStatus
+		if err := rows.Scan(&rs.TeamID, &rs.ServiceID, &rs.Round, &rs.Up, &rs.Total); err != nil {
+			return nil, err
+		}
+		out = append(out, rs)
+	}
+	return out, rows.Err()
+}
+
+// settledRound limits competition_scores rows, aliased cs, to rounds the
+// engine has finished recording, so a round still running, or left partial
+// by a crash or a failed batch, never shows up in the standings. Rows without
+// a round, or from before rounds were logged, always count.
+const settledRound = `(cs.round IS NULL OR NOT EXISTS (
+	SELECT 1 FROM rounds rd WHERE rd.number = cs.round AND rd.status <> '` + RoundComplete + `'))`
+
+// shownBefore limits competition_scores rows, aliased cs, to rounds before
+// the first parameter when it is not 0. Rows without a round are limited to
+// those added before the second parameter, the time that round started ("" when
+// it has not), so adjustments made during the final freeze stay hidden too.
+// Both parameters are passed twice.
+const shownBefore = `(? = 0 OR cs.round < ? OR (cs.round IS NULL AND (? = '' OR julianday(cs.timestamp) < julianday(?))))`
+
+// roundStartedAt returns when a round started, or "" when number is 0 or the
+// round has not started.
+func roundStartedAt(number int) (string, error) {
+	if number == 0 {
+		return "", nil
+	}
+	var startedAt sql.NullString
+	err := db.QueryRow("SELECT started_at FROM rounds WHERE number = ?", number).Scan(&startedAt)
+	if err != nil && err != sql.ErrNoRows {
+		return "", err
+	}
+	return startedAt.String, nil
+}
+
+// GetTeamStandings returns total points per team: 1 point per up per record
+// plus the graded inject submissions counted under the competition's inject
+// rule. Only rounds that completed count (see settledRound). When beforeRound
+// is not 0 only points from earlier rounds, and adjustments and injects graded
+// before that round started, are counted. Teams are ranked by points, ties
+// broken by team ID.
+func GetTeamStandings(beforeRound int) ([]TeamStanding, error) {
+	comp, err := GetCompetition()
+	if err != nil {
+		return nil, err
+	}
+	subs, err := GetCountedInjectSubmissions(comp.InjectScoreRule, beforeRound)
+	if err != nil {
+		return nil, err
+	}
+	injectPoints := make(map[int]int)
+	for _, s := range subs {
+		injectPoints[s.TeamID] += *s.Score
+	}
+
 	q := `
 		SELECT t.id, t.name, COALESCE(SUM(cs.score), 0) AS points
 		FROM teams t
-		LEFT JOIN competition_scores cs ON cs.team_id = t.id
+		LEFT JOIN competition_scores cs ON cs.team_id = t.id AND ` + shownBefore + ` AND ` + settledRound + `
 		GROUP BY t.id, t.name
-		ORDER BY points DESC, t.name ASC
 	`
-	rows, err := db.Query(q)
+	frozenAt, err := roundStartedAt(beforeRound)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.Query(q, beforeRound, beforeRound, frozenAt, frozenAt)
 	if err != nil {
 		return nil, err
 	}
@@ -820,43 +1514,165 @@ func GetTeamStandings() ([]TeamStanding, error) {
 		if err := rows.Scan(&ts.TeamID, &ts.Name, &ts.Points); err != nil {
 			return nil, err
 		}
+		ts.InjectPoints = injectPoints[ts.TeamID]
+		ts.Points += ts.InjectPoints
 		out = append(out, ts)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		if out[i].Points != out[j].Points {
+			return out[i].Points > out[j].Points
+		}
+		return out[i].TeamID < out[j].TeamID
+	})
 	return out, nil
 }
 
-// GetTeamScoresByRound returns points per team per round
-func GetTeamScoresByRound() ([]RoundScore, error) {
+// roundStartedBy returns the latest of rounds, which are newest first, that
+// started at or before at (RFC3339 UTC), or nil if none had.
+func roundStartedBy(rounds []structures.Round, at string) *structures.Round {
+	if at == "" {
+		return nil
+	}
+	for i, r := range rounds {
+		if r.StartedAt != "" && r.StartedAt <= at {
+			return &rounds[i]
+		}
+	}
+	return nil
+}
+
+// GetRoundStartedBy returns the number of the latest round that started at or
+// before at (RFC3339 UTC), or 0 if none had.
+func GetRoundStartedBy(at string) (int, error) {
+	rounds, err := GetRounds(0)
+	if err != nil {
+		return 0, err
+	}
+	if r := roundStartedBy(rounds, at); r != nil {
+		return r.Number, nil
+	}
+	return 0, nil
+}
+
+// GetTeamScoresByRound returns points per team per completed round, limited
+// to rounds before beforeRound when it is not 0. Graded injects counted under
+// the competition's inject rule are added to the round in which they were
+// graded. Adjustments not tied to a round, and injects graded before the first
+// round, are left out.
+func GetTeamScoresByRound(beforeRound int) ([]RoundScore, error) {
 	q := `
-		SELECT round, team_id, SUM(score) AS points
-		FROM competition_scores
-		GROUP BY round, team_id
-		ORDER BY round ASC, team_id ASC
+		SELECT cs.round, cs.team_id, SUM(cs.score) AS points
+		FROM competition_scores cs
+		WHERE cs.round IS NOT NULL AND (? = 0 OR cs.round < ?) AND ` + settledRound + `
+		GROUP BY cs.round, cs.team_id
+		ORDER BY cs.round ASC, cs.team_id ASC
 	`
-	rows, err := db.Query(q)
+	rows, err := db.Query(q, beforeRound, beforeRound)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 	var out []RoundScore
+	index := make(map[[2]int]int)
 	for rows.Next() {
 		var r RoundScore
 		if err := rows.Scan(&r.Round, &r.TeamID, &r.Points); err != nil {
 			return nil, err
 		}
+		index[[2]int{r.Round, r.TeamID}] = len(out)
 		out = append(out, r)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	comp, err := GetCompetition()
+	if err != nil {
+		return nil, err
+	}
+	subs, err := GetCountedInjectSubmissions(comp.InjectScoreRule, beforeRound)
+	if err != nil {
+		return nil, err
+	}
+	rounds, err := GetRounds(0)
+	if err != nil {
+		return nil, err
+	}
+	for _, sub := range subs {
+		// like service points, only rounds that completed show
+		rd := roundStartedBy(rounds, sub.ScoredAt)
+		if rd == nil || rd.Status != RoundComplete {
+			continue
+		}
+		key := [2]int{rd.Number, sub.TeamID}
+		if i, ok := index[key]; ok {
+			out[i].Points += *sub.Score
+			continue
+		}
+		index[key] = len(out)
+		out = append(out, RoundScore{Round: rd.Number, TeamID: sub.TeamID, Points: *sub.Score})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Round != out[j].Round {
+			return out[i].Round < out[j].Round
+		}
+		return out[i].TeamID < out[j].TeamID
+	})
 	return out, nil
 }
 
+// GetTeamScoreEntries returns a team's competition_scores rows in round
+// order for completed rounds, limited to rounds before beforeRound when it is
+// not 0 (adjustments not tied to a round are included when they were made
+// before it started). Rows written before categories were recorded are
+// categorized from their result and description.
+func GetTeamScoreEntries(teamID, beforeRound int) ([]ScoreEntry, error) {
+	q := `
+		SELECT cs.id, COALESCE(cs.round, 0), cs.score,
+			COALESCE(cs.category, CASE
+				WHEN cs.result_id IS NULL THEN 'adjustment'
+				WHEN cs.description LIKE 'Rescore %' THEN 'rescore'
+				WHEN cs.description LIKE 'Override %' THEN 'override'
+				ELSE 'service' END),
+			COALESCE(cs.description, ''), COALESCE(cs.timestamp, ''), COALESCE(cs.result_id, 0),
+			COALESCE(r.service_id, 0), COALESCE(r.is_up, 0)
+		FROM competition_scores cs
+		LEFT JOIN competition_services r ON r.id = cs.result_id
+		WHERE cs.team_id = ? AND ` + shownBefore + ` AND ` + settledRound + `
+		ORDER BY COALESCE(cs.round, 0) ASC, cs.id ASC
+	`
+	frozenAt, err := roundStartedAt(beforeRound)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.Query(q, teamID, beforeRound, beforeRound, frozenAt, frozenAt)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []ScoreEntry
+	for rows.Next() {
+		var e ScoreEntry
+		if err := rows.Scan(&e.ID, &e.Round, &e.Score, &e.Category, &e.Description, &e.Timestamp, &e.ResultID, &e.ServiceID, &e.IsUp); err != nil {
+			return nil, err
+		}
+		out = append(out, e)
+	}
+	return out, rows.Err()
+}
+
 // Competition management functions
 
 // GetCompetition returns the current competition state (there should only be one)
 func GetCompetition() (*structures.Competition, error) {
-	row := db.QueryRow("SELECT id, status, scheduled_time, started_time, stopped_time FROM competition ORDER BY id DESC LIMIT 1")
+	row := db.QueryRow("SELECT id, status, scheduled_time, started_time, stopped_time, round_interval, forgive_dependencies, scoring_offset, freeze_offset, revealed, inject_score_rule, anonymize_teams FROM competition ORDER BY id DESC LIMIT 1")
 	var comp structures.Competition
 	var scheduledTime, startedTime, stoppedTime sql.NullString
-	err := row.Scan(&comp.ID, &comp.Status, &scheduledTime, &startedTime, &stoppedTime)
+	var roundInterval sql.NullInt64
+	err := row.Scan(&comp.ID, &comp.Status, &scheduledTime, &startedTime, &stoppedTime, &roundInterval, &comp.ForgiveDependencies, &comp.ScoringOffset, &comp.FreezeOffset, &comp.Revealed, &comp.InjectScoreRule, &comp.AnonymizeTeams)
 	if err == sql.ErrNoRows {
 		// No competition exists, create a default one
 		_, err = db.Exec("INSERT INTO competition (status) VALUES ('stopped')")
@@ -872,6 +1688,7 @@ func GetCompetition() (*structures.Competition, error) {
 	comp.ScheduledTime = scheduledTime.String
 	comp.StartedTime = startedTime.String
 	comp.StoppedTime = stoppedTime.String
+	comp.RoundInterval = int(roundInterval.Int64)
 	return &comp, nil
 }
 
@@ -888,8 +1705,8 @@ func UpdateCompetition(comp *structures.Competition) error {
 	}
 
 	// Update the existing competition
-	query := "UPDATE competition SET status = ?, scheduled_time = ?, started_time = ?, stopped_time = ? WHERE id = ?"
-	var scheduledTime, startedTime, stoppedTime interface{}
+	query := "UPDATE competition SET status = ?, scheduled_time = ?, started_time = ?, stopped_time = ?, round_interval = ?, forgive_dependencies = ?, scoring_offset = ?, freeze_offset = ?, revealed = ?, inject_score_rule = ?, anonymize_teams = ? WHERE id = ?"
+	var scheduledTime, startedTime, stoppedTime, roundInterval interface{}
 
 	if comp.ScheduledTime != "" {
 		scheduledTime = comp.ScheduledTime
@@ -900,13 +1717,23 @@ func UpdateCompetition(comp *structures.Competition) error {
 	if comp.StoppedTime != "" {
 		stoppedTime = comp.StoppedTime
 	}
+	if comp.RoundInterval > 0 {
+		roundInterval = comp.RoundInterval
+	}
+	injectRule := comp.InjectScoreRule
+	if injectRule == "" {
+		injectRule = InjectRuleLatest
+	}
 
-	_, err = db.Exec(query, comp.Status, scheduledTime, startedTime, stoppedTime, existing.ID)
+	_, err = db.Exec(query, comp.Status, scheduledTime, startedTime, stoppedTime, roundInterval, comp.ForgiveDependencies, comp.ScoringOffset, comp.FreezeOffset, comp.Revealed, injectRule, comp.AnonymizeTeams, existing.ID)
 	return err
 }
 
 // ResetCompetitionServices deletes all competition services
 func ResetCompetitionServices() error {
+	if _, err := db.Exec("DELETE FROM check_durations"); err != nil {
+		return err
+	}
 	if _, err := db.Exec("DELETE FROM competition_services"); err != nil {
 		return err
 	}
@@ -915,6 +1742,10 @@ func ResetCompetitionServices() error {
 		return err
 	}
 
+	if _, err := db.Exec("DELETE FROM rounds"); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -938,9 +1769,19 @@ func ResetAllScoringData() error {
 	if _, err = tx.Exec("DELETE FROM competition_scores"); err != nil {
 		return err
 	}
+	if _, err = tx.Exec("DELETE FROM check_durations"); err != nil {
+		return err
+	}
 	if _, err = tx.Exec("DELETE FROM competition_services"); err != nil {
 		return err
 	}
+	if _, err = tx.Exec("DELETE FROM rounds"); err != nil {
+		return err
+	}
+	// baselines belong to the competition they were captured for
+	if _, err = tx.Exec("DELETE FROM content_baselines"); err != nil {
+		return err
+	}
 
 	// Reset competition metadata to stopped and clear times
 	if _, err = tx.Exec("UPDATE competition SET status = 'stopped', scheduled_time = NULL, started_time = NULL, stopped_time = NULL"); err != nil {
@@ -963,16 +1804,18 @@ func GetTeamScore(teamID int) (int, error) {
 	return 0, nil
 }
 
-// GetTeamServiceUptimePercents returns uptime percentage for each team/service
-func GetTeamServiceUptimePercents() (map[int]map[int]float64, error) {
+// GetTeamServiceUptimePercents returns uptime percentage for each team/service,
+// limited to rounds before beforeRound when it is not 0
+func GetTeamServiceUptimePercents(beforeRound int) (map[int]map[int]float64, error) {
 	q := `
 		SELECT team_id, service_id,
 			   SUM(CASE WHEN is_up THEN 1 ELSE 0 END) AS up_count,
 			   COUNT(*) AS total_count
 		FROM competition_services
+		WHERE ? = 0 OR round < ?
 		GROUP BY team_id, service_id
 	`
-	rows, err := db.Query(q)
+	rows, err := db.Query(q, beforeRound, beforeRound)
 	if err != nil {
 		return nil, err
 	}
@@ -1002,27 +1845,37 @@ type CompetitionServiceRecord struct {
 	IsUp      bool   `json:"is_up"`
 	Output    string `json:"output"`
 	Round     int    `json:"round"`
+	RootCause string `json:"root_cause,omitempty"`
 	Timestamp string `json:"timestamp"`
+	ID        int    `json:"id"`
+	// Points currently credited to the result, including corrections
+	Points         int    `json:"points"`
+	OverrideReason string `json:"override_reason,omitempty"`
+	OverrideBy     string `json:"override_by,omitempty"`
+	OverriddenAt   string `json:"overridden_at,omitempty"`
 }
 
 // GetCompetitionServiceHistory returns competition_services rows for a given team/service
 // If teamID or serviceID is 0, that filter is ignored.
 func GetCompetitionServiceHistory(teamID, serviceID int) ([]CompetitionServiceRecord, error) {
-	q := `SELECT team_id, service_id, is_up, output, round, timestamp FROM competition_services`
+	q := `SELECT r.team_id, r.service_id, r.is_up, COALESCE(r.output, ''), r.output_gz, r.round, COALESCE(r.root_cause, ''), r.timestamp,
+		r.id, (SELECT COALESCE(SUM(s.score), 0) FROM competition_scores s WHERE s.result_id = r.id),
+		COALESCE(r.override_reason, ''), COALESCE(r.override_by, ''), COALESCE(r.overridden_at, '')
+		FROM competition_services r`
 	var args []interface{}
 	var where []string
 	if teamID != 0 {
-		where = append(where, "team_id = ?")
+		where = append(where, "r.team_id = ?")
 		args = append(args, teamID)
 	}
 	if serviceID != 0 {
-		where = append(where, "service_id = ?")
+		where = append(where, "r.service_id = ?")
 		args = append(args, serviceID)
 	}
 	if len(where) > 0 {
 		q = q + " WHERE " + strings.Join(where, " AND ")
 	}
-	q = q + " ORDER BY round ASC, timestamp ASC"
+	q = q + " ORDER BY r.round ASC, r.timestamp ASC"
 
 	rows, err := db.Query(q, args...)
 	if err != nil {
@@ -1034,26 +1887,58 @@ func GetCompetitionServiceHistory(teamID, serviceID int) ([]CompetitionServiceRe
 	for rows.Next() {
 		var r CompetitionServiceRecord
 		var isUp bool
-		if err := rows.Scan(&r.TeamID, &r.ServiceID, &isUp, &r.Output, &r.Round, &r.Timestamp); err != nil {
+		var compressed []byte
+		if err := rows.Scan(&r.TeamID, &r.ServiceID, &isUp, &r.Output, &compressed, &r.Round, &r.RootCause, &r.Timestamp,
+			&r.ID, &r.Points, &r.OverrideReason, &r.OverrideBy, &r.OverriddenAt); err != nil {
 			return nil, err
 		}
 		r.IsUp = isUp
+		if r.Output, err = decodeOutput(r.Output, compressed); err != nil {
+			return nil, err
+		}
 		out = append(out, r)
 	}
 	return out, nil
 }
 
-// AddCompetitionScoreAdjustment inserts an adjustment into competition_scores
-func AddCompetitionScoreAdjustment(teamID int, score int, round int, description string) (int, error) {
+// GetLatestFailures returns the most recent down result of each of a team's
+// services, for services that have failed at least once.
+func GetLatestFailures(teamID int) ([]CompetitionServiceRecord, error) {
+	rows, err := db.Query(`SELECT r.team_id, r.service_id, r.is_up, COALESCE(r.output, ''), r.output_gz, r.round, COALESCE(r.root_cause, ''), r.timestamp, r.id
+		FROM competition_services r
+		WHERE r.id IN (SELECT MAX(id) FROM competition_services WHERE team_id = ? AND is_up = 0 GROUP BY service_id)
+		ORDER BY r.service_id ASC`, teamID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []CompetitionServiceRecord
+	for rows.Next() {
+		var r CompetitionServiceRecord
+		var compressed []byte
+		if err := rows.Scan(&r.TeamID, &r.ServiceID, &r.IsUp, &r.Output, &compressed, &r.Round, &r.RootCause, &r.Timestamp, &r.ID); err != nil {
+			return nil, err
+		}
+		if r.Output, err = decodeOutput(r.Output, compressed); err != nil {
+			return nil, err
+		}
+		out = append(out, r)
+	}
+	return out, rows.Err()
+}
+
+// AddCompetitionScoreAdjustment inserts an adjustment into competition_scores.
+// category is ScoreAdjustment, ScoreSLA or ScoreRedTeam.
+func AddCompetitionScoreAdjustment(teamID int, score int, round int, category string, description string) (int, error) {
 	if teamID == 0 {
 		return 0, fmt.Errorf("team_id required")
 	}
 	var res sql.Result
 	var err error
 	if round > 0 {
-		res, err = db.Exec("INSERT INTO competition_scores (team_id, score, round, description) VALUES (?, ?, ?, ?)", teamID, score, round, description)
+		res, err = db.Exec("INSERT INTO competition_scores (team_id, score, round, category, description) VALUES (?, ?, ?, ?, ?)", teamID, score, round, category, description)
 	} else {
-		res, err = db.Exec("INSERT INTO competition_scores (team_id, score, description) VALUES (?, ?, ?)", teamID, score, description)
+		res, err = db.Exec("INSERT INTO competition_scores (team_id, score, category, description) VALUES (?, ?, ?, ?)", teamID, score, category, description)
 	}
 	if err != nil {
 		return 0, err
@@ -1070,7 +1955,7 @@ func AddCompetitionScoreAdjustment(teamID int, score int, round int, description
 // is returned.
 func GetUserTeamBySubject(subject string) (*structures.Team, error) {
 	row := db.QueryRow(`
-		SELECT t.id, t.name
+		SELECT t.id, t.name, COALESCE(t.alias, '')
 		FROM teams t
 		JOIN team_members tm ON t.id = tm.team_id
 		JOIN users u ON u.id = tm.user_id
@@ -1078,7 +1963,7 @@ func GetUserTeamBySubject(subject string) (*structures.Team, error) {
 		LIMIT 1
 	`, subject)
 	var t structures.Team
-	if err := row.Scan(&t.ID, &t.Name); err != nil {
+	if err := row.Scan(&t.ID, &t.Name, &t.Alias); err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil
 		}
@@ -1086,3 +1971,825 @@ func GetUserTeamBySubject(subject string) (*structures.Team, error) {
 	}
 	return &t, nil
 }
+
+// ErrNotLeader is returned by RecordResults when the engine instance
+// recording the results no longer holds the leader lease.
+var ErrNotLeader = errors.New("this instance no longer holds the leader lease")
+
+// RecordResults stores a batch of check results in competition_services
+// together with the points each earned (points[i] for results[i]) in
+// competition_scores, and adds them to their rounds' check counts. The batch is
+// written in a single transaction so a crash never leaves a partial batch.
+// Results for a slot that is already recorded are skipped; the number stored
+// is returned. When leader is not empty the batch comes from that engine
+// instance, and is only committed while it still holds an unexpired lease.
+func RecordResults(results []structures.CheckResult, points []int, leader string) (recorded int, err error) {
+	if len(points) != len(results) {
+		return 0, fmt.Errorf("%d results but %d point values", len(results), len(points))
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+			recorded = 0
+		} else if err = tx.Commit(); err != nil {
+			recorded = 0
+		}
+	}()
+
+	type counts struct{ up, down, timedOut int }
+	perRound := make(map[int]*counts)
+	for i, res := range results {
+		var rootCause interface{}
+		if res.RootCause != "" {
+			rootCause = res.RootCause
+		}
+		slot := res.Slot
+		if slot == 0 {
+			slot = res.Round
+		}
+		output, compressed, err := encodeOutput(res.Output)
+		if err != nil {
+			return 0, err
+		}
+		evidence, err := encodeEvidence(res.Evidence)
+		if err != nil {
+			return 0, err
+		}
+		inserted, err := tx.Exec("INSERT OR IGNORE INTO competition_services (team_id, service_id, is_up, output, output_gz, round, slot, root_cause, duration_ms, timed_out, evidence_gz) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+			res.TeamID, res.ServiceID, res.IsUp, output, compressed, res.Round, slot, rootCause, res.DurationMs, res.TimedOut, evidence)
+		if err != nil {
+			return 0, err
+		}
+		if n, err := inserted.RowsAffected(); err != nil {
+			return 0, err
+		} else if n == 0 {
+			// another instance or agent already recorded this slot
+			continue
+		}
+		resultID, err := inserted.LastInsertId()
+		if err != nil {
+			return 0, err
+		}
+		recorded++
+		for _, ev := range res.Evidence {
+			if _, err = tx.Exec("INSERT INTO check_durations (result_id, round, check_name, duration_ms) VALUES (?, ?, ?, ?)",
+				resultID, res.Round, ev.Check, ev.DurationMs()); err != nil {
+				return 0, err
+			}
+		}
+		for _, b := range res.Baselines {
+			// the first capture stands; admins recapture deliberately
+			if _, err = tx.Exec(`INSERT OR IGNORE INTO content_baselines
+				(team_id, service_id, check_name, url, hash, text, captured_at)
+				VALUES (?, ?, ?, ?, ?, ?, ?)`,
+				res.TeamID, res.ServiceID, b.Check, b.URL, b.Hash, b.Text, b.CapturedAt); err != nil {
+				return 0, err
+			}
+		}
+		desc := fmt.Sprintf("Score for team %d service %d round %d", res.TeamID, res.ServiceID, res.Round)
+		if _, err = tx.Exec("INSERT INTO competition_scores (team_id, score, round, description, result_id, category) VALUES (?, ?, ?, ?, ?, ?)", res.TeamID, points[i], res.Round, desc, resultID, ScoreService); err != nil {
+			return 0, err
+		}
+		c := perRound[res.Round]
+		if c == nil {
+			c = &counts{}
+			perRound[res.Round] = c
+		}
+		if res.IsUp {
+			c.up++
+		} else {
+			c.down++
+		}
+		if res.TimedOut {
+			c.timedOut++
+		}
+	}
+
+	for round, c := range perRound {
+		// results can arrive for a round the engine did not start, e.g. from
+		// agents while the engine is down
+		if err = insertRound(tx, structures.Round{Number: round, Status: RoundRunning}); err != nil {
+			return 0, err
+		}
+		if _, err = tx.Exec("UPDATE rounds SET checks_total = checks_total + ?, checks_up = checks_up + ?, checks_down = checks_down + ?, checks_timed_out = checks_timed_out + ? WHERE number = ?",
+			c.up+c.down, c.up, c.down, c.timedOut, round); err != nil {
+			return 0, err
+		}
+	}
+
+	if leader != "" {
+		// checked last, once the writes above hold the database's write lock,
+		// so the lease cannot change hands before the commit
+		var held int
+		if err = tx.QueryRow("SELECT COUNT(*) FROM engine_lease WHERE id = 1 AND holder = ? AND expires_at >= ?",
+			leader, time.Now().UTC().Format(time.RFC3339)).Scan(&held); err != nil {
+			return 0, err
+		}
+		if held == 0 {
+			return 0, ErrNotLeader
+		}
+	}
+	return recorded, nil
+}
+
+// Round statuses stored in rounds.status.
+const (
+	RoundRunning = "running"
+	// RoundComplete rounds had every batch of checks recorded
+	RoundComplete = "complete"
+	// RoundFailed rounds had a batch that could not be recorded
+	RoundFailed = "failed"
+	// RoundIncomplete rounds were still running when the engine stopped
+	RoundIncomplete = "incomplete"
+)
+
+// insertRound adds a rounds row unless the round already has one.
+func insertRound(tx *timedTx, r structures.Round) error {
+	var exists int
+	err := tx.QueryRow("SELECT COUNT(*) FROM rounds WHERE number = ?", r.Number).Scan(&exists)
+	if err != nil || exists > 0 {
+		return err
+	}
+	_, err = tx.Exec("INSERT INTO rounds (number, started_at, seed, status) VALUES (?, ?, ?, ?)", r.Number, r.StartedAt, r.Seed, r.Status)
+	return err
+}
+
+// StartRound records that the engine has started a round. A round that
+// already has a row (e.g. from agent results) gets its start time and seed.
+func StartRound(number int, startedAt string, seed int64) (err error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+	}()
+	if err = insertRound(tx, structures.Round{Number: number, StartedAt: startedAt, Seed: seed, Status: RoundRunning}); err != nil {
+		return err
+	}
+	_, err = tx.Exec("UPDATE rounds SET started_at = ?, seed = ?, status = ? WHERE number = ?", startedAt, seed, RoundRunning, number)
+	return err
+}
+
+// FinishRound sets the end time and final status of a round. A failed round
+// stays failed.
+func FinishRound(number int, endedAt, status string) error {
+	_, err := db.Exec("UPDATE rounds SET ended_at = ?, status = CASE WHEN status = ? THEN status ELSE ? END WHERE number = ?",
+		endedAt, RoundFailed, status, number)
+	return err
+}
+
+// RecordRoundError counts a batch of checks for the round that could not be
+// recorded and marks the round failed.
+func RecordRoundError(number int) error {
+	_, err := db.Exec("UPDATE rounds SET errors = errors + 1, status = ? WHERE number = ?", RoundFailed, number)
+	return err
+}
+
+// GetCheckDurations returns the duration in milliseconds of every check
+// recorded from round fromRound on, each check of a service counted on its
+// own.
+func GetCheckDurations(fromRound int) ([]int, error) {
+	rows, err := db.Query("SELECT duration_ms FROM check_durations WHERE round >= ?", fromRound)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []int
+	for rows.Next() {
+		var d int
+		if err := rows.Scan(&d); err != nil {
+			return nil, err
+		}
+		out = append(out, d)
+	}
+	return out, rows.Err()
+}
+
+// GetOpenRounds returns the numbers of rounds before the given one that are
+// still running.
+func GetOpenRounds(before int) ([]int, error) {
+	rows, err := db.Query("SELECT number FROM rounds WHERE status = ? AND number < ?", RoundRunning, before)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []int
+	for rows.Next() {
+		var n int
+		if err := rows.Scan(&n); err != nil {
+			return nil, err
+		}
+		out = append(out, n)
+	}
+	return out, rows.Err()
+}
+
+// MarkIncompleteRounds marks rounds before the given one that never finished,
+// e.g. because the engine crashed.
+func MarkIncompleteRounds(before int) error {
+	_, err := db.Exec("UPDATE rounds SET status = ? WHERE status = ? AND number < ?", RoundIncomplete, RoundRunning, before)
+	return err
+}
+
+// SettleRound marks a failed or incomplete round complete, so the results it
+// recorded count in the standings. It reports false when the round is not
+// failed or incomplete.
+func SettleRound(number int) (bool, error) {
+	res, err := db.Exec("UPDATE rounds SET status = ? WHERE number = ? AND status IN (?, ?)", RoundComplete, number, RoundFailed, RoundIncomplete)
+	if err != nil {
+		return false, err
+	}
+	ra, err := res.RowsAffected()
+	return ra > 0, err
+}
+
+// GetLastCompletedRound returns the number of the latest round that is no
+// longer running, or 0 if none has finished.
+func GetLastCompletedRound() (int, error) {
+	var n int
+	err := db.QueryRow("SELECT COALESCE(MAX(number), 0) FROM rounds WHERE status <> ?", RoundRunning).Scan(&n)
+	return n, err
+}
+
+// GetRounds returns the most recent rounds, newest first. A limit of 0 returns
+// every round.
+func GetRounds(limit int) ([]structures.Round, error) {
+	q := `SELECT number, COALESCE(started_at, ''), COALESCE(ended_at, ''), seed, status, checks_total, checks_up, checks_down, checks_timed_out, errors
+		FROM rounds ORDER BY number DESC`
+	var args []interface{}
+	if limit > 0 {
+		q += " LIMIT ?"
+		args = append(args, limit)
+	}
+	rows, err := db.Query(q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []structures.Round
+	for rows.Next() {
+		var r structures.Round
+		if err := rows.Scan(&r.Number, &r.StartedAt, &r.EndedAt, &r.Seed, &r.Status, &r.Checks, &r.Up, &r.Down, &r.TimedOut, &r.Errors); err != nil {
+			return nil, err
+		}
+		out = append(out, r)
+	}
+	return out, rows.Err()
+}
+
+// GetRoundResults returns the results already recorded for a round. Outputs
+// are not loaded.
+func GetRoundResults(round int) ([]structures.CheckResult, error) {
+	return GetRoundRangeResults(round, round)
+}
+
+// GetRoundRangeResults returns the results already recorded for the rounds
+// from through to. Outputs are not loaded.
+func GetRoundRangeResults(from, to int) ([]structures.CheckResult, error) {
+	rows, err := db.Query("SELECT team_id, service_id, is_up, COALESCE(slot, round), COALESCE(root_cause, ''), round FROM competition_services WHERE round BETWEEN ? AND ? ORDER BY round", from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []structures.CheckResult
+	for rows.Next() {
+		var r structures.CheckResult
+		if err := rows.Scan(&r.TeamID, &r.ServiceID, &r.IsUp, &r.Slot, &r.RootCause, &r.Round); err != nil {
+			return nil, err
+		}
+		out = append(out, r)
+	}
+	return out, rows.Err()
+}
+
+// HasServiceResult reports whether a result was already recorded for the
+// team/service check slot starting in the given round. Rows written before
+// slots existed count as slot == round.
+func HasServiceResult(teamID, serviceID, round, slot int) (bool, error) {
+	row := db.QueryRow("SELECT COUNT(*) FROM competition_services WHERE team_id = ? AND service_id = ? AND round = ? AND COALESCE(slot, round) = ?", teamID, serviceID, round, slot)
+	var n int
+	if err := row.Scan(&n); err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// =========================
+// Scoring agents
+// =========================
+
+func GetAllAgents() ([]structures.ScoringAgent, error) {
+	rows, err := db.Query("SELECT id, name, last_seen, last_address FROM scoring_agents ORDER BY id ASC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []structures.ScoringAgent
+	for rows.Next() {
+		var a structures.ScoringAgent
+		var lastSeen, lastAddr sql.NullString
+		if err := rows.Scan(&a.ID, &a.Name, &lastSeen, &lastAddr); err != nil {
+			return nil, err
+		}
+		a.LastSeen = lastSeen.String
+		a.LastAddress = lastAddr.String
+		out = append(out, a)
+	}
+	return out, nil
+}
+
+// CreateAgent inserts a new agent. Only the hash of the agent token is stored.
+func CreateAgent(a *structures.ScoringAgent, tokenHash string) error {
+	if a == nil {
+		return nil
+	}
+	res, err := db.Exec("INSERT INTO scoring_agents (name, token_hash) VALUES (?, ?)", a.Name, tokenHash)
+	if err != nil {
+		return err
+	}
+	last, err := res.LastInsertId()
+	if err == nil {
+		a.ID = int(last)
+	}
+	return nil
+}
+
+// DeleteAgent removes an agent and hands its boxes back to the central engine.
+func DeleteAgent(id int) error {
+	if _, err := db.Exec("UPDATE scored_boxes SET agent_id = NULL WHERE agent_id = ?", id); err != nil {
+		return err
+	}
+	_, err := db.Exec("DELETE FROM scoring_agents WHERE id = ?", id)
+	return err
+}
+
+// GetAgentByTokenHash returns the agent owning the token, or (nil, nil) if none does.
+func GetAgentByTokenHash(tokenHash string) (*structures.ScoringAgent, error) {
+	row := db.QueryRow("SELECT id, name, last_seen, last_address FROM scoring_agents WHERE token_hash = ?", tokenHash)
+	var a structures.ScoringAgent
+	var lastSeen, lastAddr sql.NullString
+	if err := row.Scan(&a.ID, &a.Name, &lastSeen, &lastAddr); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+	a.LastSeen = lastSeen.String
+	a.LastAddress = lastAddr.String
+	return &a, nil
+}
+
+// TouchAgent records that the agent just contacted the server.
+func TouchAgent(id int, seen, address string) error {
+	_, err := db.Exec("UPDATE scoring_agents SET last_seen = ?, last_address = ? WHERE id = ?", seen, address, id)
+	return err
+}
+
+// SetBoxAgent assigns a box to an agent. A nil agentID returns the box to the
+// central engine.
+func SetBoxAgent(boxID int, agentID *int) error {
+	_, err := db.Exec("UPDATE scored_boxes SET agent_id = ? WHERE id = ?", agentID, boxID)
+	return err
+}
+
+// GetAllPlugins returns the registered check plugins.
+func GetAllPlugins() ([]structures.CheckPlugin, error) {
+	rows, err := db.Query("SELECT id, name, path, description FROM check_plugins ORDER BY name ASC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []structures.CheckPlugin
+	for rows.Next() {
+		var p structures.CheckPlugin
+		var desc sql.NullString
+		if err := rows.Scan(&p.ID, &p.Name, &p.Path, &desc); err != nil {
+			return nil, err
+		}
+		p.Description = desc.String
+		out = append(out, p)
+	}
+	return out, rows.Err()
+}
+
+// ErrPluginInUse is returned when renaming or deleting a plugin that checks
+// still use.
+var ErrPluginInUse = errors.New("plugin is used by checks; point them at another plugin first")
+
+// SavePlugin registers a new plugin or updates an existing one. Checks refer
+// to plugins by name, so a plugin in use cannot be renamed.
+func SavePlugin(p *structures.CheckPlugin) error {
+	if p == nil {
+		return nil
+	}
+	if p.ID != 0 {
+		var inUse int
+		err := db.QueryRow(`SELECT COUNT(*) FROM service_checks sc JOIN check_plugins cp ON cp.name = sc.plugin
+			WHERE cp.id = ? AND cp.name <> ?`, p.ID, p.Name).Scan(&inUse)
+		if err != nil {
+			return err
+		}
+		if inUse > 0 {
+			return ErrPluginInUse
+		}
+		_, err = db.Exec("UPDATE check_plugins SET name = ?, path = ?, description = ? WHERE id = ?", p.Name, p.Path, p.Description, p.ID)
+		return err
+	}
+	res, err := db.Exec("INSERT INTO check_plugins (name, path, description) VALUES (?, ?, ?)", p.Name, p.Path, p.Description)
+	if err != nil {
+		return err
+	}
+	last, err := res.LastInsertId()
+	if err == nil {
+		p.ID = int(last)
+	}
+	return nil
+}
+
+// DeletePlugin removes a plugin from the registry. A plugin that checks still
+// use cannot be deleted.
+func DeletePlugin(id int) error {
+	var inUse int
+	err := db.QueryRow("SELECT COUNT(*) FROM service_checks sc JOIN check_plugins cp ON cp.name = sc.plugin WHERE cp.id = ?", id).Scan(&inUse)
+	if err != nil {
+		return err
+	}
+	if inUse > 0 {
+		return ErrPluginInUse
+	}
+	_, err = db.Exec("DELETE FROM check_plugins WHERE id = ?", id)
+	return err
+}
+
+// GetBaselines returns the integrity baselines captured for a service, or for
+// every service when serviceID is 0. Text is only loaded when withText is set.
+func GetBaselines(serviceID int, withText bool) ([]structures.ContentBaseline, error) {
+	textCol := "''"
+	if withText {
+		textCol = "COALESCE(text, '')"
+	}
+	query := "SELECT team_id, service_id, check_name, url, hash, " + textCol + ", captured_at FROM content_baselines"
+	var args []interface{}
+	if serviceID != 0 {
+		query += " WHERE service_id = ?"
+		args = append(args, serviceID)
+	}
+	rows, err := db.Query(query+" ORDER BY service_id, team_id, check_name", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []structures.ContentBaseline
+	for rows.Next() {
+		var b structures.ContentBaseline
+		if err := rows.Scan(&b.TeamID, &b.ServiceID, &b.Check, &b.URL, &b.Hash, &b.Text, &b.CapturedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, b)
+	}
+	return out, rows.Err()
+}
+
+// SaveBaseline stores a baseline, replacing any earlier capture for the same
+// team, service and check.
+func SaveBaseline(b structures.ContentBaseline) (err error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+	}()
+	if _, err = tx.Exec("DELETE FROM content_baselines WHERE team_id = ? AND service_id = ? AND check_name = ?", b.TeamID, b.ServiceID, b.Check); err != nil {
+		return err
+	}
+	_, err = tx.Exec(`INSERT INTO content_baselines
+		(team_id, service_id, check_name, url, hash, text, captured_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		b.TeamID, b.ServiceID, b.Check, b.URL, b.Hash, b.Text, b.CapturedAt)
+	return err
+}
+
+// DeleteBaselines removes the baselines for a service, limited to one team
+// when teamID is not 0.
+func DeleteBaselines(serviceID, teamID int) error {
+	if teamID != 0 {
+		_, err := db.Exec("DELETE FROM content_baselines WHERE service_id = ? AND team_id = ?", serviceID, teamID)
+		return err
+	}
+	_, err := db.Exec("DELETE FROM content_baselines WHERE service_id = ?", serviceID)
+	return err
+}
+
+// compressOutputBytes is the size above which check output is stored gzipped
+// in competition_services.output_gz instead of as text.
+const compressOutputBytes = 1024
+
+// encodeOutput returns the values stored in competition_services.output and
+// output_gz for a check output.
+func encodeOutput(output string) (string, []byte, error) {
+	if len(output) <= compressOutputBytes {
+		return output, nil, nil
+	}
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write([]byte(output)); err != nil {
+		return "", nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return "", nil, err
+	}
+	return "", buf.Bytes(), nil
+}
+
+// decodeOutput reverses encodeOutput.
+func decodeOutput(output string, compressed []byte) (string, error) {
+	if len(compressed) == 0 {
+		return output, nil
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return "", fmt.Errorf("decompressing output: %w", err)
+	}
+	defer zr.Close()
+	raw, err := io.ReadAll(zr)
+	if err != nil {
+		return "", fmt.Errorf("decompressing output: %w", err)
+	}
+	return string(raw), nil
+}
+
+// encodeEvidence returns the gzipped JSON stored in
+// competition_services.evidence_gz, or nil when there is no evidence.
+func encodeEvidence(evidence []structures.CheckEvidence) ([]byte, error) {
+	if len(evidence) == 0 {
+		return nil, nil
+	}
+	raw, err := json.Marshal(evidence)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(raw); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decodeEvidence reverses encodeEvidence.
+func decodeEvidence(compressed []byte) ([]structures.CheckEvidence, error) {
+	if len(compressed) == 0 {
+		return nil, nil
+	}
+	raw, err := decodeOutput("", compressed)
+	if err != nil {
+		return nil, err
+	}
+	var evidence []structures.CheckEvidence
+	if err := json.Unmarshal([]byte(raw), &evidence); err != nil {
+		return nil, fmt.Errorf("decoding evidence: %w", err)
+	}
+	return evidence, nil
+}
+
+// GetRecordedResults returns the results recorded for rounds from through to,
+// with their evidence and the points currently credited to each (its score
+// row plus any corrections linked to it).
+func GetRecordedResults(from, to int) ([]structures.RecordedResult, error) {
+	rows, err := db.Query(`
+		SELECT r.id, r.team_id, r.service_id, r.is_up, r.round, COALESCE(r.slot, r.round), COALESCE(r.root_cause, ''),
+			COALESCE(r.duration_ms, 0), r.timed_out, r.evidence_gz,
+			(SELECT COALESCE(SUM(s.score), 0) FROM competition_scores s WHERE s.result_id = r.id),
+			r.override_reason IS NOT NULL
+		FROM competition_services r
+		WHERE r.round BETWEEN ? AND ?
+		ORDER BY r.round, r.id`, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []structures.RecordedResult
+	for rows.Next() {
+		var r structures.RecordedResult
+		var evidence []byte
+		if err := rows.Scan(&r.ID, &r.TeamID, &r.ServiceID, &r.IsUp, &r.Round, &r.Slot, &r.RootCause,
+			&r.DurationMs, &r.TimedOut, &evidence, &r.Points, &r.Overridden); err != nil {
+			return nil, err
+		}
+		if r.Evidence, err = decodeEvidence(evidence); err != nil {
+			return nil, err
+		}
+		out = append(out, r)
+	}
+	return out, rows.Err()
+}
+
+// ApplyRescore corrects re-assessed results in one transaction: each result's
+// status, root cause and output are replaced and a competition_scores row for
+// the difference in points is linked to it, described with reason.
+func ApplyRescore(changes []structures.RescoreChange, reason string) (err error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+	}()
+	for _, c := range changes {
+		var rootCause interface{}
+		if c.RootCause != "" {
+			rootCause = c.RootCause
+		}
+		output, compressed, err := encodeOutput(c.Output)
+		if err != nil {
+			return err
+		}
+		if _, err = tx.Exec("UPDATE competition_services SET is_up = ?, root_cause = ?, output = ?, output_gz = ? WHERE id = ?",
+			c.IsUp, rootCause, output, compressed, c.ResultID); err != nil {
+			return err
+		}
+		if c.NewPoints == c.OldPoints {
+			continue
+		}
+		desc := fmt.Sprintf("Rescore team %d service %d round %d: %d -> %d points (%s)", c.TeamID, c.ServiceID, c.Round, c.OldPoints, c.NewPoints, reason)
+		if _, err = tx.Exec("INSERT INTO competition_scores (team_id, score, round, description, result_id, category) VALUES (?, ?, ?, ?, ?, ?)",
+			c.TeamID, c.NewPoints-c.OldPoints, c.Round, desc, c.ResultID, ScoreRescore); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// OverrideResults sets every result recorded for a team's service in a round
+// to up or down, recording who overrode it and why, and credits each the
+// difference between its current points and points in one transaction. It
+// returns the number of results changed and the total change in points.
+func OverrideResults(teamID, serviceID, round int, isUp bool, points int, reason, by string, at time.Time) (n int, delta int, err error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, 0, err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+	}()
+
+	rows, err := tx.Query(`SELECT r.id, r.is_up, (SELECT COALESCE(SUM(s.score), 0) FROM competition_scores s WHERE s.result_id = r.id)
+		FROM competition_services r WHERE r.team_id = ? AND r.service_id = ? AND r.round = ?`, teamID, serviceID, round)
+	if err != nil {
+		return 0, 0, err
+	}
+	type current struct {
+		id, points int
+		up         bool
+	}
+	var results []current
+	for rows.Next() {
+		var c current
+		if err = rows.Scan(&c.id, &c.up, &c.points); err != nil {
+			rows.Close()
+			return 0, 0, err
+		}
+		results = append(results, c)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, 0, err
+	}
+
+	status := map[bool]string{true: "up", false: "down"}
+	ts := at.UTC().Format(time.RFC3339)
+	for _, c := range results {
+		if _, err = tx.Exec("UPDATE competition_services SET is_up = ?, root_cause = NULL, override_reason = ?, override_by = ?, overridden_at = ? WHERE id = ?",
+			isUp, reason, by, ts, c.id); err != nil {
+			return 0, 0, err
+		}
+		if points == c.points {
+			continue
+		}
+		desc := fmt.Sprintf("Override team %d service %d round %d: %s -> %s, %d -> %d points (%s, by %s)",
+			teamID, serviceID, round, status[c.up], status[isUp], c.points, points, reason, by)
+		if _, err = tx.Exec("INSERT INTO competition_scores (team_id, score, round, description, result_id, category) VALUES (?, ?, ?, ?, ?, ?)",
+			teamID, points-c.points, round, desc, c.id, ScoreOverride); err != nil {
+			return 0, 0, err
+		}
+		delta += points - c.points
+	}
+	return len(results), delta, nil
+}
+
+// AcquireEngineLease takes or renews the scoring leader lease for instance.
+// It succeeds when the instance already holds the lease or the lease has
+// expired, and returns whether the instance is leader. Times are RFC3339 UTC
+// strings, which compare in time order.
+func AcquireEngineLease(instance string, now time.Time, ttl time.Duration) (bool, error) {
+	ts := now.UTC().Format(time.RFC3339)
+	expires := now.Add(ttl).UTC().Format(time.RFC3339)
+	res, err := db.Exec(`UPDATE engine_lease
+		SET acquired_at = CASE WHEN holder = ? THEN acquired_at ELSE ? END, holder = ?, expires_at = ?
+		WHERE id = 1 AND (holder = ? OR holder = '' OR expires_at < ?)`,
+		instance, ts, instance, expires, instance, ts)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// ReleaseEngineLease gives up the lease if instance holds it, so another
+// instance can take over without waiting for it to expire.
+func ReleaseEngineLease(instance string) error {
+	_, err := db.Exec("UPDATE engine_lease SET expires_at = '' WHERE id = 1 AND holder = ?", instance)
+	return err
+}
+
+// RecordLeaderRound notes that the leader recorded results for a round.
+func RecordLeaderRound(instance string, round int, at time.Time) error {
+	_, err := db.Exec("UPDATE engine_lease SET last_round = ?, last_round_at = ? WHERE id = 1 AND holder = ?",
+		round, at.UTC().Format(time.RFC3339), instance)
+	return err
+}
+
+// HeartbeatEngine records that a scoring-service instance is alive.
+func HeartbeatEngine(instance, hostname string, started, now time.Time) (err error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		} else {
+			err = tx.Commit()
+		}
+	}()
+	res, err := tx.Exec("UPDATE engine_instances SET last_seen = ? WHERE instance_id = ?", now.UTC().Format(time.RFC3339), instance)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n > 0 {
+		return nil
+	}
+	_, err = tx.Exec("INSERT INTO engine_instances (instance_id, hostname, started_at, last_seen) VALUES (?, ?, ?, ?)",
+		instance, hostname, started.UTC().Format(time.RFC3339), now.UTC().Format(time.RFC3339))
+	return err
+}
+
+// GetEngineStatus returns the leader lease and the known scoring-service
+// instances, most recently seen first.
+func GetEngineStatus() (*structures.EngineStatus, error) {
+	var st structures.EngineStatus
+	var acquired, lastRoundAt sql.NullString
+	err := db.QueryRow("SELECT holder, acquired_at, expires_at, last_round, last_round_at FROM engine_lease WHERE id = 1").
+		Scan(&st.Leader, &acquired, &st.LeaseExpires, &st.LastRound, &lastRoundAt)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	st.LeaderSince = acquired.String
+	st.LastRoundAt = lastRoundAt.String
+	rows, err := db.Query("SELECT instance_id, COALESCE(hostname, ''), started_at, last_seen FROM engine_instances ORDER BY last_seen DESC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	st.Instances = []structures.EngineInstance{}
+	for rows.Next() {
+		var in structures.EngineInstance
+		if err := rows.Scan(&in.ID, &in.Hostname, &in.StartedAt, &in.LastSeen); err != nil {
+			return nil, err
+		}
+		in.Leader = in.ID == st.Leader
+		st.Instances = append(st.Instances, in)
+	}
+	return &st, rows.Err()
+}
+
+// PruneEngineInstances forgets instances not seen since before.
+func PruneEngineInstances(before time.Time) error {
+	_, err := db.Exec("DELETE FROM engine_instances WHERE last_seen < ?", before.UTC().Format(time.RFC3339))
+	return err
+}
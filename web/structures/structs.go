@@ -15,13 +15,93 @@ type Service struct {
 	Name   string   `json:"name"`
 	Host   string   `json:"host,omitempty"`
 	Checks []Checks `json:"checks,omitempty"`
+	// DependsOn lists the IDs of services this one needs (e.g. DNS, the DC)
+	DependsOn []int `json:"depends_on,omitempty"`
+	// Interval is how often the service is checked, in seconds (0: once per round)
+	Interval int `json:"interval,omitempty"`
+	// Weight is the points the service is worth per round when up (0: the default)
+	Weight int `json:"weight,omitempty"`
 }
 
 type Checks struct {
 	ID      int       `json:"id,omitempty"`
 	Name    string    `json:"name"`
+	Type    string    `json:"type,omitempty"` // "command" (default), "starlark" or "plugin"
 	Command string    `json:"command"`
+	Script  string    `json:"script,omitempty"` // Starlark source for "starlark" checks
 	Regexes []Regexes `json:"regexes,omitempty"`
+	// plugin checks: registered plugin name, its parameters, and the plugin
+	// binary path resolved from the registry when services are loaded
+	Plugin     string            `json:"plugin,omitempty"`
+	Params     map[string]string `json:"params,omitempty"`
+	PluginPath string            `json:"plugin_path,omitempty"`
+	// attempt policy: the check runs up to 1+Retries times, waiting RetryDelay
+	// seconds between attempts, and passes once MinPasses attempts pass.
+	// Zero values mean the default timeout, a single attempt and one pass.
+	TimeoutSeconds int `json:"timeout_seconds,omitempty"`
+	Retries        int `json:"retries,omitempty"`
+	RetryDelay     int `json:"retry_delay,omitempty"`
+	MinPasses      int `json:"min_passes,omitempty"`
+	// Integrity configures "integrity" (defacement detection) checks
+	Integrity *IntegrityCheck `json:"integrity,omitempty"`
+}
+
+// IntegrityCheck compares a page against the golden copy captured for each team
+type IntegrityCheck struct {
+	URL  string `json:"url"`  // may contain {{host}}
+	Mode string `json:"mode"` // "hash", "similarity" or "markers"
+	// Similarity is the minimum percentage of the baseline's text that must
+	// still match in "similarity" mode (default 90)
+	Similarity int `json:"similarity,omitempty"`
+	// Markers must all appear in the page in "markers" mode
+	Markers []string `json:"markers,omitempty"`
+	// Partial, when set, keeps a defaced service up but withholds this
+	// percentage of its points instead of failing it
+	Partial int `json:"partial,omitempty"`
+}
+
+// ContentBaseline is the golden copy of a page captured for a team's integrity check
+type ContentBaseline struct {
+	TeamID     int    `json:"team_id"`
+	ServiceID  int    `json:"service_id"`
+	Check      string `json:"check"`
+	URL        string `json:"url"`
+	Hash       string `json:"hash"`
+	Text       string `json:"text,omitempty"`
+	CapturedAt string `json:"captured_at"`
+}
+
+// CheckPlugin is an external checker binary registered for use as a check type
+type CheckPlugin struct {
+	ID          int    `json:"id,omitempty"`
+	Name        string `json:"name"`
+	Path        string `json:"path"`
+	Description string `json:"description,omitempty"`
+}
+
+// PluginRequest is written as JSON to a check plugin's stdin
+type PluginRequest struct {
+	Version        int               `json:"version"`
+	Host           string            `json:"host"`
+	Team           PluginTeam        `json:"team"`
+	Service        string            `json:"service"`
+	Check          string            `json:"check"`
+	Credentials    []Credential      `json:"credentials"`
+	Params         map[string]string `json:"params"`
+	TimeoutSeconds int               `json:"timeout_seconds"`
+}
+
+// PluginTeam identifies the team owning the box in a PluginRequest
+type PluginTeam struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+// PluginResult is read as JSON from a check plugin's stdout. Status is "up" or "down".
+type PluginResult struct {
+	Status  string             `json:"status"`
+	Message string             `json:"message"`
+	Metrics map[string]float64 `json:"metrics,omitempty"`
 }
 
 type Regexes struct {
@@ -40,6 +120,9 @@ type Credential struct {
 type Team struct {
 	ID   int    `json:"id"`
 	Name string `json:"name"`
+	// Alias is shown instead of the name on public pages while the
+	// competition anonymizes teams
+	Alias string `json:"alias,omitempty"`
 }
 
 // ScoringBox represents a box with an IP assigned to a team and service
@@ -48,6 +131,9 @@ type ScoringBox struct {
 	IPAddress string `json:"ip_address"`
 	TeamID    int    `json:"team_id"`
 	ServiceID int    `json:"service_id"`
+	// AgentID is set when the box is checked by a remote scoring agent
+	// instead of the central scoring engine.
+	AgentID *int `json:"agent_id,omitempty"`
 }
 
 // Competition represents the current competition state
@@ -57,6 +143,99 @@ type Competition struct {
 	ScheduledTime string `json:"scheduled_time,omitempty"`
 	StartedTime   string `json:"started_time,omitempty"`
 	StoppedTime   string `json:"stopped_time,omitempty"`
+	// RoundInterval is the round length in seconds (0 uses the engine default)
+	RoundInterval int `json:"round_interval,omitempty"`
+	// ForgiveDependencies awards full points to services that are only down
+	// because a service they depend on is down
+	ForgiveDependencies bool `json:"forgive_dependencies"`
+	// ScoringOffset is how many minutes after the start the grace period
+	// ends and checks begin to earn points
+	ScoringOffset int `json:"scoring_offset"`
+	// FreezeOffset is how many minutes after the start the final freeze
+	// begins (0 for none); the public scoreboard stops showing new points
+	FreezeOffset int `json:"freeze_offset"`
+	// Revealed is set once admins publish the final results; the public
+	// scoreboard then shows every point despite the freeze
+	Revealed bool `json:"revealed"`
+	// InjectScoreRule picks which of a team's graded submissions for an
+	// inject counts toward its standing: "latest", "best" or "first"
+	InjectScoreRule string `json:"inject_score_rule"`
+	// AnonymizeTeams hides real team names from the public: public pages and
+	// the scoreboard API show each team's alias, or "Team N" without one
+	AnonymizeTeams bool `json:"anonymize_teams"`
+	// Phase is the phase in progress, filled in by the API
+	Phase string `json:"phase,omitempty"`
+}
+
+// Round is the engine's record of one scoring round
+type Round struct {
+	Number    int    `json:"number"`
+	StartedAt string `json:"started_at,omitempty"`
+	EndedAt   string `json:"ended_at,omitempty"`
+	// Seed orders the round's checks so a round can be replayed
+	Seed     int64  `json:"seed"`
+	Status   string `json:"status"` // "running", "complete", "failed", "incomplete"
+	Checks   int    `json:"checks"`
+	Up       int    `json:"up"`
+	Down     int    `json:"down"`
+	TimedOut int    `json:"timed_out"`
+	// Errors counts batches of checks the engine failed to record
+	Errors int `json:"errors"`
+}
+
+// EngineStatus describes the scoring-service instances and which one leads
+type EngineStatus struct {
+	Leader       string `json:"leader"`
+	LeaderSince  string `json:"leader_since,omitempty"`
+	LeaseExpires string `json:"lease_expires,omitempty"`
+	// LeaderAlive is false once the lease has expired with no instance taking over
+	LeaderAlive bool             `json:"leader_alive"`
+	LastRound   int              `json:"last_round"`
+	LastRoundAt string           `json:"last_round_at,omitempty"`
+	Instances   []EngineInstance `json:"instances"`
+	Health      *EngineHealth    `json:"health,omitempty"`
+}
+
+// EngineHealth summarizes whether scoring is keeping up
+type EngineHealth struct {
+	CompetitionRunning bool `json:"competition_running"`
+	CurrentRound       int  `json:"current_round"`
+	RoundInterval      int  `json:"round_interval"` // seconds
+	// LastCompleted is the most recent round that finished
+	LastCompleted *Round `json:"last_completed,omitempty"`
+	// LastDurationSec is how long LastCompleted took from start to end
+	LastDurationSec float64 `json:"last_duration_sec"`
+	// Recent are the latest rounds, newest first
+	Recent []Round `json:"recent"`
+	// Latency is the distribution of check durations over Recent
+	Latency  LatencyStats `json:"latency"`
+	TimedOut int          `json:"timed_out"`
+	Errors   int          `json:"errors"`
+	// Stale is set when no round has completed within two round intervals
+	Stale   bool   `json:"stale"`
+	Warning string `json:"warning,omitempty"`
+}
+
+// LatencyStats is a distribution of check durations in milliseconds
+type LatencyStats struct {
+	Count int `json:"count"`
+	P50   int `json:"p50"`
+	P90   int `json:"p90"`
+	P99   int `json:"p99"`
+	Max   int `json:"max"`
+	// Buckets counts checks by duration; Bounds[i] is the upper bound of
+	// Buckets[i] and the last bucket has no upper bound
+	Bounds  []int `json:"bounds"`
+	Buckets []int `json:"buckets"`
+}
+
+// EngineInstance is a scoring-service process that heartbeats to the database
+type EngineInstance struct {
+	ID        string `json:"id"`
+	Hostname  string `json:"hostname"`
+	StartedAt string `json:"started_at"`
+	LastSeen  string `json:"last_seen"`
+	Leader    bool   `json:"leader"`
 }
 
 // Inject represents an inject that can be released during a competition
@@ -85,4 +264,132 @@ type InjectSubmission struct {
 	Score       *int   `json:"score,omitempty"`
 	Reviewer    string `json:"reviewer,omitempty"`
 	Notes       string `json:"notes,omitempty"`
+	// ScoredAt is when the submission was last graded
+	ScoredAt string `json:"scored_at,omitempty"`
+}
+
+// ScoringAgent is a remote scoring-service instance that runs checks from inside
+// a segmented network and reports results back to the web server.
+type ScoringAgent struct {
+	ID          int    `json:"id"`
+	Name        string `json:"name"`
+	LastSeen    string `json:"last_seen,omitempty"`
+	LastAddress string `json:"last_address,omitempty"`
+	Online      bool   `json:"online"`
+	// Token is only populated once, in the response that creates the agent
+	Token string `json:"token,omitempty"`
+}
+
+// AgentBox is a box to be checked along with the service and the team context
+// (name and credentials) that scripted checks can use
+type AgentBox struct {
+	BoxID       int          `json:"box_id"`
+	TeamID      int          `json:"team_id"`
+	TeamName    string       `json:"team_name,omitempty"`
+	IPAddress   string       `json:"ip_address"`
+	Service     Service      `json:"service"`
+	Credentials []Credential `json:"credentials,omitempty"`
+	// TeamAddresses and TeamURLs are the team's service addresses and login
+	// URLs from the config, keyed by service name, for scripted checks
+	TeamAddresses map[string]string `json:"team_addresses,omitempty"`
+	TeamURLs      map[string]string `json:"team_urls,omitempty"`
+	// Baselines are the golden copies for the service's integrity checks
+	Baselines []ContentBaseline `json:"baselines,omitempty"`
+	// Slot and Round identify the check that is due for this box
+	Slot  int `json:"slot,omitempty"`
+	Round int `json:"round,omitempty"`
+}
+
+// AgentAssignment is returned to an agent when it asks for work
+type AgentAssignment struct {
+	Round         int        `json:"round"`
+	RoundInterval int        `json:"round_interval"`
+	Boxes         []AgentBox `json:"boxes"`
+}
+
+// CheckResult is the outcome of checking one team's service for a round
+type CheckResult struct {
+	TeamID    int    `json:"team_id"`
+	ServiceID int    `json:"service_id"`
+	Round     int    `json:"round"`
+	IsUp      bool   `json:"is_up"`
+	Output    string `json:"output"`
+	// RootCause names the failed dependency that took this service down
+	RootCause string `json:"root_cause,omitempty"`
+	// Slot is the service's check slot (see scoring.SlotAt); Round is derived from it
+	Slot int `json:"slot,omitempty"`
+	// Penalty is the percentage of points withheld from an up service, e.g.
+	// for a partially defaced page
+	Penalty int `json:"penalty,omitempty"`
+	// DurationMs is how long the service's checks took to run
+	DurationMs int `json:"duration_ms,omitempty"`
+	// TimedOut is set when a check ran past its timeout
+	TimedOut bool `json:"timed_out,omitempty"`
+	// Evidence is the raw result of each check, kept so the result can be
+	// re-assessed if the check's assertions change
+	Evidence []CheckEvidence `json:"evidence,omitempty"`
+	// Baselines are golden copies captured by integrity checks that had none;
+	// they are stored with the result unless one was captured meanwhile
+	Baselines []ContentBaseline `json:"baselines,omitempty"`
+}
+
+// RecordedResult is a result as stored in competition_services, with the
+// points currently credited to it
+type RecordedResult struct {
+	ID int `json:"id"`
+	CheckResult
+	Points int `json:"points"`
+	// Overridden is set when an admin overrode the result by hand
+	Overridden bool `json:"overridden,omitempty"`
+}
+
+// RescoreChange is a recorded result whose outcome changes when it is
+// re-assessed from its evidence
+type RescoreChange struct {
+	ResultID    int    `json:"result_id"`
+	TeamID      int    `json:"team_id"`
+	TeamName    string `json:"team_name"`
+	ServiceID   int    `json:"service_id"`
+	ServiceName string `json:"service_name"`
+	Round       int    `json:"round"`
+	WasUp       bool   `json:"was_up"`
+	IsUp        bool   `json:"is_up"`
+	OldPoints   int    `json:"old_points"`
+	NewPoints   int    `json:"new_points"`
+	RootCause   string `json:"root_cause,omitempty"`
+	Output      string `json:"output"`
+}
+
+// CheckEvidence is what one check observed, attempt by attempt
+type CheckEvidence struct {
+	Check    string            `json:"check"`
+	Type     string            `json:"type,omitempty"`
+	Attempts []AttemptEvidence `json:"attempts"`
+}
+
+// DurationMs is how long the check's attempts took to run, not counting the
+// delay between retries
+func (ev CheckEvidence) DurationMs() int {
+	ms := 0
+	for _, a := range ev.Attempts {
+		ms += a.DurationMs
+	}
+	return ms
+}
+
+// AttemptEvidence is the raw result of one attempt of a check, before its
+// regexes are applied
+type AttemptEvidence struct {
+	// OK is whether the command exited 0, the script or plugin reported up,
+	// or the page was intact
+	OK         bool   `json:"ok"`
+	Output     string `json:"output"`
+	ExitCode   int    `json:"exit_code,omitempty"` // command checks only
+	Error      string `json:"error,omitempty"`
+	Penalty    int    `json:"penalty,omitempty"`
+	DurationMs int    `json:"duration_ms"`
+	TimedOut   bool   `json:"timed_out,omitempty"`
+	// Baseline is the page an integrity check captured for want of a
+	// baseline; it travels with the result rather than the evidence
+	Baseline *ContentBaseline `json:"-"`
 }
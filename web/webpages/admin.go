@@ -3,6 +3,7 @@ package webpages
 // Handlers for admin-related pages.
 
 import (
+	"BlueDevil-Engine/scoring"
 	sql_wrapper "BlueDevil-Engine/sql"
 	structures "BlueDevil-Engine/structures"
 	"bytes"
@@ -50,6 +51,14 @@ func HandleManageInjects(w http.ResponseWriter, r *http.Request) {
 	http.ServeFile(w, r, "templates/admin.html")
 }
 
+func HandleManageAgents(w http.ResponseWriter, r *http.Request) {
+	http.ServeFile(w, r, "templates/admin.html")
+}
+
+func HandleManagePlugins(w http.ResponseWriter, r *http.Request) {
+	http.ServeFile(w, r, "templates/admin.html")
+}
+
 // Admin API: list creates and uploads injects
 func HandleApiInjects(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
@@ -241,30 +250,42 @@ func HandleApiInjectScore(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	// load submission
-	subs, err := sql_wrapper.GetSubmissionsForInject("")
+	found, err := sql_wrapper.GetInjectSubmission(req.ID)
 	if err != nil {
 		http.Error(w, "Failed to query: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
-	var found *structures.InjectSubmission
-	for i := range subs {
-		if subs[i].ID == req.ID {
-			found = &subs[i]
-			break
-		}
-	}
 	if found == nil {
 		http.Error(w, "submission not found", http.StatusBadRequest)
 		return
 	}
+	// the submission's points move from the round it was last graded in to
+	// the current one
+	rounds := []int{}
+	for _, at := range []string{found.ScoredAt, time.Now().UTC().Format(time.RFC3339)} {
+		round, err := sql_wrapper.GetRoundStartedBy(at)
+		if err != nil {
+			http.Error(w, "Failed to get rounds: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if round > 0 {
+			rounds = append(rounds, round)
+		}
+	}
 	found.Scored = req.Scored
 	found.Score = &req.Score
 	found.Reviewer = req.Reviewer
 	found.Notes = req.Notes
+	found.ScoredAt = ""
+	if req.Scored {
+		found.ScoredAt = time.Now().UTC().Format(time.RFC3339)
+	}
 	if err := sql_wrapper.UpdateInjectSubmission(found); err != nil {
 		http.Error(w, "failed to update: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
+	// graded injects count toward the standings
+	go publishScoreUpdate(EventAdjustment, rounds...)
 	w.WriteHeader(http.StatusNoContent)
 }
 
@@ -439,6 +460,11 @@ func GetServicesHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// minServiceInterval is the shortest per-service check interval, in seconds.
+// Agents poll for due checks every few seconds, so shorter intervals would be
+// skipped.
+const minServiceInterval = 10
+
 // SaveServiceHandler accepts a JSON object for a service and either creates or updates it in memory.
 func SaveServiceHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
@@ -452,6 +478,47 @@ func SaveServiceHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	log.Println("Saving Service following json" + svc.Name)
 
+	if svc.Interval != 0 && svc.Interval < minServiceInterval {
+		http.Error(w, fmt.Sprintf("check interval must be at least %d seconds", minServiceInterval), http.StatusBadRequest)
+		return
+	}
+	if svc.Weight < 0 {
+		http.Error(w, "weight must not be negative", http.StatusBadRequest)
+		return
+	}
+	for i, chk := range svc.Checks {
+		if chk.Type != scoring.CheckTypeIntegrity {
+			svc.Checks[i].Integrity = nil
+			continue
+		}
+		if err := scoring.ValidateIntegrity(chk.Integrity); err != nil {
+			http.Error(w, fmt.Sprintf("check %q: %v", chk.Name, err), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if len(svc.DependsOn) > 0 {
+		services, err := sql_wrapper.GetAllServices()
+		if err != nil {
+			http.Error(w, "Failed to get services: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		replaced := false
+		for i := range services {
+			if services[i].ID == svc.ID && svc.ID != 0 {
+				services[i] = svc
+				replaced = true
+			}
+		}
+		if !replaced {
+			services = append(services, svc)
+		}
+		if scoring.HasDependencyCycle(services) {
+			http.Error(w, "service dependencies must not form a cycle", http.StatusBadRequest)
+			return
+		}
+	}
+
 	if err := sql_wrapper.SaveService(&svc); err != nil {
 		http.Error(w, "Failed to save service: "+err.Error(), http.StatusInternalServerError)
 		return
@@ -503,6 +570,16 @@ func HandleApiTeams(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
 			return
 		}
+		t.Alias = strings.TrimSpace(t.Alias)
+		teams, err := sql_wrapper.GetAllTeams()
+		if err != nil {
+			http.Error(w, "Failed to get teams: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if msg := teamAliasConflict(t, teams); msg != "" {
+			http.Error(w, msg, http.StatusBadRequest)
+			return
+		}
 		if err := sql_wrapper.CreateTeam(&t); err != nil {
 			http.Error(w, "Failed to create team: "+err.Error(), http.StatusInternalServerError)
 			return
@@ -519,6 +596,16 @@ func HandleApiTeams(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "Team ID is required for update", http.StatusBadRequest)
 			return
 		}
+		t.Alias = strings.TrimSpace(t.Alias)
+		teams, err := sql_wrapper.GetAllTeams()
+		if err != nil {
+			http.Error(w, "Failed to get teams: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if msg := teamAliasConflict(t, teams); msg != "" {
+			http.Error(w, msg, http.StatusBadRequest)
+			return
+		}
 		if err := sql_wrapper.CreateTeam(&t); err != nil {
 			http.Error(w, "Failed to update team: "+err.Error(), http.StatusInternalServerError)
 			return
@@ -691,12 +778,23 @@ func HandleApiCompetition(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "Failed to get competition: "+err.Error(), http.StatusInternalServerError)
 			return
 		}
+		comp.Phase = scoring.PhaseAt(comp, time.Now())
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(comp)
 	case http.MethodPost:
 		var req struct {
-			Action        string `json:"action"` // "schedule", "start", "stop", "reset"
+			Action        string `json:"action"` // "schedule", "start", "stop", "reset", "settings", "reveal"
 			ScheduledTime string `json:"scheduled_time,omitempty"`
+			RoundInterval int    `json:"round_interval,omitempty"`
+			// nil leaves the current policy unchanged
+			ForgiveDependencies *bool `json:"forgive_dependencies,omitempty"`
+			// phase offsets in minutes from the start; nil leaves them unchanged
+			ScoringOffset *int `json:"scoring_offset,omitempty"`
+			FreezeOffset  *int `json:"freeze_offset,omitempty"`
+			// "latest", "best" or "first"; empty leaves the rule unchanged
+			InjectScoreRule string `json:"inject_score_rule,omitempty"`
+			// nil leaves team names as they are shown
+			AnonymizeTeams *bool `json:"anonymize_teams,omitempty"`
 		}
 		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 			http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
@@ -709,22 +807,70 @@ func HandleApiCompetition(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		if req.RoundInterval > 0 {
+			comp.RoundInterval = req.RoundInterval
+		}
+		if req.ForgiveDependencies != nil {
+			comp.ForgiveDependencies = *req.ForgiveDependencies
+		}
+		if req.ScoringOffset != nil {
+			comp.ScoringOffset = *req.ScoringOffset
+		}
+		if req.FreezeOffset != nil {
+			// choosing a new freeze time hides the scores again
+			if *req.FreezeOffset != comp.FreezeOffset {
+				comp.Revealed = false
+			}
+			comp.FreezeOffset = *req.FreezeOffset
+		}
+		if req.AnonymizeTeams != nil {
+			comp.AnonymizeTeams = *req.AnonymizeTeams
+		}
+		switch req.InjectScoreRule {
+		case "":
+		case sql_wrapper.InjectRuleLatest, sql_wrapper.InjectRuleBest, sql_wrapper.InjectRuleFirst:
+			comp.InjectScoreRule = req.InjectScoreRule
+		default:
+			http.Error(w, "Invalid inject score rule", http.StatusBadRequest)
+			return
+		}
+		if comp.ScoringOffset < 0 || comp.FreezeOffset < 0 {
+			http.Error(w, "Phase offsets cannot be negative", http.StatusBadRequest)
+			return
+		}
+		if comp.FreezeOffset > 0 && comp.FreezeOffset <= comp.ScoringOffset {
+			http.Error(w, "The final freeze must begin after the grace period ends", http.StatusBadRequest)
+			return
+		}
+
 		switch req.Action {
+		case "settings":
+			// only the settings above are updated
 		case "schedule":
 			comp.Status = "scheduled"
 			comp.ScheduledTime = req.ScheduledTime
 		case "start":
 			comp.Status = "running"
 			comp.StartedTime = time.Now().Format(time.RFC3339)
+			comp.Revealed = false
+			go captureMissingBaselines()
 		case "stop":
 			comp.Status = "stopped"
 			comp.StoppedTime = time.Now().Format(time.RFC3339)
+		case "reveal":
+			// publish the final results hidden by the freeze
+			if comp.FreezeOffset == 0 {
+				http.Error(w, "No final freeze is set", http.StatusBadRequest)
+				return
+			}
+			comp.Revealed = true
 		case "reset":
 			// Reset all competition scoring data, service checks, and scores
 			if err := sql_wrapper.ResetAllScoringData(); err != nil {
 				http.Error(w, "Failed to reset scoring data: "+err.Error(), http.StatusInternalServerError)
 				return
 			}
+			homepages.invalidate()
 			w.Header().Set("Content-Type", "application/json")
 			json.NewEncoder(w).Encode(map[string]string{"message": "All scoring data reset successfully"})
 			return
@@ -737,7 +883,14 @@ func HandleApiCompetition(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "Failed to update competition: "+err.Error(), http.StatusInternalServerError)
 			return
 		}
+		// the homepage shows the phase and the freeze
+		homepages.invalidate()
+		if req.Action == "reveal" || req.InjectScoreRule != "" || req.AnonymizeTeams != nil {
+			// open homepages pick up the new standings
+			go publishScoreUpdate(EventAdjustment)
+		}
 
+		comp.Phase = scoring.PhaseAt(comp, time.Now())
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(comp)
 	default:
@@ -782,24 +935,106 @@ func HandleApiScoreAdjust(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	var req struct {
-		TeamID      int    `json:"team_id"`
-		Score       int    `json:"score"`
-		Round       int    `json:"round"`
+		TeamID int `json:"team_id"`
+		Score  int `json:"score"`
+		Round  int `json:"round"`
+		// Category is "adjustment" (the default), "sla" or "red_team"
+		Category    string `json:"category"`
 		Description string `json:"description"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
 		return
 	}
-	id, err := sql_wrapper.AddCompetitionScoreAdjustment(req.TeamID, req.Score, req.Round, req.Description)
+	switch req.Category {
+	case "":
+		req.Category = sql_wrapper.ScoreAdjustment
+	case sql_wrapper.ScoreAdjustment, sql_wrapper.ScoreSLA, sql_wrapper.ScoreRedTeam:
+	default:
+		http.Error(w, "Invalid category", http.StatusBadRequest)
+		return
+	}
+	id, err := sql_wrapper.AddCompetitionScoreAdjustment(req.TeamID, req.Score, req.Round, req.Category, req.Description)
 	if err != nil {
 		http.Error(w, "Failed to add adjustment: "+err.Error(), http.StatusInternalServerError)
 		return
 	}
+	go publishScoreUpdate(EventAdjustment, req.Round)
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]int{"id": id})
 }
 
+// Override the results of a team's service in a round, e.g. when it was down
+// because of our infrastructure. Points are recalculated and the change is
+// recorded in competition_scores with the reason.
+func HandleApiScoreOverride(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		TeamID    int    `json:"team_id"`
+		ServiceID int    `json:"service_id"`
+		Round     int    `json:"round"`
+		IsUp      bool   `json:"is_up"`
+		Reason    string `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	req.Reason = strings.TrimSpace(req.Reason)
+	if req.TeamID == 0 || req.ServiceID == 0 || req.Round == 0 {
+		http.Error(w, "team_id, service_id and round required", http.StatusBadRequest)
+		return
+	}
+	if req.Reason == "" {
+		http.Error(w, "A reason is required to override a result", http.StatusBadRequest)
+		return
+	}
+
+	comp, err := sql_wrapper.GetCompetition()
+	if err != nil {
+		http.Error(w, "Failed to get competition: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	services, err := sql_wrapper.GetAllServices()
+	if err != nil {
+		http.Error(w, "Failed to get services: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var svc *structures.Service
+	for i := range services {
+		if services[i].ID == req.ServiceID {
+			svc = &services[i]
+		}
+	}
+	if svc == nil {
+		http.Error(w, "Service not found", http.StatusNotFound)
+		return
+	}
+	points := scoring.PointsForResult(structures.CheckResult{TeamID: req.TeamID, ServiceID: req.ServiceID, Round: req.Round, IsUp: req.IsUp}, *svc, comp)
+
+	by := "admin"
+	if u, ok := r.Context().Value(CtxUserKey).(structures.User); ok && u.Name != "" {
+		by = u.Name
+	}
+	n, delta, err := sql_wrapper.OverrideResults(req.TeamID, req.ServiceID, req.Round, req.IsUp, points, req.Reason, by, time.Now())
+	if err != nil {
+		http.Error(w, "Failed to override result: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if n == 0 {
+		http.Error(w, "No result recorded for that team, service and round", http.StatusNotFound)
+		return
+	}
+	if delta != 0 {
+		go publishScoreUpdate(EventAdjustment, req.Round)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"overridden": n, "point_change": delta})
+}
+
 // Service Matrix API - returns data for the dashboard service configuration matrix
 func HandleApiServiceMatrix(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
@@ -0,0 +1,337 @@
+package webpages
+
+// Handlers for remote scoring agents: admin management of agents and the
+// token-authenticated API the agents use to fetch work and report results.
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"BlueDevil-Engine/scoring"
+	sql_wrapper "BlueDevil-Engine/sql"
+	structures "BlueDevil-Engine/structures"
+)
+
+// agentOnlineRounds is how many round intervals an agent may stay silent
+// before it is reported as offline.
+const agentOnlineRounds = 3
+
+// agentReportGrace is how long after a slot's checks can last have finished
+// an agent's results for it are still accepted, allowing for the agent's poll
+// interval and for retrying the request.
+const agentReportGrace = 30 * time.Second
+
+// HashAgentToken returns the value stored in scoring_agents.token_hash for a token.
+func HashAgentToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func generateAgentToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Admin API: list, create and delete scoring agents
+func HandleApiAgents(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		agents, err := sql_wrapper.GetAllAgents()
+		if err != nil {
+			http.Error(w, "Failed to get agents: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if agents == nil {
+			agents = []structures.ScoringAgent{}
+		}
+		comp, _ := sql_wrapper.GetCompetition()
+		window := agentOnlineRounds * scoring.RoundInterval(comp)
+		now := time.Now().UTC()
+		for i := range agents {
+			if seen, err := time.Parse(time.RFC3339, agents[i].LastSeen); err == nil {
+				agents[i].Online = now.Sub(seen) <= window
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(agents)
+	case http.MethodPost:
+		var a structures.ScoringAgent
+		if err := json.NewDecoder(r.Body).Decode(&a); err != nil {
+			http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if a.Name == "" {
+			http.Error(w, "name required", http.StatusBadRequest)
+			return
+		}
+		token, err := generateAgentToken()
+		if err != nil {
+			http.Error(w, "Failed to generate token: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if err := sql_wrapper.CreateAgent(&a, HashAgentToken(token)); err != nil {
+			http.Error(w, "Failed to create agent: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		// the plaintext token is only ever returned here
+		a.Token = token
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(a)
+	case http.MethodDelete:
+		var req struct {
+			ID int `json:"id"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := sql_wrapper.DeleteAgent(req.ID); err != nil {
+			http.Error(w, "Failed to delete agent: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// Admin API: assign a box to an agent (agent_id null returns it to the engine)
+func HandleApiAgentAssign(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		BoxID   int  `json:"box_id"`
+		AgentID *int `json:"agent_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.BoxID == 0 {
+		http.Error(w, "box_id required", http.StatusBadRequest)
+		return
+	}
+	if req.AgentID != nil && *req.AgentID == 0 {
+		req.AgentID = nil
+	}
+	if err := sql_wrapper.SetBoxAgent(req.BoxID, req.AgentID); err != nil {
+		http.Error(w, "Failed to assign box: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// agentFromContext returns the agent placed in the request by the agent auth middleware
+func agentFromContext(r *http.Request) *structures.ScoringAgent {
+	if a, ok := r.Context().Value(CtxAgentKey).(*structures.ScoringAgent); ok {
+		return a
+	}
+	return nil
+}
+
+// agentBoxes returns the boxes assigned to the agent along with their services
+func agentBoxes(agentID int) ([]structures.AgentBox, error) {
+	boxes, err := sql_wrapper.GetAllScoringBoxes()
+	if err != nil {
+		return nil, err
+	}
+	services, err := sql_wrapper.GetAllServices()
+	if err != nil {
+		return nil, err
+	}
+	teams, err := sql_wrapper.GetAllTeams()
+	if err != nil {
+		return nil, err
+	}
+	baselines, err := sql_wrapper.GetBaselines(0, true)
+	if err != nil {
+		return nil, err
+	}
+	return scoring.BuildBoxes(boxes, services, teams, baselines, func(b structures.ScoringBox) bool {
+		return b.AgentID != nil && *b.AgentID == agentID
+	}), nil
+}
+
+// Agent API: the boxes and checks this agent should run for the current round
+func HandleAgentAssignments(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	agent := agentFromContext(r)
+	if agent == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	comp, err := sql_wrapper.GetCompetition()
+	if err != nil {
+		http.Error(w, "Failed to get competition: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	boxes, err := agentBoxes(agent.ID)
+	if err != nil {
+		http.Error(w, "Failed to get assignments: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	now := time.Now().UTC()
+	for i := range boxes {
+		boxes[i].Slot = scoring.SlotAt(comp, boxes[i].Service, now)
+		boxes[i].Round = scoring.RoundForSlot(comp, boxes[i].Service, boxes[i].Slot)
+	}
+	resp := structures.AgentAssignment{
+		Round:         scoring.RoundAt(comp, now),
+		RoundInterval: int(scoring.RoundInterval(comp) / time.Second),
+		Boxes:         boxes,
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// Agent API: accept check results for boxes assigned to this agent
+func HandleAgentResults(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	agent := agentFromContext(r)
+	if agent == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	var results []structures.CheckResult
+	if err := json.NewDecoder(r.Body).Decode(&results); err != nil {
+		http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	comp, err := sql_wrapper.GetCompetition()
+	if err != nil {
+		http.Error(w, "Failed to get competition: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	now := time.Now().UTC()
+	current := scoring.RoundAt(comp, now)
+	if current == 0 {
+		http.Error(w, "competition is not running", http.StatusConflict)
+		return
+	}
+	boxes, err := agentBoxes(agent.ID)
+	if err != nil {
+		http.Error(w, "Failed to get assignments: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// agents may only report on team/service pairs they were assigned
+	assigned := make(map[[2]int]structures.Service)
+	for _, b := range boxes {
+		assigned[[2]int{b.TeamID, b.Service.ID}] = b.Service
+	}
+
+	var valid []structures.CheckResult
+	for _, res := range results {
+		svc, ok := assigned[[2]int{res.TeamID, res.ServiceID}]
+		if !ok {
+			log.Printf("agent %s: rejected result for unassigned team %d service %d", agent.Name, res.TeamID, res.ServiceID)
+			continue
+		}
+		// results for slots that have not started yet are not accepted, nor
+		// results arriving after the slot's checks could have finished
+		slot := scoring.SlotAt(comp, svc, now)
+		deadline := scoring.SlotStart(comp, svc, res.Slot+1).Add(scoring.ServiceRunTime(svc) + agentReportGrace)
+		if res.Slot > slot || res.Slot <= 0 || now.After(deadline) {
+			log.Printf("agent %s: rejected result for service %d slot %d (current %d)", agent.Name, res.ServiceID, res.Slot, slot)
+			continue
+		}
+		sanitizeAgentResult(&res, svc)
+		// the round is derived here rather than trusted from the agent
+		res.Round = scoring.RoundForSlot(comp, svc, res.Slot)
+		exists, err := sql_wrapper.HasServiceResult(res.TeamID, res.ServiceID, res.Round, res.Slot)
+		if err != nil {
+			http.Error(w, "Failed to check existing results: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if exists {
+			continue
+		}
+		valid = append(valid, res)
+	}
+
+	// root causes are worked out here rather than trusted from the agent, using
+	// whatever the engine and other agents have already recorded
+	services, err := sql_wrapper.GetAllServices()
+	if err != nil {
+		http.Error(w, "Failed to get services: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var recorded []structures.CheckResult
+	if len(valid) > 0 {
+		first, last := valid[0].Round, valid[0].Round
+		for _, res := range valid {
+			first = min(first, res.Round)
+			last = max(last, res.Round)
+		}
+		// dependencies checked less often may last have run rounds earlier
+		recorded, err = sql_wrapper.GetRoundRangeResults(first-scoring.DependencyLookback(comp, services), last)
+		if err != nil {
+			http.Error(w, "Failed to get round results: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	scoring.ApplyDependencies(valid, recorded, services, comp)
+
+	points := make([]int, len(valid))
+	for i := range valid {
+		svc := assigned[[2]int{valid[i].TeamID, valid[i].ServiceID}]
+		valid[i].Output = scoring.PrepareOutput(valid[i].TeamID, valid[i].Output)
+		scoring.PrepareEvidence(valid[i].TeamID, valid[i].Evidence)
+		points[i] = scoring.PointsForResult(valid[i], svc, comp)
+	}
+	accepted, err := sql_wrapper.RecordResults(valid, points, "")
+	if err != nil {
+		http.Error(w, "Failed to record results: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"accepted": accepted})
+}
+
+// sanitizeAgentResult drops what an agent may not set on a result for svc:
+// evidence and baselines for checks the service does not have, baselines for
+// anything but its integrity checks or for another team or service, and
+// penalties outside 0-100.
+func sanitizeAgentResult(res *structures.CheckResult, svc structures.Service) {
+	types := make(map[string]string)
+	for _, chk := range svc.Checks {
+		types[chk.Name] = chk.Type
+	}
+	clamp := func(p int) int { return min(max(p, 0), 100) }
+	res.Penalty = clamp(res.Penalty)
+	evidence := res.Evidence[:0]
+	for _, ev := range res.Evidence {
+		if _, ok := types[ev.Check]; !ok {
+			continue
+		}
+		for i := range ev.Attempts {
+			ev.Attempts[i].Penalty = clamp(ev.Attempts[i].Penalty)
+		}
+		evidence = append(evidence, ev)
+	}
+	res.Evidence = evidence
+	baselines := res.Baselines[:0]
+	for _, b := range res.Baselines {
+		if b.TeamID != res.TeamID || b.ServiceID != res.ServiceID || types[b.Check] != scoring.CheckTypeIntegrity {
+			continue
+		}
+		baselines = append(baselines, b)
+	}
+	res.Baselines = baselines
+}
@@ -0,0 +1,341 @@
+package webpages
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"BlueDevil-Engine/scoring"
+	sql_wrapper "BlueDevil-Engine/sql"
+	structures "BlueDevil-Engine/structures"
+)
+
+// openTestDB points the sql package at a fresh SQLite database for the test.
+func openTestDB(t *testing.T) {
+	if err := sql_wrapper.InitDB("sqlite3", filepath.Join(t.TempDir(), "test.db")); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sql_wrapper.CloseDB() })
+	if err := sql_wrapper.CreateTables(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// agentRequest builds a request as the agent auth middleware passes it on.
+func agentRequest(method, path string, body []byte, agent *structures.ScoringAgent) *http.Request {
+	r := httptest.NewRequest(method, path, bytes.NewReader(body))
+	if agent != nil {
+		r = r.WithContext(context.WithValue(r.Context(), CtxAgentKey, agent))
+	}
+	return r
+}
+
+// agentFixture is a running competition with a service checked every 30
+// seconds on three teams' boxes, the first two assigned to one agent.
+type agentFixture struct {
+	agent *structures.ScoringAgent
+	svc   structures.Service
+	teams []int
+	start time.Time
+}
+
+func newAgentFixture(t *testing.T, elapsed time.Duration) agentFixture {
+	openTestDB(t)
+	var f agentFixture
+	rec := httptest.NewRecorder()
+	HandleApiAgents(rec, httptest.NewRequest(http.MethodPost, "/api/agents", bytes.NewReader([]byte(`{"name":"dmz"}`))))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("creating an agent returned %d: %s", rec.Code, rec.Body)
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&f.agent); err != nil {
+		t.Fatal(err)
+	}
+	f.svc = structures.Service{Name: "Web", Interval: 30, Checks: []structures.Checks{{Name: "http", Command: "true", TimeoutSeconds: 5}}}
+	if err := sql_wrapper.SaveService(&f.svc); err != nil {
+		t.Fatal(err)
+	}
+	for i, name := range []string{"T1", "T2", "T3"} {
+		team := &structures.Team{Name: name}
+		if err := sql_wrapper.CreateTeam(team); err != nil {
+			t.Fatal(err)
+		}
+		f.teams = append(f.teams, team.ID)
+		box := &structures.ScoringBox{TeamID: team.ID, ServiceID: f.svc.ID, IPAddress: "10.0.0.1"}
+		if err := sql_wrapper.SaveScoringBox(box); err != nil {
+			t.Fatal(err)
+		}
+		if i < 2 {
+			if err := sql_wrapper.SetBoxAgent(box.ID, &f.agent.ID); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	comp, err := sql_wrapper.GetCompetition()
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.start = time.Now().UTC().Add(-elapsed)
+	comp.Status = "running"
+	comp.StartedTime = f.start.Format(time.RFC3339)
+	comp.RoundInterval = 60
+	if err := sql_wrapper.UpdateCompetition(comp); err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func TestAgentToken(t *testing.T) {
+	f := newAgentFixture(t, time.Minute)
+	if f.agent.Token == "" {
+		t.Fatal("creating an agent did not return its token")
+	}
+	got, err := sql_wrapper.GetAgentByTokenHash(HashAgentToken(f.agent.Token))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got == nil || got.ID != f.agent.ID {
+		t.Fatalf("the agent's token found %+v", got)
+	}
+	if got, _ := sql_wrapper.GetAgentByTokenHash(HashAgentToken(f.agent.Token + "x")); got != nil {
+		t.Errorf("a wrong token found agent %d", got.ID)
+	}
+	agents, err := sql_wrapper.GetAllAgents()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, a := range agents {
+		if a.Token != "" {
+			t.Errorf("agent %d lists its token", a.ID)
+		}
+	}
+
+	for _, tt := range []struct {
+		name    string
+		handler http.HandlerFunc
+		method  string
+	}{
+		{"assignments", HandleAgentAssignments, http.MethodGet},
+		{"results", HandleAgentResults, http.MethodPost},
+	} {
+		t.Run(tt.name+" without an agent", func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			tt.handler(rec, agentRequest(tt.method, "/api/agent/"+tt.name, []byte("[]"), nil))
+			if rec.Code != http.StatusUnauthorized {
+				t.Errorf("returned %d, want %d", rec.Code, http.StatusUnauthorized)
+			}
+		})
+	}
+}
+
+func TestHandleAgentAssignments(t *testing.T) {
+	f := newAgentFixture(t, 100*time.Second)
+	rec := httptest.NewRecorder()
+	HandleAgentAssignments(rec, agentRequest(http.MethodGet, "/api/agent/assignments", nil, f.agent))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("returned %d: %s", rec.Code, rec.Body)
+	}
+	var got structures.AgentAssignment
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Round != 2 || got.RoundInterval != 60 {
+		t.Errorf("round %d of %ds, want round 2 of 60s", got.Round, got.RoundInterval)
+	}
+	if len(got.Boxes) != 2 {
+		t.Fatalf("%d boxes assigned, want the agent's 2", len(got.Boxes))
+	}
+	for _, b := range got.Boxes {
+		if b.TeamID == f.teams[2] {
+			t.Errorf("assigned team %d's box, which the engine checks", b.TeamID)
+		}
+		// 100s in, the 30s service is in its fourth slot, which starts in round 2
+		if b.Slot != 4 || b.Round != 2 {
+			t.Errorf("team %d: slot %d in round %d, want slot 4 in round 2", b.TeamID, b.Slot, b.Round)
+		}
+		if len(b.Service.Checks) != 1 || b.Service.Checks[0].Name != "http" {
+			t.Errorf("team %d: checks %+v", b.TeamID, b.Service.Checks)
+		}
+	}
+}
+
+func TestHandleAgentResults(t *testing.T) {
+	// the fourth slot is in progress; the third ended 10s ago, the second 40s
+	// ago, past its 5s of checks and the grace for reporting
+	f := newAgentFixture(t, 100*time.Second)
+	result := func(team, slot int) structures.CheckResult {
+		return structures.CheckResult{TeamID: team, ServiceID: f.svc.ID, Slot: slot, IsUp: true, Output: "ok"}
+	}
+	tampered := result(f.teams[1], 4)
+	tampered.Penalty = 250
+	tampered.Baselines = []structures.ContentBaseline{{TeamID: f.teams[1], ServiceID: f.svc.ID, Check: "http", Hash: "forged"}}
+
+	tests := []struct {
+		name     string
+		result   structures.CheckResult
+		accepted bool
+	}{
+		{"current slot", result(f.teams[0], 4), true},
+		{"previous slot", result(f.teams[0], 3), true},
+		{"slot long over", result(f.teams[0], 2), false},
+		{"slot not started", result(f.teams[0], 5), false},
+		{"no slot", result(f.teams[1], 0), false},
+		{"unassigned box", result(f.teams[2], 4), false},
+		{"tampered fields", tampered, true},
+	}
+	var results []structures.CheckResult
+	want := 0
+	for _, tt := range tests {
+		results = append(results, tt.result)
+		if tt.accepted {
+			want++
+		}
+	}
+	body, _ := json.Marshal(results)
+	post := func() int {
+		rec := httptest.NewRecorder()
+		HandleAgentResults(rec, agentRequest(http.MethodPost, "/api/agent/results", body, f.agent))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("returned %d: %s", rec.Code, rec.Body)
+		}
+		var resp map[string]int
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+		return resp["accepted"]
+	}
+	if got := post(); got != want {
+		t.Errorf("accepted %d results, want %d", got, want)
+	}
+	// results already recorded are not recorded twice
+	if got := post(); got != 0 {
+		t.Errorf("accepted %d results again", got)
+	}
+
+	recorded := make(map[[2]int]bool)
+	for round := 1; round <= 3; round++ {
+		rows, err := sql_wrapper.GetRoundResults(round)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, r := range rows {
+			recorded[[2]int{r.TeamID, r.Slot}] = true
+			if want := scoring.RoundForSlot(&structures.Competition{RoundInterval: 60}, f.svc, r.Slot); want != round {
+				t.Errorf("slot %d recorded in round %d, want %d", r.Slot, round, want)
+			}
+		}
+	}
+	for _, tt := range tests {
+		if got := recorded[[2]int{tt.result.TeamID, tt.result.Slot}]; got != tt.accepted {
+			t.Errorf("%s: recorded = %v, want %v", tt.name, got, tt.accepted)
+		}
+	}
+	baselines, err := sql_wrapper.GetBaselines(f.svc.ID, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(baselines) != 0 {
+		t.Errorf("stored a baseline for a command check: %+v", baselines)
+	}
+}
+
+func TestSanitizeAgentResult(t *testing.T) {
+	svc := structures.Service{ID: 2, Checks: []structures.Checks{
+		{Name: "http"},
+		{Name: "page", Type: scoring.CheckTypeIntegrity},
+	}}
+	baseline := func(team, service int, check string) structures.ContentBaseline {
+		return structures.ContentBaseline{TeamID: team, ServiceID: service, Check: check}
+	}
+	tests := []struct {
+		name          string
+		penalty       int
+		wantPenalty   int
+		evidence      []string
+		wantEvidence  int
+		baselines     []structures.ContentBaseline
+		wantBaselines int
+	}{
+		{"penalty kept", 30, 30, nil, 0, nil, 0},
+		{"penalty over 100", 250, 100, nil, 0, nil, 0},
+		{"negative penalty", -20, 0, nil, 0, nil, 0},
+		{"evidence for the service's checks", 0, 0, []string{"http", "page"}, 2, nil, 0},
+		{"evidence for other checks", 0, 0, []string{"http", "ssh"}, 1, nil, 0},
+		{"integrity baseline", 0, 0, nil, 0, []structures.ContentBaseline{baseline(1, 2, "page")}, 1},
+		{"baseline for a command check", 0, 0, nil, 0, []structures.ContentBaseline{baseline(1, 2, "http")}, 0},
+		{"baseline for an unknown check", 0, 0, nil, 0, []structures.ContentBaseline{baseline(1, 2, "other")}, 0},
+		{"baseline for another team", 0, 0, nil, 0, []structures.ContentBaseline{baseline(3, 2, "page")}, 0},
+		{"baseline for another service", 0, 0, nil, 0, []structures.ContentBaseline{baseline(1, 5, "page")}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := structures.CheckResult{TeamID: 1, ServiceID: 2, Penalty: tt.penalty, Baselines: tt.baselines}
+			for _, check := range tt.evidence {
+				res.Evidence = append(res.Evidence, structures.CheckEvidence{Check: check, Attempts: []structures.AttemptEvidence{{OK: true, Penalty: 500}}})
+			}
+			sanitizeAgentResult(&res, svc)
+			if res.Penalty != tt.wantPenalty {
+				t.Errorf("penalty %d, want %d", res.Penalty, tt.wantPenalty)
+			}
+			if len(res.Evidence) != tt.wantEvidence {
+				t.Errorf("%d evidence entries kept, want %d", len(res.Evidence), tt.wantEvidence)
+			}
+			for _, ev := range res.Evidence {
+				if ev.Attempts[0].Penalty != 100 {
+					t.Errorf("%s: attempt penalty %d, want 100", ev.Check, ev.Attempts[0].Penalty)
+				}
+			}
+			if len(res.Baselines) != tt.wantBaselines {
+				t.Errorf("%d baselines kept, want %d", len(res.Baselines), tt.wantBaselines)
+			}
+		})
+	}
+}
+
+func TestHandleAgentResultsSlowDependency(t *testing.T) {
+	// Web depends on DNS, which is checked every five minutes. 250s in, Web's
+	// ninth slot starts in round 5 while DNS last ran in round 1.
+	f := newAgentFixture(t, 250*time.Second)
+	dns := structures.Service{Name: "DNS", Interval: 300}
+	if err := sql_wrapper.SaveService(&dns); err != nil {
+		t.Fatal(err)
+	}
+	f.svc.DependsOn = []int{dns.ID}
+	if err := sql_wrapper.SaveService(&f.svc); err != nil {
+		t.Fatal(err)
+	}
+	dnsDown := structures.CheckResult{TeamID: f.teams[0], ServiceID: dns.ID, Round: 1, Slot: 1, Output: "no answer"}
+	if _, err := sql_wrapper.RecordResults([]structures.CheckResult{dnsDown}, []int{0}, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	body, _ := json.Marshal([]structures.CheckResult{
+		{TeamID: f.teams[0], ServiceID: f.svc.ID, Slot: 9, Output: "connection refused"},
+		{TeamID: f.teams[1], ServiceID: f.svc.ID, Slot: 9, Output: "connection refused"},
+	})
+	rec := httptest.NewRecorder()
+	HandleAgentResults(rec, agentRequest(http.MethodPost, "/api/agent/results", body, f.agent))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("returned %d: %s", rec.Code, rec.Body)
+	}
+	rows, err := sql_wrapper.GetRoundResults(5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 {
+		t.Fatalf("%d results recorded in round 5, want 2", len(rows))
+	}
+	for _, r := range rows {
+		want := ""
+		if r.TeamID == f.teams[0] {
+			want = "DNS"
+		}
+		if r.RootCause != want {
+			t.Errorf("team %d: root cause %q, want %q", r.TeamID, r.RootCause, want)
+		}
+	}
+}
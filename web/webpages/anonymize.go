@@ -0,0 +1,189 @@
+package webpages
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	dbsql "BlueDevil-Engine/sql"
+	structures "BlueDevil-Engine/structures"
+)
+
+// teamNames maps team IDs to the names shown in place of their real ones
+// while the competition anonymizes teams. Teams missing from it, and every
+// team when it is nil, keep their real names.
+type teamNames map[int]string
+
+// publicTeamName is the name the public sees for a team: its alias, or
+// "Team N" without one.
+func publicTeamName(t structures.Team) string {
+	if alias := strings.TrimSpace(t.Alias); alias != "" {
+		return alias
+	}
+	return "Team " + strconv.Itoa(t.ID)
+}
+
+// teamAliasConflict returns why t's alias, or its name, cannot be used
+// alongside the other teams, or "" when they can. A public name must not be
+// another team's public name or give away a real one.
+func teamAliasConflict(t structures.Team, teams []structures.Team) string {
+	name, alias := strings.TrimSpace(t.Name), strings.TrimSpace(t.Alias)
+	if alias != "" && strings.EqualFold(alias, name) {
+		return "The alias must differ from the team's name"
+	}
+	for _, other := range teams {
+		if other.ID == t.ID {
+			continue
+		}
+		otherAlias := strings.TrimSpace(other.Alias)
+		switch {
+		case alias != "" && strings.EqualFold(alias, strings.TrimSpace(other.Name)):
+			return fmt.Sprintf("The alias %q is the name of another team", alias)
+		case alias != "" && strings.EqualFold(alias, publicTeamName(other)):
+			return fmt.Sprintf("The alias %q is already shown for another team", alias)
+		case otherAlias != "" && strings.EqualFold(name, otherAlias):
+			return fmt.Sprintf("The name %q is another team's alias", name)
+		}
+	}
+	return ""
+}
+
+// publicTeamNames returns the public name of every team except own, which
+// keeps its real name (0 for none).
+func publicTeamNames(own int) (teamNames, error) {
+	teams, err := dbsql.GetAllTeams()
+	if err != nil {
+		return nil, err
+	}
+	names := make(teamNames)
+	for _, t := range teams {
+		if t.ID != own {
+			names[t.ID] = publicTeamName(t)
+		}
+	}
+	return names, nil
+}
+
+// viewerTeamNames returns the names a viewer sees. Admins see every real
+// name and team members see their own team's.
+func viewerTeamNames(viewer cookieViewer) (teamNames, error) {
+	if viewer.IsAdmin {
+		return nil, nil
+	}
+	own := 0
+	if viewer.Subject != "" {
+		team, err := dbsql.GetUserTeamBySubject(viewer.Subject)
+		if err != nil {
+			return nil, err
+		}
+		if team != nil {
+			own = team.ID
+		}
+	}
+	return publicTeamNames(own)
+}
+
+// anonymizedTeamNames returns the names a viewer sees, or nil when the
+// competition does not anonymize teams.
+func anonymizedTeamNames(viewer cookieViewer) (teamNames, error) {
+	comp, err := dbsql.GetCompetition()
+	if err != nil || !comp.AnonymizeTeams {
+		return nil, err
+	}
+	return viewerTeamNames(viewer)
+}
+
+// except returns n without own, which keeps its real name. n itself is left
+// alone, as it may be shared with other viewers.
+func (n teamNames) except(own int) teamNames {
+	if _, ok := n[own]; !ok {
+		return n
+	}
+	out := make(teamNames, len(n)-1)
+	for id, name := range n {
+		if id != own {
+			out[id] = name
+		}
+	}
+	return out
+}
+
+func (n teamNames) name(teamID int, real string) string {
+	if name, ok := n[teamID]; ok {
+		return name
+	}
+	return real
+}
+
+// scoreboard returns sb with the teams renamed. sb itself is left alone, as
+// it may be shared with other viewers.
+func (n teamNames) scoreboard(sb *Scoreboard) *Scoreboard {
+	if n == nil {
+		return sb
+	}
+	out := *sb
+	out.Standings = make([]ScoreboardStanding, len(sb.Standings))
+	for i, st := range sb.Standings {
+		st.Name = n.name(st.TeamID, st.Name)
+		out.Standings[i] = st
+	}
+	out.Status = make([]ScoreboardTeam, len(sb.Status))
+	for i, t := range sb.Status {
+		t.Name = n.name(t.TeamID, t.Name)
+		out.Status[i] = t
+	}
+	return &out
+}
+
+// scoreUpdate returns up with the teams renamed in its standings.
+func (n teamNames) scoreUpdate(up *ScoreUpdate) *ScoreUpdate {
+	if n == nil {
+		return up
+	}
+	out := *up
+	out.Standings = n.scoreboard(&Scoreboard{Standings: up.Standings}).Standings
+	return &out
+}
+
+// homepage renames the teams in vm, a copy of a snapshot's view model,
+// without touching the snapshot.
+func (n teamNames) homepage(vm *HomepageViewModel) {
+	if n == nil {
+		return
+	}
+	teams := make([]TeamMeta, len(vm.Teams))
+	for i, t := range vm.Teams {
+		t.Name = n.name(t.ID, t.Name)
+		teams[i] = t
+	}
+	vm.Teams = teams
+	standings := make([]TeamStandingVM, len(vm.Standings))
+	for i, st := range vm.Standings {
+		st.Name = n.name(st.TeamID, st.Name)
+		standings[i] = st
+	}
+	vm.Standings = standings
+	vm.Heatmap = n.heatmap(vm.Heatmap)
+}
+
+// heatmap returns hm with the teams renamed in its row labels and cell
+// titles.
+func (n teamNames) heatmap(hm *Heatmap) *Heatmap {
+	if n == nil || hm == nil {
+		return hm
+	}
+	out := *hm
+	out.Rows = make([]HeatmapRow, len(hm.Rows))
+	for i, row := range hm.Rows {
+		if name, ok := n[row.teamID]; ok {
+			row.Label = heatmapLabel(name, row.service)
+		}
+		out.Rows[i] = row
+	}
+	out.Cells = make([]HeatmapCell, len(hm.Cells))
+	for i, c := range hm.Cells {
+		c.Title = out.Rows[c.row].Label + c.detail
+		out.Cells[i] = c
+	}
+	return &out
+}
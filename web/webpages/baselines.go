@@ -0,0 +1,171 @@
+package webpages
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"BlueDevil-Engine/scoring"
+	sql_wrapper "BlueDevil-Engine/sql"
+	structures "BlueDevil-Engine/structures"
+)
+
+// baselineCaptureTimeout bounds a whole capture run across every team.
+const baselineCaptureTimeout = 2 * time.Minute
+
+// captureBaselines fetches golden copies for the baseline-backed integrity
+// checks of serviceID (every service when 0) on each team's box, limited to
+// teamID when it is not 0. With missingOnly set, existing baselines are kept.
+//
+// Boxes checked by remote agents may not be reachable from here, so they are
+// left to capture their own baseline on their next check; when recapturing,
+// their current baselines are cleared for that. It returns how many baselines
+// were stored, how many were left to agents, and one message per failure.
+func captureBaselines(ctx context.Context, serviceID, teamID int, missingOnly bool) (int, int, []string, error) {
+	boxes, err := sql_wrapper.GetAllScoringBoxes()
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	services, err := sql_wrapper.GetAllServices()
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	teams, err := sql_wrapper.GetAllTeams()
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	have := make(map[string]bool)
+	if missingOnly {
+		existing, err := sql_wrapper.GetBaselines(serviceID, false)
+		if err != nil {
+			return 0, 0, nil, err
+		}
+		for _, b := range existing {
+			have[fmt.Sprintf("%d/%d/%s", b.TeamID, b.ServiceID, b.Check)] = true
+		}
+	}
+
+	targets := scoring.BuildBoxes(boxes, services, teams, nil, func(b structures.ScoringBox) bool {
+		return (serviceID == 0 || b.ServiceID == serviceID) && (teamID == 0 || b.TeamID == teamID)
+	})
+	byAgent := make(map[[2]int]bool)
+	for _, b := range boxes {
+		if b.AgentID != nil {
+			byAgent[[2]int{b.TeamID, b.ServiceID}] = true
+		}
+	}
+	captured, deferred := 0, 0
+	var failures []string
+	for _, box := range targets {
+		if byAgent[[2]int{box.TeamID, box.Service.ID}] {
+			n := 0
+			for _, chk := range box.Service.Checks {
+				if scoring.NeedsBaseline(chk) && !have[fmt.Sprintf("%d/%d/%s", box.TeamID, box.Service.ID, chk.Name)] {
+					n++
+				}
+			}
+			if n > 0 && !missingOnly {
+				if err := sql_wrapper.DeleteBaselines(box.Service.ID, box.TeamID); err != nil {
+					return captured, deferred, failures, err
+				}
+			}
+			deferred += n
+			continue
+		}
+		for _, chk := range box.Service.Checks {
+			if !scoring.NeedsBaseline(chk) || have[fmt.Sprintf("%d/%d/%s", box.TeamID, box.Service.ID, chk.Name)] {
+				continue
+			}
+			b, err := scoring.CaptureBaseline(ctx, chk, box)
+			if err == nil {
+				err = sql_wrapper.SaveBaseline(b)
+			}
+			if err != nil {
+				failures = append(failures, fmt.Sprintf("%s / %s / %s: %v", box.TeamName, box.Service.Name, chk.Name, err))
+				continue
+			}
+			captured++
+		}
+	}
+	return captured, deferred, failures, nil
+}
+
+// captureMissingBaselines runs when the competition starts so every team has
+// a golden copy before its first integrity check.
+func captureMissingBaselines() {
+	ctx, cancel := context.WithTimeout(context.Background(), baselineCaptureTimeout)
+	defer cancel()
+	captured, deferred, failures, err := captureBaselines(ctx, 0, 0, true)
+	if err != nil {
+		log.Println("Failed to capture baselines:", err)
+		return
+	}
+	log.Printf("Captured %d integrity baselines; %d left to agents to capture on their first check", captured, deferred)
+	for _, f := range failures {
+		log.Println("Baseline capture failed:", f)
+	}
+}
+
+// Admin API: list, capture and delete golden copies for integrity checks
+func HandleApiBaselines(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		serviceID, _ := strconv.Atoi(r.URL.Query().Get("service_id"))
+		baselines, err := sql_wrapper.GetBaselines(serviceID, false)
+		if err != nil {
+			http.Error(w, "Failed to get baselines: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if baselines == nil {
+			baselines = []structures.ContentBaseline{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(baselines)
+	case http.MethodPost:
+		var req struct {
+			ServiceID   int  `json:"service_id"`
+			TeamID      int  `json:"team_id"`
+			MissingOnly bool `json:"missing_only"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), baselineCaptureTimeout)
+		defer cancel()
+		captured, deferred, failures, err := captureBaselines(ctx, req.ServiceID, req.TeamID, req.MissingOnly)
+		if err != nil {
+			http.Error(w, "Failed to capture baselines: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if failures == nil {
+			failures = []string{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"captured": captured, "deferred": deferred, "failures": failures})
+	case http.MethodDelete:
+		var req struct {
+			ServiceID int `json:"service_id"`
+			TeamID    int `json:"team_id"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.ServiceID == 0 {
+			http.Error(w, "service_id required", http.StatusBadRequest)
+			return
+		}
+		if err := sql_wrapper.DeleteBaselines(req.ServiceID, req.TeamID); err != nil {
+			http.Error(w, "Failed to delete baselines: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
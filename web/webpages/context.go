@@ -4,3 +4,6 @@ package webpages
 type ContextKey string
 
 var CtxUserKey ContextKey = "user"
+
+// CtxAgentKey stores the authenticated scoring agent for /api/agent requests
+var CtxAgentKey ContextKey = "agent"
@@ -0,0 +1,131 @@
+package webpages
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"BlueDevil-Engine/scoring"
+	sql_wrapper "BlueDevil-Engine/sql"
+	structures "BlueDevil-Engine/structures"
+)
+
+// healthRounds is how many recent rounds the engine health summary covers.
+const healthRounds = 10
+
+// staleRounds is how many round intervals may pass without a round completing
+// before the dashboard warns that scoring has stopped.
+const staleRounds = 2
+
+// latencyBounds are the upper bounds, in milliseconds, of the check latency
+// histogram buckets.
+var latencyBounds = []int{100, 250, 500, 1000, 2500, 5000, 10000}
+
+// Admin API: which scoring-service instance leads, when it last scored and
+// whether scoring is keeping up
+func HandleApiEngine(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	st, err := sql_wrapper.GetEngineStatus()
+	if err != nil {
+		http.Error(w, "Failed to get engine status: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	now := time.Now().UTC()
+	if expires, err := time.Parse(time.RFC3339, st.LeaseExpires); err == nil {
+		st.LeaderAlive = st.Leader != "" && now.Before(expires)
+	}
+	if st.Health, err = engineHealth(now); err != nil {
+		http.Error(w, "Failed to get engine health: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(st)
+}
+
+func engineHealth(now time.Time) (*structures.EngineHealth, error) {
+	comp, err := sql_wrapper.GetCompetition()
+	if err != nil {
+		return nil, err
+	}
+	rounds, err := sql_wrapper.GetRounds(healthRounds)
+	if err != nil {
+		return nil, err
+	}
+	interval := scoring.RoundInterval(comp)
+	h := &structures.EngineHealth{
+		CurrentRound:  scoring.RoundAt(comp, now),
+		RoundInterval: int(interval / time.Second),
+		Recent:        rounds,
+	}
+	h.CompetitionRunning = h.CurrentRound > 0
+	if h.Recent == nil {
+		h.Recent = []structures.Round{}
+	}
+	for i, rd := range rounds {
+		h.TimedOut += rd.TimedOut
+		h.Errors += rd.Errors
+		if h.LastCompleted == nil && rd.Status == sql_wrapper.RoundComplete {
+			h.LastCompleted = &rounds[i]
+			start, err1 := time.Parse(time.RFC3339, rd.StartedAt)
+			end, err2 := time.Parse(time.RFC3339, rd.EndedAt)
+			if err1 == nil && err2 == nil {
+				h.LastDurationSec = end.Sub(start).Seconds()
+			}
+		}
+	}
+	if len(rounds) > 0 {
+		durations, err := sql_wrapper.GetCheckDurations(rounds[len(rounds)-1].Number)
+		if err != nil {
+			return nil, err
+		}
+		h.Latency = latencyStats(durations)
+	} else {
+		h.Latency = latencyStats(nil)
+	}
+
+	// once the competition has run for long enough, some round should have
+	// completed in the last staleRounds intervals
+	if h.CompetitionRunning && h.CurrentRound > staleRounds {
+		window := staleRounds * interval
+		var last time.Time
+		if h.LastCompleted != nil {
+			last, _ = time.Parse(time.RFC3339, h.LastCompleted.EndedAt)
+		}
+		if now.Sub(last) > window {
+			h.Stale = true
+			if last.IsZero() {
+				h.Warning = fmt.Sprintf("No round has completed in round %d of the competition. Scoring is not running.", h.CurrentRound)
+			} else {
+				h.Warning = fmt.Sprintf("No round has completed for %s (more than %d round intervals). Scoring may have stopped.",
+					now.Sub(last).Round(time.Second), staleRounds)
+			}
+		}
+	}
+	return h, nil
+}
+
+// latencyStats summarizes check durations in milliseconds.
+func latencyStats(durations []int) structures.LatencyStats {
+	st := structures.LatencyStats{
+		Count:   len(durations),
+		Bounds:  latencyBounds,
+		Buckets: make([]int, len(latencyBounds)+1),
+	}
+	if len(durations) == 0 {
+		return st
+	}
+	sort.Ints(durations)
+	pct := func(p int) int { return durations[(len(durations)-1)*p/100] }
+	st.P50, st.P90, st.P99 = pct(50), pct(90), pct(99)
+	st.Max = durations[len(durations)-1]
+	for _, d := range durations {
+		i := sort.SearchInts(latencyBounds, d+1)
+		st.Buckets[i]++
+	}
+	return st
+}
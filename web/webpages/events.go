@@ -0,0 +1,374 @@
+package webpages
+
+// Live scoreboard updates over Server-Sent Events. Viewers of the homepage
+// subscribe to /api/events instead of reloading the page; the server builds
+// each update once per kind of viewer and pushes a compact delta: admins get a
+// live variant while the scoreboard is frozen, team members see their own
+// team's name while teams are anonymized, and injects are only announced to
+// logged-in users.
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	dbsql "BlueDevil-Engine/sql"
+)
+
+// Event types pushed to viewers.
+const (
+	EventRound      = "round"
+	EventAdjustment = "adjustment"
+	EventInject     = "inject"
+)
+
+// eventPollInterval is how often the watcher looks for completed rounds and
+// released injects.
+const eventPollInterval = 2 * time.Second
+
+// eventKeepAlive keeps idle connections from being closed by proxies.
+const eventKeepAlive = 25 * time.Second
+
+// ScoreUpdate is the delta pushed when a round completes or scores are
+// adjusted. Points holds the per-team points of the affected rounds only;
+// Status and Uptime are sent for completed rounds.
+type ScoreUpdate struct {
+	Round     int                    `json:"round,omitempty"`
+	Frozen    bool                   `json:"frozen"`
+	Live      bool                   `json:"live,omitempty"`
+	Standings []ScoreboardStanding   `json:"standings"`
+	Points    []ScoreboardRound      `json:"points,omitempty"`
+	Status    []ScoreboardStatusCell `json:"status,omitempty"`
+	Uptime    []ScoreboardUptimeCell `json:"uptime,omitempty"`
+}
+
+// ScoreboardStatusCell is the latest status of one team's service.
+type ScoreboardStatusCell struct {
+	TeamID    int  `json:"team_id"`
+	ServiceID int  `json:"service_id"`
+	IsUp      bool `json:"is_up"`
+}
+
+// ScoreboardUptimeCell is one team's uptime percentage for a service.
+type ScoreboardUptimeCell struct {
+	TeamID    int     `json:"team_id"`
+	ServiceID int     `json:"service_id"`
+	Percent   float64 `json:"percent"`
+}
+
+// InjectRelease is pushed when an inject becomes visible to teams.
+type InjectRelease struct {
+	InjectID string `json:"inject_id"`
+	Title    string `json:"title"`
+}
+
+// eventViewer is who a subscription belongs to.
+type eventViewer struct {
+	admin    bool
+	loggedIn bool
+	// team is the viewer's team, 0 for none
+	team int
+}
+
+// eventHub fans events out to every connected viewer.
+type eventHub struct {
+	mu   sync.Mutex
+	subs map[chan []byte]eventViewer
+}
+
+var events = &eventHub{subs: make(map[chan []byte]eventViewer)}
+
+func (h *eventHub) subscribe(v eventViewer) chan []byte {
+	ch := make(chan []byte, 8)
+	h.mu.Lock()
+	h.subs[ch] = v
+	h.mu.Unlock()
+	return ch
+}
+
+func (h *eventHub) unsubscribe(ch chan []byte) {
+	h.mu.Lock()
+	delete(h.subs, ch)
+	h.mu.Unlock()
+}
+
+// count returns the number of viewers and how many of them are admins.
+func (h *eventHub) count() (int, int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	admins := 0
+	for _, v := range h.subs {
+		if v.admin {
+			admins++
+		}
+	}
+	return len(h.subs), admins
+}
+
+// publish sends an event to every viewer data returns a payload for, calling
+// it and encoding the payload once per kind of viewer. Viewers too slow to
+// keep up miss it rather than holding up the others.
+func (h *eventHub) publish(kind string, data func(v eventViewer) interface{}) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	msgs := make(map[eventViewer][]byte)
+	for ch, v := range h.subs {
+		msg, ok := msgs[v]
+		if !ok {
+			if payload := data(v); payload != nil {
+				var err error
+				if msg, err = eventMessage(kind, payload); err != nil {
+					log.Println("events: encoding", kind, "event:", err)
+					return
+				}
+			}
+			msgs[v] = msg
+		}
+		if msg == nil {
+			continue
+		}
+		select {
+		case ch <- msg:
+		default:
+		}
+	}
+}
+
+func eventMessage(kind string, data interface{}) ([]byte, error) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(fmt.Sprintf("event: %s\ndata: %s\n\n", kind, payload)), nil
+}
+
+// buildScoreUpdate builds the delta for the given rounds from the scoreboard,
+// so the final freeze applies unless live is set. withStatus adds the status
+// grid and uptime, which only change when results are recorded.
+func buildScoreUpdate(live, withStatus bool, rounds ...int) (*ScoreUpdate, error) {
+	sb, err := buildScoreboard(live)
+	if err != nil {
+		return nil, err
+	}
+	up := &ScoreUpdate{Frozen: sb.Frozen, Live: sb.Live, Standings: sb.Standings}
+	want := make(map[int]bool)
+	for _, r := range rounds {
+		want[r] = true
+		up.Round = max(up.Round, r)
+	}
+	for _, rs := range sb.Rounds {
+		if want[rs.Round] {
+			up.Points = append(up.Points, rs)
+		}
+	}
+	if !withStatus {
+		return up, nil
+	}
+	for _, t := range sb.Status {
+		for _, st := range t.Services {
+			up.Status = append(up.Status, ScoreboardStatusCell{TeamID: t.TeamID, ServiceID: st.ServiceID, IsUp: st.IsUp})
+		}
+	}
+	shownBefore := sb.FrozenBefore
+	if sb.Live {
+		shownBefore = 0
+	}
+	uptime, err := dbsql.GetTeamServiceUptimePercents(shownBefore)
+	if err != nil {
+		return nil, err
+	}
+	for teamID, bySvc := range uptime {
+		for svcID, pct := range bySvc {
+			up.Uptime = append(up.Uptime, ScoreboardUptimeCell{TeamID: teamID, ServiceID: svcID, Percent: pct})
+		}
+	}
+	return up, nil
+}
+
+// publishScoreUpdate rebuilds the homepage snapshot and pushes a score delta
+// for the given rounds to every viewer.
+func publishScoreUpdate(kind string, rounds ...int) {
+	if _, err := homepages.rebuild(); err != nil {
+		log.Println("events: rebuilding homepage:", err)
+	}
+	viewers, admins := events.count()
+	if viewers == 0 {
+		return
+	}
+	up, err := buildScoreUpdate(false, kind == EventRound, rounds...)
+	if err != nil {
+		log.Println("events: building", kind, "update:", err)
+		return
+	}
+	// admins see through the freeze
+	var live *ScoreUpdate
+	if up.Frozen && admins > 0 {
+		if live, err = buildScoreUpdate(true, kind == EventRound, rounds...); err != nil {
+			log.Println("events: building", kind, "update:", err)
+			return
+		}
+	}
+	comp, err := dbsql.GetCompetition()
+	if err != nil {
+		log.Println("events: building", kind, "update:", err)
+		return
+	}
+	var names teamNames
+	if comp.AnonymizeTeams {
+		if names, err = publicTeamNames(0); err != nil {
+			log.Println("events: building", kind, "update:", err)
+			return
+		}
+	}
+	events.publish(kind, scoreUpdateFor(up, live, names))
+}
+
+// scoreUpdateFor returns the update each viewer gets: admins get live when it
+// is not nil and real names, everyone else up with teams renamed by names
+// except their own.
+func scoreUpdateFor(up, live *ScoreUpdate, names teamNames) func(v eventViewer) interface{} {
+	return func(v eventViewer) interface{} {
+		if v.admin {
+			if live != nil {
+				return live
+			}
+			return up
+		}
+		return names.except(v.team).scoreUpdate(up)
+	}
+}
+
+// injectReleaseFor returns the inject release announcement for logged-in
+// viewers only, as the public cannot open injects.
+func injectReleaseFor(in InjectRelease) func(v eventViewer) interface{} {
+	return func(v eventViewer) interface{} {
+		if !v.loggedIn {
+			return nil
+		}
+		return in
+	}
+}
+
+// WatchEvents polls for completed rounds and newly released injects and
+// pushes them to viewers until ctx is done. Rounds are recorded by the scoring
+// service, a separate process, so they are noticed in the database.
+func WatchEvents(ctx context.Context) {
+	lastRound, err := dbsql.GetLastCompletedRound()
+	if err != nil {
+		log.Println("events: reading rounds:", err)
+	}
+	released, err := releasedInjects()
+	if err != nil {
+		log.Println("events: reading injects:", err)
+	}
+	ticker := time.NewTicker(eventPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		round, err := dbsql.GetLastCompletedRound()
+		if err != nil {
+			log.Println("events: reading rounds:", err)
+			continue
+		}
+		if round < lastRound {
+			// scoring was reset
+			lastRound = 0
+		}
+		if round > lastRound {
+			var rounds []int
+			for r := lastRound + 1; r <= round; r++ {
+				rounds = append(rounds, r)
+			}
+			publishScoreUpdate(EventRound, rounds...)
+			lastRound = round
+		}
+
+		now, err := releasedInjects()
+		if err != nil {
+			log.Println("events: reading injects:", err)
+			continue
+		}
+		for id, in := range now {
+			if _, seen := released[id]; !seen && released != nil {
+				events.publish(EventInject, injectReleaseFor(in))
+			}
+		}
+		released = now
+	}
+}
+
+// releasedInjects returns the injects currently visible to teams by inject ID.
+func releasedInjects() (map[string]InjectRelease, error) {
+	comp, err := dbsql.GetCompetition()
+	if err != nil {
+		return nil, err
+	}
+	injects, err := dbsql.GetAllInjects()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	out := make(map[string]InjectRelease)
+	for i := range injects {
+		if injectVisibleToUser(&injects[i], comp, now, false) {
+			out[injects[i].InjectID] = InjectRelease{InjectID: injects[i].InjectID, Title: injects[i].Title}
+		}
+	}
+	return out, nil
+}
+
+// Public API: a Server-Sent Events stream of scoreboard updates
+func HandleEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	viewer := getViewerFromCookie(r)
+	sub := eventViewer{admin: viewer.IsAdmin, loggedIn: viewer.LoggedIn}
+	if viewer.Subject != "" && !viewer.IsAdmin {
+		team, err := dbsql.GetUserTeamBySubject(viewer.Subject)
+		if err != nil {
+			http.Error(w, "Failed to get team: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if team != nil {
+			sub.team = team.ID
+		}
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	// reconnect after 5 seconds if the connection drops
+	fmt.Fprint(w, "retry: 5000\n\n")
+	flusher.Flush()
+
+	ch := events.subscribe(sub)
+	defer events.unsubscribe(ch)
+	keepAlive := time.NewTicker(eventKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case msg := <-ch:
+			if _, err := w.Write(msg); err != nil {
+				return
+			}
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,72 @@
+package webpages
+
+import (
+	"strings"
+	"testing"
+)
+
+// receive returns the event waiting on ch, or "" when there is none.
+func receive(ch chan []byte) string {
+	select {
+	case msg := <-ch:
+		return string(msg)
+	default:
+		return ""
+	}
+}
+
+func TestEventsPerViewer(t *testing.T) {
+	hub := &eventHub{subs: make(map[chan []byte]eventViewer)}
+	anonymous := hub.subscribe(eventViewer{})
+	member := hub.subscribe(eventViewer{loggedIn: true, team: 1})
+	other := hub.subscribe(eventViewer{loggedIn: true, team: 2})
+	admin := hub.subscribe(eventViewer{admin: true, loggedIn: true})
+
+	t.Run("inject", func(t *testing.T) {
+		hub.publish(EventInject, injectReleaseFor(InjectRelease{InjectID: "I-1", Title: "Password policy"}))
+		if msg := receive(anonymous); msg != "" {
+			t.Errorf("anonymous viewer got %q", msg)
+		}
+		for name, ch := range map[string]chan []byte{"member": member, "other": other, "admin": admin} {
+			if msg := receive(ch); !strings.Contains(msg, "Password policy") {
+				t.Errorf("%s got %q", name, msg)
+			}
+		}
+	})
+
+	t.Run("score update", func(t *testing.T) {
+		standings := []ScoreboardStanding{{Rank: 1, TeamID: 1, Name: "Red Herrings"}, {Rank: 2, TeamID: 2, Name: "Blue Whales"}}
+		up := &ScoreUpdate{Frozen: true, Standings: standings}
+		live := &ScoreUpdate{Frozen: true, Live: true, Standings: standings}
+		names := teamNames{1: "Team 1", 2: "Team 2"}
+		hub.publish(EventRound, scoreUpdateFor(up, live, names))
+
+		tests := []struct {
+			name   string
+			ch     chan []byte
+			want   []string
+			hidden []string
+		}{
+			{"anonymous", anonymous, []string{`"Team 1"`, `"Team 2"`}, []string{"Red Herrings", "Blue Whales", `"live"`}},
+			{"member", member, []string{"Red Herrings", `"Team 2"`}, []string{"Blue Whales", `"live"`}},
+			{"other", other, []string{`"Team 1"`, "Blue Whales"}, []string{"Red Herrings", `"live"`}},
+			{"admin", admin, []string{"Red Herrings", "Blue Whales", `"live":true`}, nil},
+		}
+		for _, tt := range tests {
+			msg := receive(tt.ch)
+			for _, s := range tt.want {
+				if !strings.Contains(msg, s) {
+					t.Errorf("%s: %q does not contain %s", tt.name, msg, s)
+				}
+			}
+			for _, s := range tt.hidden {
+				if strings.Contains(msg, s) {
+					t.Errorf("%s: %q contains %s", tt.name, msg, s)
+				}
+			}
+		}
+		if standings[0].Name != "Red Herrings" || names[1] != "Team 1" {
+			t.Error("renaming changed the shared update")
+		}
+	})
+}
@@ -0,0 +1,250 @@
+package webpages
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"time"
+
+	"BlueDevil-Engine/scoring"
+	dbsql "BlueDevil-Engine/sql"
+	structures "BlueDevil-Engine/structures"
+)
+
+// Heatmap geometry, in SVG user units
+const (
+	heatmapLabelWidth = 160.0
+	heatmapPlotWidth  = 640.0
+	heatmapRowHeight  = 14.0
+	heatmapRowGap     = 2.0
+	heatmapTeamGap    = 8.0
+	heatmapAxisHeight = 20.0
+	heatmapMinCell    = 3.0
+	heatmapMaxCell    = 16.0
+)
+
+// Heatmap is the uptime timeline drawn by templates/heatmap.svg: one row per
+// team and service, one column per round.
+type Heatmap struct {
+	Width  float64
+	Height float64
+	Rows   []HeatmapRow
+	Cells  []HeatmapCell
+	Ticks  []HeatmapTick
+	// AxisY is where the round numbers are drawn
+	AxisY float64
+}
+
+type HeatmapRow struct {
+	Label string
+	Y     float64 // text baseline
+
+	// kept to relabel the row when teams are anonymized
+	teamID  int
+	service string
+}
+
+type HeatmapCell struct {
+	X, Y, W, H float64
+	Color      string
+	// Title is shown on hover
+	Title string
+
+	// row indexes Rows; Title is the row's label followed by detail
+	row    int
+	detail string
+}
+
+type HeatmapTick struct {
+	X     float64
+	Round int
+}
+
+// heatmapColor is green when every check in the round passed, red when none
+// did and amber when some did.
+func heatmapColor(up, total int) string {
+	switch {
+	case up == total:
+		return "#10b981"
+	case up == 0:
+		return "#ef4444"
+	default:
+		return "#f59e0b"
+	}
+}
+
+func heatmapLabel(team, service string) string {
+	return team + " · " + service
+}
+
+// heatmapTickStep spaces round labels about a tenth of the rounds apart,
+// on 1, 2 or 5 times a power of ten.
+func heatmapTickStep(rounds int) int {
+	for step := 1; ; step *= 10 {
+		for _, m := range []int{1, 2, 5} {
+			if rounds/(step*m) <= 10 {
+				return step * m
+			}
+		}
+	}
+}
+
+// buildHeatmap lays out the uptime timeline, limited to rounds before
+// beforeRound when it is not 0. It returns nil when nothing has been checked
+// yet.
+func buildHeatmap(teams []structures.Team, services []structures.Service, beforeRound int) (*Heatmap, error) {
+	statuses, err := dbsql.GetRoundStatuses(beforeRound)
+	if err != nil {
+		return nil, err
+	}
+	if len(statuses) == 0 {
+		return nil, nil
+	}
+	comp, err := dbsql.GetCompetition()
+	if err != nil {
+		return nil, err
+	}
+	rounds, err := dbsql.GetRounds(0)
+	if err != nil {
+		return nil, err
+	}
+	started := make(map[int]string)
+	for _, r := range rounds {
+		started[r.Number] = r.StartedAt
+	}
+	roundTime := func(round int) string {
+		t, err := time.Parse(time.RFC3339, started[round])
+		if err != nil {
+			// rounds recorded before the engine kept a round log
+			t = scoring.RoundStart(comp, round)
+		}
+		if t.IsZero() {
+			return ""
+		}
+		return t.UTC().Format("2006-01-02 15:04:05 UTC")
+	}
+
+	maxRound := 0
+	for _, s := range statuses {
+		maxRound = max(maxRound, s.Round)
+	}
+	cell := min(max(heatmapPlotWidth/float64(maxRound), heatmapMinCell), heatmapMaxCell)
+
+	hm := &Heatmap{}
+	rowY := make(map[[2]int]float64)
+	rowIndex := make(map[[2]int]int)
+	y := 0.0
+	for i, t := range teams {
+		if i > 0 {
+			y += heatmapTeamGap
+		}
+		for _, s := range services {
+			key := [2]int{t.ID, s.ID}
+			rowY[key] = y
+			rowIndex[key] = len(hm.Rows)
+			hm.Rows = append(hm.Rows, HeatmapRow{Label: heatmapLabel(t.Name, s.Name), Y: y + heatmapRowHeight - 3, teamID: t.ID, service: s.Name})
+			y += heatmapRowHeight + heatmapRowGap
+		}
+	}
+	for _, s := range statuses {
+		key := [2]int{s.TeamID, s.ServiceID}
+		ry, ok := rowY[key]
+		if !ok || s.Round < 1 {
+			continue
+		}
+		row := rowIndex[key]
+		status := "up"
+		if s.Up == 0 {
+			status = "down"
+		} else if s.Up < s.Total {
+			status = "partly up"
+		}
+		detail := fmt.Sprintf(" — round %d", s.Round)
+		if when := roundTime(s.Round); when != "" {
+			detail += ", " + when
+		}
+		detail += fmt.Sprintf(": %s (%d/%d checks passed)", status, s.Up, s.Total)
+		hm.Cells = append(hm.Cells, HeatmapCell{
+			X:      heatmapLabelWidth + float64(s.Round-1)*cell,
+			Y:      ry,
+			W:      max(cell-1, 1),
+			H:      heatmapRowHeight,
+			Color:  heatmapColor(s.Up, s.Total),
+			Title:  hm.Rows[row].Label + detail,
+			row:    row,
+			detail: detail,
+		})
+	}
+	hm.AxisY = y + heatmapAxisHeight - 6
+	step := heatmapTickStep(maxRound)
+	for r := 1; r <= maxRound; r++ {
+		if r == 1 || r%step == 0 {
+			hm.Ticks = append(hm.Ticks, HeatmapTick{X: heatmapLabelWidth + (float64(r)-0.5)*cell, Round: r})
+		}
+	}
+	hm.Width = heatmapLabelWidth + float64(maxRound)*cell + 10
+	hm.Height = y + heatmapAxisHeight
+	return hm, nil
+}
+
+// Public API: the uptime heatmap as SVG, so the homepage can redraw it when a
+// round completes
+func HandleApiHeatmap(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	teams, err := dbsql.GetAllTeams()
+	if err != nil {
+		http.Error(w, "Failed to get teams: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	services, err := dbsql.GetAllServices()
+	if err != nil {
+		http.Error(w, "Failed to get services: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	comp, err := dbsql.GetCompetition()
+	if err != nil {
+		http.Error(w, "Failed to get competition: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// during the final freeze only rounds from before it are shown, except
+	// to admins
+	viewer := getViewerFromCookie(r)
+	frozenBefore, frozen, err := freezeState(comp)
+	if err != nil {
+		http.Error(w, "Failed to get rounds: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !frozen || viewer.IsAdmin {
+		frozenBefore = 0
+	}
+	hm, err := buildHeatmap(teams, services, frozenBefore)
+	if err != nil {
+		http.Error(w, "Failed to get heatmap: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	names, err := anonymizedTeamNames(viewer)
+	if err != nil {
+		http.Error(w, "Failed to get teams: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	hm = names.heatmap(hm)
+	tmpl, err := template.ParseFiles("templates/heatmap.svg")
+	if err != nil {
+		http.Error(w, "template parse error", http.StatusInternalServerError)
+		log.Println("heatmap: template parse error:", err)
+		return
+	}
+	var buf bytes.Buffer
+	if err := tmpl.ExecuteTemplate(&buf, "heatmap.svg", hm); err != nil {
+		log.Println("heatmap: template exec error:", err)
+		http.Error(w, "template exec error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "image/svg+xml")
+	_, _ = w.Write(buf.Bytes())
+}
@@ -4,13 +4,14 @@ import (
 	"bytes"
 	"context"
 	"fmt"
-	"html/template"
 	"log"
 	"net/http"
 	"os"
 	"sort"
 	"sync"
+	"time"
 
+	"BlueDevil-Engine/scoring"
 	dbsql "BlueDevil-Engine/sql"
 
 	"github.com/coreos/go-oidc/v3/oidc"
@@ -25,6 +26,7 @@ type HomepageViewModel struct {
 	TeamServiceUptime map[int]map[int]float64 // teamID -> serviceID -> uptime%
 	Standings         []TeamStandingVM
 	ScoresByRound     []RoundScoreVM
+	Heatmap           *Heatmap // nil until something has been checked
 	AutoRefreshSec    int
 	// Navbar / session info
 	IsLoggedIn bool
@@ -32,6 +34,12 @@ type HomepageViewModel struct {
 	UserName   string
 	Active     string
 	HasScoring bool
+	// Phase is the competition phase in progress ("" when not running) and
+	// Frozen is set once the final freeze has begun. Live is set when an
+	// admin is shown the scores hidden by the freeze.
+	Phase  string
+	Frozen bool
+	Live   bool
 }
 
 type ServiceMeta struct {
@@ -47,10 +55,11 @@ type TeamMeta struct {
 }
 
 type TeamStandingVM struct {
-	TeamID int
-	Name   string
-	Points int
-	Rank   int
+	TeamID       int
+	Name         string
+	Points       int
+	InjectPoints int
+	Rank         int
 }
 
 type RoundScoreVM struct {
@@ -59,22 +68,18 @@ type RoundScoreVM struct {
 	Points int
 }
 
-// HandleHomepage serves the public homepage
-func HandleHomepage(w http.ResponseWriter, r *http.Request) {
-	// Try to identify user from id_token (optional)
-	isLoggedIn, isAdmin, userName := getUserInfoFromCookie(r)
+// buildHomepage loads the homepage view model, honoring the final freeze
+// unless live is set. The fields describing the viewer are left for the
+// handler to fill in.
+func buildHomepage(live bool) (*HomepageViewModel, error) {
 	// Load services and teams
 	services, err := dbsql.GetAllServices()
 	if err != nil {
-		http.Error(w, "failed to load services", http.StatusInternalServerError)
-		log.Println("homepage: services error:", err)
-		return
+		return nil, fmt.Errorf("services: %w", err)
 	}
 	teams, err := dbsql.GetAllTeams()
 	if err != nil {
-		http.Error(w, "failed to load teams", http.StatusInternalServerError)
-		log.Println("homepage: teams error:", err)
-		return
+		return nil, fmt.Errorf("teams: %w", err)
 	}
 
 	// Build meta slices
@@ -89,36 +94,44 @@ func HandleHomepage(w http.ResponseWriter, r *http.Request) {
 		teamMeta = append(teamMeta, TeamMeta{ID: t.ID, Name: t.Name, Color: color})
 	}
 
+	comp, err := dbsql.GetCompetition()
+	if err != nil {
+		return nil, fmt.Errorf("competition: %w", err)
+	}
+	// during the final freeze only points and statuses from before it are
+	// shown, except to admins
+	frozenBefore, frozen, err := freezeState(comp)
+	if err != nil {
+		return nil, fmt.Errorf("rounds: %w", err)
+	}
+	if live {
+		frozenBefore = 0
+	}
+
 	// Load homepage aggregates
-	latest, err := dbsql.GetLatestStatuses()
+	latest, err := dbsql.GetLatestStatuses(frozenBefore)
 	if err != nil {
-		http.Error(w, "failed to load latest statuses", http.StatusInternalServerError)
-		log.Println("homepage: latest statuses error:", err)
-		return
+		return nil, fmt.Errorf("latest statuses: %w", err)
 	}
-	uptime, err := dbsql.GetServiceUptimePercents()
+	uptime, err := dbsql.GetServiceUptimePercents(frozenBefore)
 	if err != nil {
-		http.Error(w, "failed to load uptime", http.StatusInternalServerError)
-		log.Println("homepage: uptime error:", err)
-		return
+		return nil, fmt.Errorf("uptime: %w", err)
 	}
-	teamSvcUptime, err := dbsql.GetTeamServiceUptimePercents()
+	teamSvcUptime, err := dbsql.GetTeamServiceUptimePercents(frozenBefore)
 	if err != nil {
-		http.Error(w, "failed to load team/service uptime", http.StatusInternalServerError)
-		log.Println("homepage: team/service uptime error:", err)
-		return
+		return nil, fmt.Errorf("team/service uptime: %w", err)
 	}
-	standings, err := dbsql.GetTeamStandings()
+	standings, err := dbsql.GetTeamStandings(frozenBefore)
 	if err != nil {
-		http.Error(w, "failed to load standings", http.StatusInternalServerError)
-		log.Println("homepage: standings error:", err)
-		return
+		return nil, fmt.Errorf("standings: %w", err)
 	}
-	roundScores, err := dbsql.GetTeamScoresByRound()
+	roundScores, err := dbsql.GetTeamScoresByRound(frozenBefore)
 	if err != nil {
-		http.Error(w, "failed to load round scores", http.StatusInternalServerError)
-		log.Println("homepage: round scores error:", err)
-		return
+		return nil, fmt.Errorf("round scores: %w", err)
+	}
+	heatmap, err := buildHeatmap(teams, services, frozenBefore)
+	if err != nil {
+		return nil, fmt.Errorf("heatmap: %w", err)
 	}
 
 	// Transform
@@ -142,13 +155,13 @@ func HandleHomepage(w http.ResponseWriter, r *http.Request) {
 	// sort standings and assign rank
 	sort.SliceStable(standings, func(i, j int) bool {
 		if standings[i].Points == standings[j].Points {
-			return standings[i].Name < standings[j].Name
+			return standings[i].TeamID < standings[j].TeamID
 		}
 		return standings[i].Points > standings[j].Points
 	})
 	var standingsVM []TeamStandingVM
 	for i, st := range standings {
-		standingsVM = append(standingsVM, TeamStandingVM{TeamID: st.TeamID, Name: st.Name, Points: st.Points, Rank: i + 1})
+		standingsVM = append(standingsVM, TeamStandingVM{TeamID: st.TeamID, Name: st.Name, Points: st.Points, InjectPoints: st.InjectPoints, Rank: i + 1})
 	}
 	var roundVM []RoundScoreVM
 	maxRound := 0
@@ -216,18 +229,7 @@ func HandleHomepage(w http.ResponseWriter, r *http.Request) {
 		t.Path = path
 	}
 
-	// active tab based on path
-	active := "scoring"
-	switch r.URL.Path {
-	case "/info":
-		active = "info"
-	case "/injects":
-		active = "injects"
-	case "/practice":
-		active = "practice"
-	}
-
-	vm := HomepageViewModel{
+	return &HomepageViewModel{
 		Services:          svcMeta,
 		Teams:             teamMeta,
 		LatestStatuses:    latestMap,
@@ -235,24 +237,58 @@ func HandleHomepage(w http.ResponseWriter, r *http.Request) {
 		TeamServiceUptime: teamSvcUptime,
 		Standings:         standingsVM,
 		ScoresByRound:     roundVM,
+		Heatmap:           heatmap,
 		AutoRefreshSec:    5,
-		IsLoggedIn:        isLoggedIn,
-		IsAdmin:           isAdmin,
-		UserName:          userName,
-		Active:            active,
 		HasScoring:        len(latest) > 0 || len(roundVM) > 0,
-	}
+		Frozen:            frozen,
+		Live:              frozen && live,
+	}, nil
+}
 
-	// Parse and execute template
-	tmpl, err := template.ParseFiles("templates/homepage.html")
+// HandleHomepage serves the public homepage from the latest snapshot
+func HandleHomepage(w http.ResponseWriter, r *http.Request) {
+	// Try to identify user from id_token (optional)
+	viewer := getViewerFromCookie(r)
+	snap, err := homepages.get()
 	if err != nil {
-		http.Error(w, "template parse error", http.StatusInternalServerError)
-		log.Println("homepage: template parse error:", err)
+		http.Error(w, "failed to load scoreboard", http.StatusInternalServerError)
+		log.Println("homepage:", err)
 		return
 	}
+
+	// active tab based on path
+	active := "scoring"
+	switch r.URL.Path {
+	case "/info":
+		active = "info"
+	case "/injects":
+		active = "injects"
+	case "/practice":
+		active = "practice"
+	}
+
+	vm := *snap.public
+	if viewer.IsAdmin && snap.live != nil {
+		vm = *snap.live
+	}
+	if snap.comp.AnonymizeTeams {
+		names, err := viewerTeamNames(viewer)
+		if err != nil {
+			http.Error(w, "failed to load scoreboard", http.StatusInternalServerError)
+			log.Println("homepage:", err)
+			return
+		}
+		names.homepage(&vm)
+	}
+	vm.IsLoggedIn = viewer.LoggedIn
+	vm.IsAdmin = viewer.IsAdmin
+	vm.UserName = viewer.Name
+	vm.Active = active
+	vm.Phase = scoring.PhaseAt(snap.comp, time.Now())
+
 	// buffer the output to avoid superfluous WriteHeader on exec errors
 	var buf bytes.Buffer
-	if err := tmpl.Execute(&buf, vm); err != nil {
+	if err := snap.tmpl.Execute(&buf, vm); err != nil {
 		log.Println("homepage: template exec error:", err)
 		http.Error(w, "template exec error", http.StatusInternalServerError)
 		return
@@ -286,14 +322,27 @@ func initNavbarVerifier() {
 }
 
 func getUserInfoFromCookie(r *http.Request) (loggedIn bool, isAdmin bool, name string) {
+	v := getViewerFromCookie(r)
+	return v.LoggedIn, v.IsAdmin, v.Name
+}
+
+// cookieViewer is the viewer identified by the optional id_token cookie.
+type cookieViewer struct {
+	LoggedIn bool
+	IsAdmin  bool
+	Name     string
+	Subject  string
+}
+
+func getViewerFromCookie(r *http.Request) cookieViewer {
 	navbarOnce.Do(initNavbarVerifier)
 	cookie, err := r.Cookie("id_token")
 	if err != nil || cookie.Value == "" || navbarVerifier == nil || navbarInitErr != nil {
-		return false, false, ""
+		return cookieViewer{}
 	}
 	idToken, err := navbarVerifier.Verify(r.Context(), cookie.Value)
 	if err != nil {
-		return false, false, ""
+		return cookieViewer{}
 	}
 	var claims struct {
 		Email  string   `json:"email"`
@@ -301,7 +350,7 @@ func getUserInfoFromCookie(r *http.Request) (loggedIn bool, isAdmin bool, name s
 		Groups []string `json:"groups"`
 	}
 	if err := idToken.Claims(&claims); err != nil {
-		return true, false, ""
+		return cookieViewer{LoggedIn: true, Subject: idToken.Subject}
 	}
 	adminGroup := os.Getenv("ADMIN_GROUP")
 	isAdm := false
@@ -317,5 +366,5 @@ func getUserInfoFromCookie(r *http.Request) (loggedIn bool, isAdmin bool, name s
 	if display == "" {
 		display = claims.Email
 	}
-	return true, isAdm, display
+	return cookieViewer{LoggedIn: true, IsAdmin: isAdm, Name: display, Subject: idToken.Subject}
 }
@@ -72,35 +72,11 @@ func HandleInfoPage(w http.ResponseWriter, r *http.Request) {
 		for _, si := range conf.ServiceIPScheme {
 			nat := ""
 			if teamID > 0 {
-				// prefer NatTemplate if provided
-				if si.NatTemplate != "" {
-					// render template with sprig funcs and a `team` helper function
-					funcs := sprig.TxtFuncMap()
-					// add aliases for multiplication helper names (sprig uses "mul")
-					if m, ok := funcs["mul"]; ok {
-						funcs["multiply"] = m
-						funcs["times"] = m
-						funcs["mult"] = m
-					}
-					// inject team function so templates can reference `team` as a bare identifier
-					funcs["team"] = func() int { return teamID }
-					funcs["Team"] = func() int { return teamID }
-					t, terr := template.New("nat").Funcs(funcs).Parse(si.NatTemplate)
-					if terr == nil {
-						var tb bytes.Buffer
-						if err := t.Execute(&tb, nil); err == nil {
-							nat = template.HTMLEscapeString(tb.String())
-						} else {
-							log.Println("nat template execute error:", err)
-						}
-					} else {
-						log.Println("nat template parse error:", terr)
-					}
-				} else if si.NatPrefix != "" {
-					// calculate nat: prefix + '.' + (nat_base + team) + '.' + suffix
-					octet := si.NatBase + teamID
-					nat = template.HTMLEscapeString(si.NatPrefix + "." + strconv.Itoa(octet) + "." + strconv.Itoa(si.NatSuffix))
+				addr, err := cfg.NatAddress(si, teamID)
+				if err != nil {
+					log.Println("nat template error:", err)
 				}
+				nat = template.HTMLEscapeString(addr)
 			}
 			svcIP = append(svcIP, map[string]string{"service": si.Service, "internal": template.HTMLEscapeString(si.InternalIP), "nat": nat})
 		}
@@ -0,0 +1,193 @@
+package webpages
+
+import (
+	"crypto/subtle"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"BlueDevil-Engine/metrics"
+	"BlueDevil-Engine/scoring"
+	sql_wrapper "BlueDevil-Engine/sql"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Competition gauges read from the database on each scrape. Results from the
+// engine and from remote agents all land there, so one scrape of the web
+// server covers every check.
+var (
+	metricRunning = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "bluedevil_competition_running",
+		Help: "1 while the competition is running.",
+	})
+	metricCurrentRound = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "bluedevil_current_round",
+		Help: "The round in progress, 0 when the competition is not running.",
+	})
+	metricCompletedRound = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "bluedevil_last_completed_round",
+		Help: "The most recent round the engine finished.",
+	})
+	metricLeaderAlive = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "bluedevil_engine_leader_alive",
+		Help: "1 while a scoring engine holds an unexpired leader lease.",
+	})
+	metricServiceUp = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "bluedevil_service_up",
+		Help: "1 when the latest check of a team's service passed, 0 when it failed.",
+	}, []string{"team_id", "service"})
+	metricRoundChecks = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "bluedevil_round_checks",
+		Help: "Checks run in the last completed round, by result.",
+	}, []string{"result"})
+	metricRoundErrors = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "bluedevil_round_errors",
+		Help: "Batches of checks the engine failed to record in the last completed round.",
+	})
+	metricCheckLatency = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "bluedevil_check_duration_recent_seconds",
+		Help: "Check durations over the recent rounds the admin dashboard covers.",
+	}, []string{"quantile"})
+	metricTeamPoints = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "bluedevil_team_points",
+		Help: "Each team's total points, including injects.",
+	}, []string{"team_id"})
+	metricTeamInjectPoints = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "bluedevil_team_inject_points",
+		Help: "Each team's points from graded injects.",
+	}, []string{"team_id"})
+	metricViewers = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "bluedevil_event_viewers",
+		Help: "Viewers connected to the live scoreboard stream.",
+	})
+)
+
+// metricsMu keeps scrapes with and without the token from mixing their team
+// points.
+var metricsMu sync.Mutex
+
+// refreshCompetitionMetrics reloads the competition gauges. Teams are labelled
+// by ID, which gives nothing away when they are anonymized. Team points and
+// statuses honor the final freeze unless live is set.
+func refreshCompetitionMetrics(live bool) error {
+	comp, err := sql_wrapper.GetCompetition()
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	round := scoring.RoundAt(comp, now)
+	metricCurrentRound.Set(float64(round))
+	metricRunning.Set(boolGauge(round > 0))
+
+	completed, err := sql_wrapper.GetLastCompletedRound()
+	if err != nil {
+		return err
+	}
+	metricCompletedRound.Set(float64(completed))
+
+	st, err := sql_wrapper.GetEngineStatus()
+	if err != nil {
+		return err
+	}
+	expires, err := time.Parse(time.RFC3339, st.LeaseExpires)
+	metricLeaderAlive.Set(boolGauge(err == nil && st.Leader != "" && now.Before(expires)))
+
+	health, err := engineHealth(now)
+	if err != nil {
+		return err
+	}
+	metricRoundChecks.Reset()
+	metricRoundErrors.Set(0)
+	if rd := health.LastCompleted; rd != nil {
+		metricRoundChecks.WithLabelValues("up").Set(float64(rd.Up))
+		metricRoundChecks.WithLabelValues("down").Set(float64(rd.Down))
+		metricRoundChecks.WithLabelValues("timed_out").Set(float64(rd.TimedOut))
+		metricRoundErrors.Set(float64(rd.Errors))
+	}
+	metricCheckLatency.Reset()
+	if health.Latency.Count > 0 {
+		metricCheckLatency.WithLabelValues("0.5").Set(float64(health.Latency.P50) / 1000)
+		metricCheckLatency.WithLabelValues("0.9").Set(float64(health.Latency.P90) / 1000)
+		metricCheckLatency.WithLabelValues("0.99").Set(float64(health.Latency.P99) / 1000)
+		metricCheckLatency.WithLabelValues("1").Set(float64(health.Latency.Max) / 1000)
+	}
+
+	services, err := sql_wrapper.GetAllServices()
+	if err != nil {
+		return err
+	}
+	frozenBefore, frozen, err := freezeState(comp)
+	if err != nil {
+		return err
+	}
+	if !frozen || live {
+		frozenBefore = 0
+	}
+	latest, err := sql_wrapper.GetLatestStatuses(frozenBefore)
+	if err != nil {
+		return err
+	}
+	serviceNames := make(map[int]string)
+	for _, s := range services {
+		serviceNames[s.ID] = s.Name
+	}
+	metricServiceUp.Reset()
+	for _, ls := range latest {
+		svc, ok := serviceNames[ls.ServiceID]
+		if !ok {
+			continue
+		}
+		metricServiceUp.WithLabelValues(strconv.Itoa(ls.TeamID), svc).Set(boolGauge(ls.IsUp))
+	}
+
+	standings, err := sql_wrapper.GetTeamStandings(frozenBefore)
+	if err != nil {
+		return err
+	}
+	metricTeamPoints.Reset()
+	metricTeamInjectPoints.Reset()
+	for _, ts := range standings {
+		id := strconv.Itoa(ts.TeamID)
+		metricTeamPoints.WithLabelValues(id).Set(float64(ts.Points))
+		metricTeamInjectPoints.WithLabelValues(id).Set(float64(ts.InjectPoints))
+	}
+
+	viewers, _ := events.count()
+	metricViewers.Set(float64(viewers))
+	return nil
+}
+
+func boolGauge(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// HandleMetrics serves /metrics in the Prometheus text format. When
+// METRICS_TOKEN is set scrapes must send it as a bearer token and see live
+// team points; otherwise the endpoint is open and honors the final freeze
+// like the public scoreboard.
+func HandleMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	token := os.Getenv("METRICS_TOKEN")
+	if token != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte("Bearer "+token)) != 1 {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+	if err := refreshCompetitionMetrics(token != ""); err != nil {
+		// the HTTP and query metrics are still worth serving
+		log.Println("metrics: refreshing competition metrics:", err)
+	}
+	metrics.Handler().ServeHTTP(w, r)
+}
@@ -0,0 +1,77 @@
+package webpages
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	sql_wrapper "BlueDevil-Engine/sql"
+	structures "BlueDevil-Engine/structures"
+)
+
+func TestHandleMetrics(t *testing.T) {
+	openTestDB(t)
+	team := &structures.Team{Name: "Red Herrings"}
+	if err := sql_wrapper.CreateTeam(team); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sql_wrapper.AddCompetitionScoreAdjustment(team.ID, 150, 0, sql_wrapper.ScoreAdjustment, "test"); err != nil {
+		t.Fatal(err)
+	}
+
+	scrape := func(method, auth string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, "/metrics", nil)
+		if auth != "" {
+			r.Header.Set("Authorization", auth)
+		}
+		rec := httptest.NewRecorder()
+		HandleMetrics(rec, r)
+		return rec
+	}
+
+	rec := scrape(http.MethodGet, "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("/metrics returned %d", rec.Code)
+	}
+	body := rec.Body.String()
+	for _, line := range []string{
+		"# TYPE bluedevil_team_points gauge",
+		`bluedevil_team_points{team_id="1"} 150`,
+		`bluedevil_team_inject_points{team_id="1"} 0`,
+		"bluedevil_competition_running 0",
+		"bluedevil_event_viewers 0",
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("/metrics is missing %q", line)
+		}
+	}
+	if !strings.Contains(body, `bluedevil_db_query_duration_seconds_count{query="GetTeamStandings"}`) {
+		t.Errorf("/metrics is missing query timings labelled by function")
+	}
+	if strings.Contains(body, team.Name) {
+		t.Errorf("/metrics shows the team name")
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		token  string
+		auth   string
+		want   int
+	}{
+		{"open", http.MethodGet, "", "", http.StatusOK},
+		{"wrong method", http.MethodPost, "", "", http.StatusMethodNotAllowed},
+		{"token missing", http.MethodGet, "s3cret", "", http.StatusUnauthorized},
+		{"token wrong", http.MethodGet, "s3cret", "Bearer guess", http.StatusUnauthorized},
+		{"token given", http.MethodGet, "s3cret", "Bearer s3cret", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("METRICS_TOKEN", tt.token)
+			if got := scrape(tt.method, tt.auth).Code; got != tt.want {
+				t.Errorf("/metrics returned %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
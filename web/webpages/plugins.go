@@ -0,0 +1,67 @@
+package webpages
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	sql_wrapper "BlueDevil-Engine/sql"
+	structures "BlueDevil-Engine/structures"
+)
+
+// Admin API: list, register/update and delete external check plugins
+func HandleApiPlugins(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		plugins, err := sql_wrapper.GetAllPlugins()
+		if err != nil {
+			http.Error(w, "Failed to get plugins: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if plugins == nil {
+			plugins = []structures.CheckPlugin{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(plugins)
+	case http.MethodPost:
+		var p structures.CheckPlugin
+		if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
+			http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		p.Name = strings.TrimSpace(p.Name)
+		p.Path = strings.TrimSpace(p.Path)
+		if p.Name == "" || p.Path == "" {
+			http.Error(w, "name and path required", http.StatusBadRequest)
+			return
+		}
+		if err := sql_wrapper.SavePlugin(&p); errors.Is(err, sql_wrapper.ErrPluginInUse) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		} else if err != nil {
+			http.Error(w, "Failed to save plugin: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(p)
+	case http.MethodDelete:
+		var req struct {
+			ID int `json:"id"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := sql_wrapper.DeletePlugin(req.ID); errors.Is(err, sql_wrapper.ErrPluginInUse) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		} else if err != nil {
+			http.Error(w, "Failed to delete plugin: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
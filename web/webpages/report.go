@@ -0,0 +1,448 @@
+package webpages
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/phpdave11/gofpdf"
+
+	sql_wrapper "BlueDevil-Engine/sql"
+)
+
+// Report is the final competition report: the live standings, each team's
+// uptime per service, every inject grade and every adjustment made.
+type Report struct {
+	GeneratedAt     string             `json:"generated_at"`
+	StartedTime     string             `json:"started_time,omitempty"`
+	StoppedTime     string             `json:"stopped_time,omitempty"`
+	Rounds          int                `json:"rounds"`
+	InjectScoreRule string             `json:"inject_score_rule"`
+	Standings       []ReportStanding   `json:"standings"`
+	Uptime          []ReportUptime     `json:"uptime"`
+	Injects         []ReportInject     `json:"injects"`
+	Adjustments     []ReportAdjustment `json:"adjustments"`
+}
+
+type ReportStanding struct {
+	Rank          int    `json:"rank"`
+	TeamID        int    `json:"team_id"`
+	Team          string `json:"team"`
+	ServicePoints int    `json:"service_points"`
+	InjectPoints  int    `json:"inject_points"`
+	Points        int    `json:"points"`
+}
+
+type ReportUptime struct {
+	TeamID    int     `json:"team_id"`
+	Team      string  `json:"team"`
+	ServiceID int     `json:"service_id"`
+	Service   string  `json:"service"`
+	Percent   float64 `json:"uptime_percent"`
+}
+
+// ReportInject is a graded submission. Counted is set for the submission
+// that counts toward the standings under the inject rule.
+type ReportInject struct {
+	TeamID       int    `json:"team_id"`
+	Team         string `json:"team"`
+	InjectID     string `json:"inject_id"`
+	Title        string `json:"title"`
+	SubmissionID int    `json:"submission_id"`
+	Filename     string `json:"filename"`
+	SubmittedAt  string `json:"submitted_at"`
+	Score        int    `json:"score"`
+	Reviewer     string `json:"reviewer"`
+	Notes        string `json:"notes"`
+	ScoredAt     string `json:"scored_at,omitempty"`
+	Counted      bool   `json:"counted"`
+}
+
+// ReportAdjustment is a competition_scores row that is not a check's own
+// points: rescores, overrides, SLA penalties, red team deductions and manual
+// adjustments.
+type ReportAdjustment struct {
+	TeamID      int    `json:"team_id"`
+	Team        string `json:"team"`
+	Round       int    `json:"round,omitempty"`
+	Category    string `json:"category"`
+	Service     string `json:"service,omitempty"`
+	Points      int    `json:"points"`
+	Description string `json:"description"`
+	Timestamp   string `json:"timestamp"`
+}
+
+// buildReport gathers the final report. It ignores the final freeze.
+func buildReport() (*Report, error) {
+	comp, err := sql_wrapper.GetCompetition()
+	if err != nil {
+		return nil, err
+	}
+	teams, err := sql_wrapper.GetAllTeams()
+	if err != nil {
+		return nil, err
+	}
+	services, err := sql_wrapper.GetAllServices()
+	if err != nil {
+		return nil, err
+	}
+	standings, err := sql_wrapper.GetTeamStandings(0)
+	if err != nil {
+		return nil, err
+	}
+	uptime, err := sql_wrapper.GetTeamServiceUptimePercents(0)
+	if err != nil {
+		return nil, err
+	}
+	injects, err := sql_wrapper.GetAllInjects()
+	if err != nil {
+		return nil, err
+	}
+	subs, err := sql_wrapper.GetAllInjectSubmissions()
+	if err != nil {
+		return nil, err
+	}
+	counted, err := sql_wrapper.GetCountedInjectSubmissions(comp.InjectScoreRule, 0)
+	if err != nil {
+		return nil, err
+	}
+	recent, err := sql_wrapper.GetRounds(1)
+	if err != nil {
+		return nil, err
+	}
+
+	rule := comp.InjectScoreRule
+	if rule == "" {
+		rule = sql_wrapper.InjectRuleLatest
+	}
+	rep := &Report{
+		GeneratedAt:     time.Now().UTC().Format(time.RFC3339),
+		StartedTime:     comp.StartedTime,
+		StoppedTime:     comp.StoppedTime,
+		InjectScoreRule: rule,
+		Standings:       []ReportStanding{},
+		Uptime:          []ReportUptime{},
+		Injects:         []ReportInject{},
+		Adjustments:     []ReportAdjustment{},
+	}
+	if len(recent) > 0 {
+		rep.Rounds = recent[0].Number
+	}
+
+	teamNames := make(map[int]string)
+	for _, t := range teams {
+		teamNames[t.ID] = t.Name
+	}
+	serviceNames := make(map[int]string)
+	for _, s := range services {
+		serviceNames[s.ID] = s.Name
+	}
+
+	for i, st := range standings {
+		rep.Standings = append(rep.Standings, ReportStanding{
+			Rank:          i + 1,
+			TeamID:        st.TeamID,
+			Team:          st.Name,
+			ServicePoints: st.Points - st.InjectPoints,
+			InjectPoints:  st.InjectPoints,
+			Points:        st.Points,
+		})
+	}
+
+	for _, t := range teams {
+		for _, s := range services {
+			pct, ok := uptime[t.ID][s.ID]
+			if !ok {
+				continue
+			}
+			rep.Uptime = append(rep.Uptime, ReportUptime{TeamID: t.ID, Team: t.Name, ServiceID: s.ID, Service: s.Name, Percent: pct})
+		}
+	}
+
+	titles := make(map[string]string)
+	for _, inj := range injects {
+		titles[inj.InjectID] = inj.Title
+	}
+	countedIDs := make(map[int]bool)
+	for _, s := range counted {
+		countedIDs[s.ID] = true
+	}
+	for _, s := range subs {
+		if !s.Scored || s.Score == nil {
+			continue
+		}
+		rep.Injects = append(rep.Injects, ReportInject{
+			TeamID:       s.TeamID,
+			Team:         teamNames[s.TeamID],
+			InjectID:     s.InjectID,
+			Title:        titles[s.InjectID],
+			SubmissionID: s.ID,
+			Filename:     s.Filename,
+			SubmittedAt:  s.SubmittedAt,
+			Score:        *s.Score,
+			Reviewer:     s.Reviewer,
+			Notes:        s.Notes,
+			ScoredAt:     s.ScoredAt,
+			Counted:      countedIDs[s.ID],
+		})
+	}
+
+	for _, t := range teams {
+		entries, err := sql_wrapper.GetTeamScoreEntries(t.ID, 0)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			if e.Category == sql_wrapper.ScoreService {
+				continue
+			}
+			rep.Adjustments = append(rep.Adjustments, ReportAdjustment{
+				TeamID:      t.ID,
+				Team:        t.Name,
+				Round:       e.Round,
+				Category:    e.Category,
+				Service:     serviceNames[e.ServiceID],
+				Points:      e.Score,
+				Description: e.Description,
+				Timestamp:   e.Timestamp,
+			})
+		}
+	}
+	return rep, nil
+}
+
+// writeReportCSV writes the report as one CSV file with a section per table.
+// Each section starts with its name on a line of its own, then a header row,
+// and is followed by a blank line.
+func writeReportCSV(w io.Writer, rep *Report) error {
+	cw := csv.NewWriter(w)
+	itoa := strconv.Itoa
+	section := func(name string, header []string, rows [][]string) {
+		cw.Write([]string{name})
+		cw.Write(header)
+		for _, row := range rows {
+			cw.Write(row)
+		}
+		cw.Write(nil)
+	}
+
+	var rows [][]string
+	for _, s := range rep.Standings {
+		rows = append(rows, []string{itoa(s.Rank), itoa(s.TeamID), s.Team, itoa(s.ServicePoints), itoa(s.InjectPoints), itoa(s.Points)})
+	}
+	section("Standings", []string{"rank", "team_id", "team", "service_points", "inject_points", "points"}, rows)
+
+	rows = nil
+	for _, u := range rep.Uptime {
+		rows = append(rows, []string{itoa(u.TeamID), u.Team, itoa(u.ServiceID), u.Service, strconv.FormatFloat(u.Percent, 'f', 1, 64)})
+	}
+	section("Uptime", []string{"team_id", "team", "service_id", "service", "uptime_percent"}, rows)
+
+	rows = nil
+	for _, g := range rep.Injects {
+		rows = append(rows, []string{itoa(g.TeamID), g.Team, g.InjectID, g.Title, itoa(g.SubmissionID), g.Filename, g.SubmittedAt,
+			itoa(g.Score), g.Reviewer, g.Notes, g.ScoredAt, strconv.FormatBool(g.Counted)})
+	}
+	section("Inject grades", []string{"team_id", "team", "inject_id", "title", "submission_id", "filename", "submitted_at",
+		"score", "reviewer", "notes", "scored_at", "counted"}, rows)
+
+	rows = nil
+	for _, a := range rep.Adjustments {
+		round := ""
+		if a.Round > 0 {
+			round = itoa(a.Round)
+		}
+		rows = append(rows, []string{itoa(a.TeamID), a.Team, round, a.Category, a.Service, itoa(a.Points), a.Description, a.Timestamp})
+	}
+	section("Adjustments", []string{"team_id", "team", "round", "category", "service", "points", "description", "timestamp"}, rows)
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// writeReportPDF lays the report out as a title block and a table per
+// section.
+func writeReportPDF(w io.Writer, rep *Report) error {
+	pdf := gofpdf.New("P", "mm", "A4", "")
+	tr := pdf.UnicodeTranslatorFromDescriptor("")
+	text := func(s string) string { return tr(sanitizeText(s)) }
+	pdf.SetTitle("Competition Report", false)
+	pdf.SetAuthor("CCDC", false)
+	pdf.SetMargins(12, 12, 12)
+	pdf.SetAutoPageBreak(true, 16)
+	pdf.SetFooterFunc(func() {
+		pdf.SetY(-12)
+		pdf.SetFont("Helvetica", "", 9)
+		pdf.CellFormat(0, 8, fmt.Sprintf("Page %d", pdf.PageNo()), "", 0, "C", false, 0, "")
+	})
+	pdf.AddPage()
+	pageW, pageH := pdf.GetPageSize()
+	left, _, right, _ := pdf.GetMargins()
+	bodyW := pageW - left - right
+
+	pdf.SetFont("Helvetica", "B", 24)
+	pdf.CellFormat(0, 12, "Competition Report", "", 1, "L", false, 0, "")
+	pdf.SetFont("Helvetica", "", 10)
+	summary := "Generated " + rep.GeneratedAt
+	if rep.StartedTime != "" {
+		summary += " | Started " + rep.StartedTime
+	}
+	if rep.StoppedTime != "" {
+		summary += " | Stopped " + rep.StoppedTime
+	}
+	pdf.CellFormat(0, 6, text(summary), "", 1, "L", false, 0, "")
+	pdf.CellFormat(0, 6, fmt.Sprintf("%d rounds | the %s graded submission for each inject counts", rep.Rounds, rep.InjectScoreRule), "", 1, "L", false, 0, "")
+
+	const rowH = 6.0
+	// fit shortens s to the width of a cell
+	fit := func(s string, width float64) string {
+		s = text(s)
+		if pdf.GetStringWidth(s) <= width-2 {
+			return s
+		}
+		for len(s) > 0 && pdf.GetStringWidth(s+"...") > width-2 {
+			s = s[:len(s)-1]
+		}
+		return s + "..."
+	}
+	// table draws a table with widths relative to the page; the header is
+	// repeated after page breaks. after, if set, draws below each row.
+	table := func(title string, header []string, widths []float64, rows [][]string, empty string, after func(i int)) {
+		pdf.Ln(6)
+		pdf.SetFont("Helvetica", "B", 14)
+		pdf.CellFormat(0, 9, title, "", 1, "L", false, 0, "")
+		total := 0.0
+		for _, wd := range widths {
+			total += wd
+		}
+		drawHeader := func() {
+			pdf.SetFont("Helvetica", "B", 9)
+			pdf.SetFillColor(230, 234, 238)
+			for i, h := range header {
+				pdf.CellFormat(widths[i]*bodyW/total, rowH, h, "1", 0, "L", true, 0, "")
+			}
+			pdf.Ln(-1)
+			pdf.SetFont("Helvetica", "", 9)
+		}
+		if len(rows) == 0 {
+			pdf.SetFont("Helvetica", "I", 10)
+			pdf.CellFormat(0, rowH, empty, "", 1, "L", false, 0, "")
+			return
+		}
+		drawHeader()
+		for i, row := range rows {
+			if pdf.GetY()+rowH*2 > pageH-16 {
+				pdf.AddPage()
+				drawHeader()
+			}
+			for j, cell := range row {
+				cw := widths[j] * bodyW / total
+				pdf.CellFormat(cw, rowH, fit(cell, cw), "1", 0, "L", false, 0, "")
+			}
+			pdf.Ln(-1)
+			if after != nil {
+				after(i)
+			}
+		}
+	}
+
+	itoa := strconv.Itoa
+	var rows [][]string
+	for _, s := range rep.Standings {
+		rows = append(rows, []string{itoa(s.Rank), s.Team, itoa(s.ServicePoints), itoa(s.InjectPoints), itoa(s.Points)})
+	}
+	table("Final Standings", []string{"Rank", "Team", "Service points", "Inject points", "Total"}, []float64{1, 4, 2, 2, 2}, rows, "No teams", nil)
+
+	rows = nil
+	for _, u := range rep.Uptime {
+		rows = append(rows, []string{u.Team, u.Service, fmt.Sprintf("%.1f%%", u.Percent)})
+	}
+	table("Service Uptime", []string{"Team", "Service", "Uptime"}, []float64{4, 4, 2}, rows, "No checks recorded", nil)
+
+	rows = nil
+	for _, g := range rep.Injects {
+		counted := ""
+		if g.Counted {
+			counted = "yes"
+		}
+		rows = append(rows, []string{g.Team, g.InjectID + " " + g.Title, g.SubmittedAt, itoa(g.Score), g.Reviewer, counted})
+	}
+	table("Inject Grades", []string{"Team", "Inject", "Submitted", "Score", "Reviewer", "Counted"}, []float64{3, 5, 3.5, 1.3, 2.5, 1.5}, rows, "No graded injects",
+		func(i int) {
+			if rep.Injects[i].Notes == "" {
+				return
+			}
+			pdf.SetFont("Helvetica", "I", 9)
+			pdf.MultiCell(bodyW, 5, text("Notes: "+rep.Injects[i].Notes), "LRB", "L", false)
+			pdf.SetFont("Helvetica", "", 9)
+		})
+
+	rows = nil
+	for _, a := range rep.Adjustments {
+		round := "-"
+		if a.Round > 0 {
+			round = itoa(a.Round)
+		}
+		label := scoreCategoryLabels[a.Category]
+		if label == "" {
+			label = a.Category
+		}
+		rows = append(rows, []string{a.Team, round, label, a.Service, itoa(a.Points), a.Description})
+	}
+	table("Adjustment Log", []string{"Team", "Round", "Kind", "Service", "Points", "Reason"}, []float64{3, 1.3, 3, 2.5, 1.3, 6}, rows, "No adjustments", nil)
+
+	return pdf.Output(w)
+}
+
+// Admin API: the final competition report as JSON, CSV or PDF
+// (?format=json|csv|pdf), served as a download
+func HandleApiReport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "json"
+	}
+	var contentType string
+	var write func(io.Writer, *Report) error
+	switch format {
+	case "json":
+		contentType = "application/json"
+		write = func(w io.Writer, rep *Report) error {
+			enc := json.NewEncoder(w)
+			enc.SetIndent("", "  ")
+			return enc.Encode(rep)
+		}
+	case "csv":
+		contentType = "text/csv; charset=utf-8"
+		write = writeReportCSV
+	case "pdf":
+		contentType = "application/pdf"
+		write = writeReportPDF
+	default:
+		http.Error(w, "Invalid format", http.StatusBadRequest)
+		return
+	}
+
+	rep, err := buildReport()
+	if err != nil {
+		http.Error(w, "Failed to build report: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	var buf bytes.Buffer
+	if err := write(&buf, rep); err != nil {
+		http.Error(w, "Failed to write report: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	filename := "competition-report-" + time.Now().UTC().Format("20060102-1504") + "." + format
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+	_, _ = w.Write(buf.Bytes())
+}
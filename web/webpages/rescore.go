@@ -0,0 +1,203 @@
+package webpages
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"BlueDevil-Engine/scoring"
+	sql_wrapper "BlueDevil-Engine/sql"
+	structures "BlueDevil-Engine/structures"
+)
+
+// rescorePlan is the outcome of re-assessing a range of rounds.
+type rescorePlan struct {
+	FromRound int `json:"from_round"`
+	ToRound   int `json:"to_round"`
+	// Checked counts results re-assessed from their evidence, Skipped those
+	// without evidence for every current check, which keep their points.
+	// Overridden results are left as they are and not counted.
+	Checked   int                        `json:"checked"`
+	Skipped   int                        `json:"skipped"`
+	Changes   []structures.RescoreChange `json:"changes"`
+	Teams     []rescoreTeam              `json:"teams"`
+	Committed bool                       `json:"committed"`
+}
+
+// rescoreTeam is the net change in a team's points.
+type rescoreTeam struct {
+	TeamID   int    `json:"team_id"`
+	TeamName string `json:"team_name"`
+	Delta    int    `json:"delta"`
+}
+
+// planRescore re-assesses every result recorded for rounds from through to
+// under the current checks, dependencies and scoring policy, and returns the
+// results whose outcome or points change.
+func planRescore(from, to int) (*rescorePlan, error) {
+	comp, err := sql_wrapper.GetCompetition()
+	if err != nil {
+		return nil, err
+	}
+	services, err := sql_wrapper.GetAllServices()
+	if err != nil {
+		return nil, err
+	}
+	teams, err := sql_wrapper.GetAllTeams()
+	if err != nil {
+		return nil, err
+	}
+	recorded, err := sql_wrapper.GetRecordedResults(from, to)
+	if err != nil {
+		return nil, err
+	}
+	svcByID := make(map[int]structures.Service)
+	for _, s := range services {
+		svcByID[s.ID] = s
+	}
+	teamNames := make(map[int]string)
+	for _, t := range teams {
+		teamNames[t.ID] = t.Name
+	}
+
+	plan := &rescorePlan{FromRound: from, ToRound: to, Changes: []structures.RescoreChange{}, Teams: []rescoreTeam{}}
+	// results are re-assessed round by round so dependencies see the
+	// corrected status of the services they depend on
+	byRound := make(map[int][]structures.RecordedResult)
+	var rounds []int
+	for _, r := range recorded {
+		if _, ok := byRound[r.Round]; !ok {
+			rounds = append(rounds, r.Round)
+		}
+		byRound[r.Round] = append(byRound[r.Round], r)
+	}
+	deltas := make(map[int]int)
+	// results from the rounds before, as re-assessed, for dependencies checked
+	// less often than once a round
+	lookback := scoring.DependencyLookback(comp, services)
+	history, err := sql_wrapper.GetRoundRangeResults(from-lookback, from-1)
+	if err != nil {
+		return nil, err
+	}
+	for _, round := range rounds {
+		var fresh, kept []structures.CheckResult
+		var sources []structures.RecordedResult
+		for _, r := range byRound[round] {
+			// manual overrides stand
+			if r.Overridden {
+				kept = append(kept, r.CheckResult)
+				continue
+			}
+			res, ok := scoring.Reassess(r.CheckResult, svcByID[r.ServiceID])
+			if !ok {
+				kept = append(kept, r.CheckResult)
+				plan.Skipped++
+				continue
+			}
+			fresh = append(fresh, res)
+			sources = append(sources, r)
+		}
+		plan.Checked += len(fresh)
+		var context []structures.CheckResult
+		for _, h := range history {
+			if h.Round >= round-lookback {
+				context = append(context, h)
+			}
+		}
+		context = append(context, kept...)
+		scoring.ApplyDependencies(fresh, context, services, comp)
+		history = append(context, fresh...)
+		for i, res := range fresh {
+			old := sources[i]
+			points := scoring.PointsForResult(res, svcByID[res.ServiceID], comp)
+			if points == old.Points && res.IsUp == old.IsUp && res.RootCause == old.RootCause {
+				continue
+			}
+			plan.Changes = append(plan.Changes, structures.RescoreChange{
+				ResultID:    old.ID,
+				TeamID:      res.TeamID,
+				TeamName:    teamNames[res.TeamID],
+				ServiceID:   res.ServiceID,
+				ServiceName: svcByID[res.ServiceID].Name,
+				Round:       round,
+				WasUp:       old.IsUp,
+				IsUp:        res.IsUp,
+				OldPoints:   old.Points,
+				NewPoints:   points,
+				RootCause:   res.RootCause,
+				Output:      scoring.CapOutput(res.Output),
+			})
+			deltas[res.TeamID] += points - old.Points
+		}
+	}
+	for id, d := range deltas {
+		plan.Teams = append(plan.Teams, rescoreTeam{TeamID: id, TeamName: teamNames[id], Delta: d})
+	}
+	sort.Slice(plan.Teams, func(i, j int) bool { return plan.Teams[i].TeamID < plan.Teams[j].TeamID })
+	return plan, nil
+}
+
+// Admin API: re-assess a range of rounds from stored check evidence. Without
+// commit the changes are only previewed; with commit they are written along
+// with score corrections that carry the reason.
+func HandleApiRescore(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var req struct {
+		FromRound int    `json:"from_round"`
+		ToRound   int    `json:"to_round"`
+		Reason    string `json:"reason"`
+		Commit    bool   `json:"commit"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.ToRound == 0 {
+		req.ToRound = req.FromRound
+	}
+	if req.FromRound < 1 || req.ToRound < req.FromRound {
+		http.Error(w, "Invalid round range", http.StatusBadRequest)
+		return
+	}
+	req.Reason = strings.TrimSpace(req.Reason)
+	if req.Commit && req.Reason == "" {
+		http.Error(w, "A reason is required to commit a rescore", http.StatusBadRequest)
+		return
+	}
+	comp, err := sql_wrapper.GetCompetition()
+	if err != nil {
+		http.Error(w, "Failed to get competition: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// the engine may still be recording the current round
+	if current := scoring.RoundAt(comp, time.Now()); current > 0 && req.ToRound >= current {
+		http.Error(w, fmt.Sprintf("Round %d is still being scored", current), http.StatusBadRequest)
+		return
+	}
+
+	plan, err := planRescore(req.FromRound, req.ToRound)
+	if err != nil {
+		http.Error(w, "Failed to rescore: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if req.Commit && len(plan.Changes) > 0 {
+		if err := sql_wrapper.ApplyRescore(plan.Changes, req.Reason); err != nil {
+			http.Error(w, "Failed to apply rescore: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		plan.Committed = true
+		rounds := make([]int, 0, len(plan.Changes))
+		for _, c := range plan.Changes {
+			rounds = append(rounds, c.Round)
+		}
+		go publishScoreUpdate(EventAdjustment, rounds...)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(plan)
+}
@@ -0,0 +1,52 @@
+package webpages
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	sql_wrapper "BlueDevil-Engine/sql"
+	structures "BlueDevil-Engine/structures"
+)
+
+// Admin API: the engine's round records, newest first (?limit=N). POST
+// {"number": N} marks a failed or incomplete round complete once an admin
+// has checked the results it did record, so they count in the standings.
+func HandleApiRounds(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+		rounds, err := sql_wrapper.GetRounds(limit)
+		if err != nil {
+			http.Error(w, "Failed to get rounds: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if rounds == nil {
+			rounds = []structures.Round{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(rounds)
+	case http.MethodPost:
+		var req struct {
+			Number int `json:"number"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		settled, err := sql_wrapper.SettleRound(req.Number)
+		if err != nil {
+			http.Error(w, "Failed to settle round: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !settled {
+			http.Error(w, fmt.Sprintf("Round %d is not failed or incomplete", req.Number), http.StatusConflict)
+			return
+		}
+		go publishScoreUpdate(EventAdjustment, req.Number)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
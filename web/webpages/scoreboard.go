@@ -0,0 +1,279 @@
+package webpages
+
+import (
+	"bytes"
+	"encoding/json"
+	"html/template"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"BlueDevil-Engine/scoring"
+	dbsql "BlueDevil-Engine/sql"
+	structures "BlueDevil-Engine/structures"
+)
+
+// Scoreboard is the public scoreboard served as HTML at /scoreboard and as
+// JSON at /api/scoreboard.
+type Scoreboard struct {
+	// Phase is the competition phase in progress ("" when not running)
+	Phase string `json:"phase"`
+	// Frozen is set during the final freeze, when standings and round points
+	// only cover rounds before FrozenBefore
+	Frozen       bool `json:"frozen"`
+	FrozenBefore int  `json:"frozen_before,omitempty"`
+	// Live is set for admins, who see every point while the public
+	// scoreboard is frozen
+	Live        bool                 `json:"live,omitempty"`
+	GeneratedAt string               `json:"generated_at"`
+	Services    []ScoreboardService  `json:"services"`
+	Standings   []ScoreboardStanding `json:"standings"`
+	Status      []ScoreboardTeam     `json:"status"`
+	Rounds      []ScoreboardRound    `json:"rounds"`
+}
+
+type ScoreboardService struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+type ScoreboardStanding struct {
+	Rank   int    `json:"rank"`
+	TeamID int    `json:"team_id"`
+	Name   string `json:"name"`
+	// Points is the total, including InjectPoints
+	Points       int `json:"points"`
+	InjectPoints int `json:"inject_points"`
+}
+
+// ScoreboardTeam is a row of the service status grid. Services without a
+// result yet are left out.
+type ScoreboardTeam struct {
+	TeamID   int                     `json:"team_id"`
+	Name     string                  `json:"name"`
+	Services []ScoreboardServiceStat `json:"services"`
+}
+
+type ScoreboardServiceStat struct {
+	ServiceID int  `json:"service_id"`
+	IsUp      bool `json:"is_up"`
+}
+
+// ScoreboardRound is the points a team earned in one round.
+type ScoreboardRound struct {
+	Round  int `json:"round"`
+	TeamID int `json:"team_id"`
+	Points int `json:"points"`
+}
+
+// freezeState returns the first round hidden by the final freeze (0 for
+// none) and whether the freeze has begun. The freeze holds after the
+// competition stops until admins reveal the results.
+func freezeState(comp *structures.Competition) (int, bool, error) {
+	before := scoring.FreezeRound(comp)
+	if before == 0 || comp.Revealed {
+		return 0, false, nil
+	}
+	recent, err := dbsql.GetRounds(1)
+	if err != nil {
+		return 0, false, err
+	}
+	return before, len(recent) > 0 && recent[0].Number >= before, nil
+}
+
+// buildScoreboard gathers the public scoreboard, honoring the final freeze
+// in the standings, round points and status grid unless live is set.
+func buildScoreboard(live bool) (*Scoreboard, error) {
+	comp, err := dbsql.GetCompetition()
+	if err != nil {
+		return nil, err
+	}
+	services, err := dbsql.GetAllServices()
+	if err != nil {
+		return nil, err
+	}
+	teams, err := dbsql.GetAllTeams()
+	if err != nil {
+		return nil, err
+	}
+	frozenBefore, frozen, err := freezeState(comp)
+	if err != nil {
+		return nil, err
+	}
+	shownBefore := frozenBefore
+	if live {
+		shownBefore = 0
+	}
+	latest, err := dbsql.GetLatestStatuses(shownBefore)
+	if err != nil {
+		return nil, err
+	}
+	standings, err := dbsql.GetTeamStandings(shownBefore)
+	if err != nil {
+		return nil, err
+	}
+	roundScores, err := dbsql.GetTeamScoresByRound(shownBefore)
+	if err != nil {
+		return nil, err
+	}
+
+	sb := &Scoreboard{
+		Phase:       scoring.PhaseAt(comp, time.Now()),
+		Frozen:      frozen,
+		GeneratedAt: time.Now().UTC().Format(time.RFC3339),
+		Services:    []ScoreboardService{},
+		Standings:   []ScoreboardStanding{},
+		Status:      []ScoreboardTeam{},
+		Rounds:      []ScoreboardRound{},
+	}
+	if frozen {
+		sb.FrozenBefore = frozenBefore
+		sb.Live = live
+	}
+	for _, s := range services {
+		sb.Services = append(sb.Services, ScoreboardService{ID: s.ID, Name: s.Name})
+	}
+	sort.Slice(sb.Services, func(i, j int) bool { return sb.Services[i].ID < sb.Services[j].ID })
+
+	// standings come ranked, ties broken by team ID
+	for i, st := range standings {
+		sb.Standings = append(sb.Standings, ScoreboardStanding{Rank: i + 1, TeamID: st.TeamID, Name: st.Name, Points: st.Points, InjectPoints: st.InjectPoints})
+	}
+
+	status := make(map[int]map[int]bool)
+	for _, ls := range latest {
+		if status[ls.TeamID] == nil {
+			status[ls.TeamID] = make(map[int]bool)
+		}
+		status[ls.TeamID][ls.ServiceID] = ls.IsUp
+	}
+	for _, t := range teams {
+		row := ScoreboardTeam{TeamID: t.ID, Name: t.Name, Services: []ScoreboardServiceStat{}}
+		for _, s := range sb.Services {
+			if up, ok := status[t.ID][s.ID]; ok {
+				row.Services = append(row.Services, ScoreboardServiceStat{ServiceID: s.ID, IsUp: up})
+			}
+		}
+		sb.Status = append(sb.Status, row)
+	}
+
+	for _, rs := range roundScores {
+		sb.Rounds = append(sb.Rounds, ScoreboardRound{Round: rs.Round, TeamID: rs.TeamID, Points: rs.Points})
+	}
+	return sb, nil
+}
+
+// scoreboardPage is the view model of templates/scoreboard.html.
+type scoreboardPage struct {
+	*Scoreboard
+	// Grid is the status grid with one cell per service, in Services order
+	Grid []scoreboardGridRow
+	// RoundRows lists rounds newest first with one cell per team, in Status
+	// order
+	RoundRows      []scoreboardRoundRow
+	AutoRefreshSec int
+}
+
+type scoreboardGridRow struct {
+	Name  string
+	Cells []string // "up", "down" or "" when not checked yet
+}
+
+type scoreboardRoundRow struct {
+	Round  int
+	Points []int
+}
+
+func newScoreboardPage(sb *Scoreboard) scoreboardPage {
+	page := scoreboardPage{Scoreboard: sb, AutoRefreshSec: 5}
+	for _, t := range sb.Status {
+		row := scoreboardGridRow{Name: t.Name, Cells: make([]string, len(sb.Services))}
+		for _, st := range t.Services {
+			for i, s := range sb.Services {
+				if s.ID != st.ServiceID {
+					continue
+				}
+				row.Cells[i] = "down"
+				if st.IsUp {
+					row.Cells[i] = "up"
+				}
+			}
+		}
+		page.Grid = append(page.Grid, row)
+	}
+
+	teamCol := make(map[int]int)
+	for i, t := range sb.Status {
+		teamCol[t.TeamID] = i
+	}
+	byRound := make(map[int][]int)
+	for _, rs := range sb.Rounds {
+		if byRound[rs.Round] == nil {
+			byRound[rs.Round] = make([]int, len(sb.Status))
+		}
+		if col, ok := teamCol[rs.TeamID]; ok {
+			byRound[rs.Round][col] = rs.Points
+		}
+	}
+	for round, points := range byRound {
+		page.RoundRows = append(page.RoundRows, scoreboardRoundRow{Round: round, Points: points})
+	}
+	sort.Slice(page.RoundRows, func(i, j int) bool { return page.RoundRows[i].Round > page.RoundRows[j].Round })
+	return page
+}
+
+// HandleScoreboard serves the public scoreboard page. Admins see live scores
+// during the final freeze, and real team names when teams are anonymized.
+func HandleScoreboard(w http.ResponseWriter, r *http.Request) {
+	viewer := getViewerFromCookie(r)
+	sb, err := buildScoreboard(viewer.IsAdmin)
+	if err != nil {
+		http.Error(w, "failed to load scoreboard", http.StatusInternalServerError)
+		log.Println("scoreboard:", err)
+		return
+	}
+	names, err := anonymizedTeamNames(viewer)
+	if err != nil {
+		http.Error(w, "failed to load scoreboard", http.StatusInternalServerError)
+		log.Println("scoreboard:", err)
+		return
+	}
+	sb = names.scoreboard(sb)
+	tmpl, err := template.ParseFiles("templates/scoreboard.html")
+	if err != nil {
+		http.Error(w, "template parse error", http.StatusInternalServerError)
+		log.Println("scoreboard: template parse error:", err)
+		return
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, newScoreboardPage(sb)); err != nil {
+		log.Println("scoreboard: template exec error:", err)
+		http.Error(w, "template exec error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_, _ = w.Write(buf.Bytes())
+}
+
+// Public API: the scoreboard as JSON for external displays and scripts
+func HandleApiScoreboard(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	viewer := getViewerFromCookie(r)
+	sb, err := buildScoreboard(viewer.IsAdmin)
+	if err != nil {
+		http.Error(w, "Failed to get scoreboard: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	names, err := anonymizedTeamNames(viewer)
+	if err != nil {
+		http.Error(w, "Failed to get teams: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	sb = names.scoreboard(sb)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sb)
+}
@@ -0,0 +1,99 @@
+package webpages
+
+// The homepage is served from an in-memory snapshot of its view model rather
+// than queried from the database on every request. The snapshot is rebuilt
+// when a round completes or scores are adjusted, along with the live update
+// pushed to viewers, so a room full of refreshing browsers costs one set of
+// queries per event.
+
+import (
+	"fmt"
+	"html/template"
+	"sync"
+	"time"
+
+	dbsql "BlueDevil-Engine/sql"
+	structures "BlueDevil-Engine/structures"
+)
+
+// homepageMaxAge bounds how stale a snapshot gets between events, so changes
+// that publish none, such as new teams or services, still show up.
+const homepageMaxAge = time.Minute
+
+// homepageSnapshot is everything the homepage shows that does not depend on
+// the viewer.
+type homepageSnapshot struct {
+	public *HomepageViewModel
+	// live is what admins see while the scoreboard is frozen; nil otherwise
+	live *HomepageViewModel
+	comp *structures.Competition
+	tmpl *template.Template
+	// started is when the build began; the snapshot holds everything
+	// recorded before it
+	started time.Time
+}
+
+// homepageCache holds the latest snapshot. Rebuilds run one at a time and
+// callers waiting on one share its result.
+type homepageCache struct {
+	mu    sync.Mutex
+	snap  *homepageSnapshot
+	build sync.Mutex
+}
+
+var homepages = &homepageCache{}
+
+func (c *homepageCache) current() *homepageSnapshot {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.snap
+}
+
+// get returns the snapshot, building it first when there is none or it is
+// older than homepageMaxAge.
+func (c *homepageCache) get() (*homepageSnapshot, error) {
+	if snap := c.current(); snap != nil && time.Since(snap.started) < homepageMaxAge {
+		return snap, nil
+	}
+	return c.rebuild()
+}
+
+// rebuild replaces the snapshot with one reflecting everything recorded up
+// to the call.
+func (c *homepageCache) rebuild() (*homepageSnapshot, error) {
+	requested := time.Now()
+	c.build.Lock()
+	defer c.build.Unlock()
+	// a build started while this call waited covers it
+	if snap := c.current(); snap != nil && !snap.started.Before(requested) {
+		return snap, nil
+	}
+
+	snap := &homepageSnapshot{started: time.Now()}
+	var err error
+	if snap.comp, err = dbsql.GetCompetition(); err != nil {
+		return nil, fmt.Errorf("competition: %w", err)
+	}
+	if snap.public, err = buildHomepage(false); err != nil {
+		return nil, err
+	}
+	if snap.public.Frozen {
+		if snap.live, err = buildHomepage(true); err != nil {
+			return nil, err
+		}
+	}
+	if snap.tmpl, err = template.ParseFiles("templates/homepage.html", "templates/heatmap.svg"); err != nil {
+		return nil, fmt.Errorf("template parse error: %w", err)
+	}
+	c.mu.Lock()
+	c.snap = snap
+	c.mu.Unlock()
+	return snap, nil
+}
+
+// invalidate drops the snapshot so the next request rebuilds it.
+func (c *homepageCache) invalidate() {
+	c.mu.Lock()
+	c.snap = nil
+	c.mu.Unlock()
+}
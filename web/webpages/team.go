@@ -0,0 +1,373 @@
+package webpages
+
+import (
+	"bytes"
+	"html/template"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"BlueDevil-Engine/scoring"
+	sql_wrapper "BlueDevil-Engine/sql"
+	structures "BlueDevil-Engine/structures"
+)
+
+// scoreCategoryLabels names the kinds of competition_scores rows for teams.
+var scoreCategoryLabels = map[string]string{
+	sql_wrapper.ScoreService:    "Service uptime",
+	sql_wrapper.ScoreRescore:    "Rescore",
+	sql_wrapper.ScoreOverride:   "Override",
+	sql_wrapper.ScoreAdjustment: "Manual adjustment",
+	sql_wrapper.ScoreSLA:        "SLA penalty",
+	sql_wrapper.ScoreRedTeam:    "Red team deduction",
+}
+
+// TeamBreakdown is where a team's points came from: the total split by
+// category and by service, the adjustments made, and points per round.
+type TeamBreakdown struct {
+	TeamID   int
+	TeamName string
+	Total    int
+	// Uptime is the points checks earned; Corrections the changes made to
+	// them by rescoring and overrides
+	Uptime      int
+	Corrections int
+	SLA         int
+	RedTeam     int
+	Adjustments int
+	Services    []BreakdownService
+	// Adjusted lists every row that is not a check's own points
+	Adjusted []BreakdownEntry
+	// Injects are the graded submissions counted under the competition's
+	// inject rule; InjectTotal is part of Total
+	Injects     []BreakdownInject
+	InjectTotal int
+	Rounds      []BreakdownRound // newest first
+	// FrozenBefore is set when rounds from it on are hidden by the final
+	// freeze
+	FrozenBefore int
+}
+
+type BreakdownService struct {
+	ServiceID   int
+	Name        string
+	Checks      int
+	Up          int
+	Points      int
+	Corrections int
+}
+
+type BreakdownEntry struct {
+	Round       int
+	Category    string
+	Label       string
+	ServiceName string
+	Points      int
+	Description string
+	Timestamp   string
+}
+
+type BreakdownInject struct {
+	InjectID string
+	Title    string
+	Score    int
+	Notes    string
+}
+
+// BreakdownRound is a team's points in one round. Checks and Entries are
+// only filled in for the round being drilled into.
+type BreakdownRound struct {
+	Round       int
+	Uptime      int
+	Corrections int
+	Other       int
+	Total       int
+	Checks      []BreakdownCheck
+	Entries     []BreakdownEntry
+}
+
+type BreakdownCheck struct {
+	ServiceName string
+	IsUp        bool
+	Points      int
+}
+
+// buildTeamBreakdown gathers a team's points, limited to rounds before
+// beforeRound when it is not 0. drill is the round to give in detail.
+func buildTeamBreakdown(team structures.Team, beforeRound, drill int) (*TeamBreakdown, *BreakdownRound, error) {
+	entries, err := sql_wrapper.GetTeamScoreEntries(team.ID, beforeRound)
+	if err != nil {
+		return nil, nil, err
+	}
+	services, err := sql_wrapper.GetAllServices()
+	if err != nil {
+		return nil, nil, err
+	}
+	injects, err := sql_wrapper.GetAllInjects()
+	if err != nil {
+		return nil, nil, err
+	}
+	comp, err := sql_wrapper.GetCompetition()
+	if err != nil {
+		return nil, nil, err
+	}
+	subs, err := sql_wrapper.GetCountedInjectSubmissions(comp.InjectScoreRule, beforeRound)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	b := &TeamBreakdown{TeamID: team.ID, TeamName: team.Name, FrozenBefore: beforeRound}
+	svcIndex := make(map[int]int)
+	for _, s := range services {
+		svcIndex[s.ID] = len(b.Services)
+		b.Services = append(b.Services, BreakdownService{ServiceID: s.ID, Name: s.Name})
+	}
+	svcName := func(id int) string {
+		if i, ok := svcIndex[id]; ok {
+			return b.Services[i].Name
+		}
+		if id == 0 {
+			return ""
+		}
+		return "Service " + strconv.Itoa(id)
+	}
+
+	rounds := make(map[int]*BreakdownRound)
+	var detail *BreakdownRound
+	for _, e := range entries {
+		b.Total += e.Score
+		var rd *BreakdownRound
+		if e.Round > 0 {
+			if rounds[e.Round] == nil {
+				rounds[e.Round] = &BreakdownRound{Round: e.Round}
+			}
+			rd = rounds[e.Round]
+			rd.Total += e.Score
+		}
+		entry := BreakdownEntry{
+			Round:       e.Round,
+			Category:    e.Category,
+			Label:       scoreCategoryLabels[e.Category],
+			ServiceName: svcName(e.ServiceID),
+			Points:      e.Score,
+			Description: e.Description,
+			Timestamp:   e.Timestamp,
+		}
+		i, known := svcIndex[e.ServiceID]
+		switch e.Category {
+		case sql_wrapper.ScoreService:
+			b.Uptime += e.Score
+			if known {
+				b.Services[i].Checks++
+				b.Services[i].Points += e.Score
+				if e.IsUp {
+					b.Services[i].Up++
+				}
+			}
+			if rd != nil {
+				rd.Uptime += e.Score
+				if e.Round == drill {
+					rd.Checks = append(rd.Checks, BreakdownCheck{ServiceName: entry.ServiceName, IsUp: e.IsUp, Points: e.Score})
+				}
+			}
+			continue
+		case sql_wrapper.ScoreRescore, sql_wrapper.ScoreOverride:
+			b.Corrections += e.Score
+			if known {
+				b.Services[i].Corrections += e.Score
+			}
+			if rd != nil {
+				rd.Corrections += e.Score
+			}
+		case sql_wrapper.ScoreSLA:
+			b.SLA += e.Score
+		case sql_wrapper.ScoreRedTeam:
+			b.RedTeam += e.Score
+		default:
+			b.Adjustments += e.Score
+		}
+		if rd != nil && e.Category != sql_wrapper.ScoreRescore && e.Category != sql_wrapper.ScoreOverride {
+			rd.Other += e.Score
+		}
+		if rd != nil && e.Round == drill {
+			rd.Entries = append(rd.Entries, entry)
+		}
+		b.Adjusted = append(b.Adjusted, entry)
+	}
+	for _, rd := range rounds {
+		b.Rounds = append(b.Rounds, *rd)
+		if rd.Round == drill {
+			detail = rd
+		}
+	}
+	sort.Slice(b.Rounds, func(i, j int) bool { return b.Rounds[i].Round > b.Rounds[j].Round })
+
+	titles := make(map[string]string)
+	for _, inj := range injects {
+		titles[inj.InjectID] = inj.Title
+	}
+	for _, s := range subs {
+		if s.TeamID != team.ID {
+			continue
+		}
+		b.Injects = append(b.Injects, BreakdownInject{InjectID: s.InjectID, Title: titles[s.InjectID], Score: *s.Score, Notes: s.Notes})
+		b.InjectTotal += *s.Score
+	}
+	b.Total += b.InjectTotal
+	return b, detail, nil
+}
+
+// TeamFailure is the latest failed check of one of a team's services, with
+// its output filtered for the team.
+type TeamFailure struct {
+	ServiceName string
+	Round       int
+	Timestamp   string
+	RootCause   string
+	Output      string
+	// UpNow is set when the service's latest check passed
+	UpNow bool
+}
+
+// teamFailures returns the latest failure of each of a team's services.
+func teamFailures(teamID int) ([]TeamFailure, error) {
+	failures, err := sql_wrapper.GetLatestFailures(teamID)
+	if err != nil {
+		return nil, err
+	}
+	services, err := sql_wrapper.GetAllServices()
+	if err != nil {
+		return nil, err
+	}
+	// a team always sees its own services' latest status, freeze or not
+	latest, err := sql_wrapper.GetLatestStatuses(0)
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[int]string)
+	for _, s := range services {
+		names[s.ID] = s.Name
+	}
+	upNow := make(map[int]bool)
+	for _, ls := range latest {
+		if ls.TeamID == teamID {
+			upNow[ls.ServiceID] = ls.IsUp
+		}
+	}
+	var out []TeamFailure
+	for _, f := range failures {
+		name, ok := names[f.ServiceID]
+		if !ok {
+			// the service was removed
+			continue
+		}
+		out = append(out, TeamFailure{
+			ServiceName: name,
+			Round:       f.Round,
+			Timestamp:   f.Timestamp,
+			RootCause:   f.RootCause,
+			Output:      scoring.RedactForTeam(teamID, f.Output),
+			UpNow:       upNow[f.ServiceID],
+		})
+	}
+	return out, nil
+}
+
+// teamPage is the view model of templates/team.html.
+type teamPage struct {
+	Active     string
+	IsLoggedIn bool
+	IsAdmin    bool
+	UserName   string
+	// Teams lets admins switch between teams
+	Teams     []structures.Team
+	Breakdown *TeamBreakdown
+	// Round is the round drilled into, if any
+	Round    *BreakdownRound
+	Failures []TeamFailure
+}
+
+// HandleTeamPage shows a team where its points came from and why its
+// services last failed. Team members see their own team, during the final
+// freeze only up to the freeze; admins can pick any team with ?team_id= and
+// always see live scores. ?round= drills into a round.
+func HandleTeamPage(w http.ResponseWriter, r *http.Request) {
+	user, _ := r.Context().Value(CtxUserKey).(structures.User)
+	page := teamPage{Active: "team", IsLoggedIn: true, IsAdmin: user.Is_Admin, UserName: user.Name}
+
+	var team *structures.Team
+	if user.Is_Admin {
+		teams, err := sql_wrapper.GetAllTeams()
+		if err != nil {
+			http.Error(w, "failed to load teams", http.StatusInternalServerError)
+			log.Println("team page: teams error:", err)
+			return
+		}
+		page.Teams = teams
+		id, _ := strconv.Atoi(r.URL.Query().Get("team_id"))
+		for i := range teams {
+			if teams[i].ID == id || (id == 0 && team == nil) {
+				team = &teams[i]
+			}
+		}
+	} else if user.Subject != "" {
+		t, err := sql_wrapper.GetUserTeamBySubject(user.Subject)
+		if err != nil {
+			http.Error(w, "failed to load team", http.StatusInternalServerError)
+			log.Println("team page: team error:", err)
+			return
+		}
+		team = t
+	}
+
+	if team != nil {
+		frozenBefore := 0
+		if !user.Is_Admin {
+			comp, err := sql_wrapper.GetCompetition()
+			if err != nil {
+				http.Error(w, "failed to load competition", http.StatusInternalServerError)
+				log.Println("team page: competition error:", err)
+				return
+			}
+			before, frozen, err := freezeState(comp)
+			if err != nil {
+				http.Error(w, "failed to load rounds", http.StatusInternalServerError)
+				log.Println("team page: rounds error:", err)
+				return
+			}
+			if frozen {
+				frozenBefore = before
+			}
+		}
+		drill, _ := strconv.Atoi(r.URL.Query().Get("round"))
+		b, detail, err := buildTeamBreakdown(*team, frozenBefore, drill)
+		if err != nil {
+			http.Error(w, "failed to load scores", http.StatusInternalServerError)
+			log.Println("team page: scores error:", err)
+			return
+		}
+		page.Breakdown = b
+		page.Round = detail
+		if page.Failures, err = teamFailures(team.ID); err != nil {
+			http.Error(w, "failed to load check results", http.StatusInternalServerError)
+			log.Println("team page: failures error:", err)
+			return
+		}
+	}
+
+	tmpl, err := template.ParseFiles("templates/team.html")
+	if err != nil {
+		http.Error(w, "template parse error", http.StatusInternalServerError)
+		log.Println("team page: template parse error:", err)
+		return
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, page); err != nil {
+		log.Println("team page: template exec error:", err)
+		http.Error(w, "template exec error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_, _ = w.Write(buf.Bytes())
+}